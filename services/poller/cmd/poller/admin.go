@@ -0,0 +1,194 @@
+package main
+
+import (
+	subtlepkg "crypto/subtle"
+	encodingjson "encoding/json"
+	nethttppkg "net/http"
+	sortpkg "sort"
+	stringspkg "strings"
+	syncpkg "sync"
+)
+
+// adminServer serves the debugging endpoints on ADMIN_PORT, each behind the
+// ADMIN_TOKEN bearer token:
+//
+//	GET    /watches            the watch sets with their match counts
+//	POST   /watches            add a watch by hand
+//	DELETE /watches/{address}  remove one by hand (?type=from for a sender)
+//	GET    /status             progress, lag and Kafka connectivity
+//
+// Changes made here are ephemeral: the next reconcile with the API
+// (WATCH_REFRESH_INTERVAL, or the bootstrap after a restart) undoes them.
+type adminServer struct {
+	token            string
+	targets, senders *WatchSet
+	matches          *matchStats
+	health           *health
+	// kafka checks that a broker answers; nil without Kafka
+	kafka func() error
+}
+
+func (a *adminServer) handler() nethttppkg.Handler {
+	mux := nethttppkg.NewServeMux()
+	mux.HandleFunc("GET /watches", a.listWatches)
+	mux.HandleFunc("POST /watches", a.addWatch)
+	mux.HandleFunc("DELETE /watches/{address}", a.removeWatch)
+	mux.HandleFunc("GET /status", a.status)
+	return nethttppkg.HandlerFunc(func(w nethttppkg.ResponseWriter, r *nethttppkg.Request) {
+		token, ok := stringspkg.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtlepkg.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			nethttppkg.Error(w, "unauthorized", nethttppkg.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// adminWatch is a watch as GET /watches lists it.
+type adminWatch struct {
+	Address          string   `json:"address"`
+	Type             string   `json:"type"`
+	Methods          []string `json:"methods,omitempty"`
+	MinCostEth       string   `json:"minCostEth,omitempty"`
+	Matches          uint64   `json:"matches"`
+	LastMatchedBlock uint64   `json:"lastMatchedBlock,omitempty"`
+}
+
+func (a *adminServer) listWatches(w nethttppkg.ResponseWriter, r *nethttppkg.Request) {
+	out := []adminWatch{}
+	for _, s := range []struct {
+		kind string
+		set  *WatchSet
+	}{{watchContract, a.targets}, {watchFrom, a.senders}} {
+		for _, wt := range s.set.Watches() {
+			aw := adminWatch{Address: wt.Address, Type: s.kind, Methods: wt.Methods, MinCostEth: wt.MinCostEth}
+			if s.kind == watchContract {
+				aw.Matches, aw.LastMatchedBlock = a.matches.get(wt.Address)
+			}
+			out = append(out, aw)
+		}
+	}
+	sortpkg.Slice(out, func(i, j int) bool {
+		if out[i].Type != out[j].Type {
+			return out[i].Type < out[j].Type
+		}
+		return out[i].Address < out[j].Address
+	})
+	writeJSON(w, nethttppkg.StatusOK, map[string]any{"watches": out})
+}
+
+func (a *adminServer) addWatch(w nethttppkg.ResponseWriter, r *nethttppkg.Request) {
+	var req struct {
+		Address    string              `json:"address"`
+		Type       string              `json:"type"`
+		Methods    []string            `json:"methods"`
+		MinCostEth encodingjson.Number `json:"minCostEth"`
+	}
+	if err := encodingjson.NewDecoder(r.Body).Decode(&req); err != nil {
+		nethttppkg.Error(w, "malformed JSON: "+err.Error(), nethttppkg.StatusBadRequest)
+		return
+	}
+	addr, ok := normalizeAddress(req.Address)
+	if !ok {
+		nethttppkg.Error(w, "invalid address", nethttppkg.StatusBadRequest)
+		return
+	}
+	set := setFor(req.Type, a.targets, a.senders)
+	if set == nil {
+		nethttppkg.Error(w, "unknown watch type", nethttppkg.StatusBadRequest)
+		return
+	}
+	minCost, err := parseEthAmount(req.MinCostEth.String())
+	if err != nil {
+		nethttppkg.Error(w, "minCostEth: "+err.Error(), nethttppkg.StatusBadRequest)
+		return
+	}
+	if bad := set.AddWithMinCost(addr, minCost, req.Methods...); len(bad) > 0 {
+		logFor("admin").Warn("ignoring invalid method selectors", "address", addr, "methods", bad)
+	}
+	logFor("admin").Warn("watch added by hand, until the next reconcile with the API", "address", addr, "type", req.Type)
+	writeJSON(w, nethttppkg.StatusCreated, map[string]any{"address": stringspkg.ToLower(addr), "ephemeral": true})
+}
+
+func (a *adminServer) removeWatch(w nethttppkg.ResponseWriter, r *nethttppkg.Request) {
+	addr := stringspkg.ToLower(r.PathValue("address"))
+	typ := r.URL.Query().Get("type")
+	set := setFor(typ, a.targets, a.senders)
+	if set == nil {
+		nethttppkg.Error(w, "unknown watch type", nethttppkg.StatusBadRequest)
+		return
+	}
+	if !set.Contains(addr) {
+		nethttppkg.Error(w, "not watched", nethttppkg.StatusNotFound)
+		return
+	}
+	set.Remove(addr)
+	logFor("admin").Warn("watch removed by hand, until the next reconcile with the API", "address", addr, "type", typ)
+	writeJSON(w, nethttppkg.StatusOK, map[string]any{"address": addr, "ephemeral": true})
+}
+
+func (a *adminServer) status(w nethttppkg.ResponseWriter, r *nethttppkg.Request) {
+	kafka := map[string]any{"enabled": a.kafka != nil}
+	if a.kafka != nil {
+		err := a.kafka()
+		kafka["connected"] = err == nil
+		if err != nil {
+			kafka["error"] = err.Error()
+		}
+	}
+	writeJSON(w, nethttppkg.StatusOK, map[string]any{
+		"lastBlock": a.health.last.Load(),
+		"tip":       a.health.tip.Load(),
+		"lag":       a.health.lag.Load(),
+		"contracts": a.targets.Len(),
+		"senders":   a.senders.Len(),
+		"kafka":     kafka,
+	})
+}
+
+func writeJSON(w nethttppkg.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = encodingjson.NewEncoder(w).Encode(v)
+}
+
+// matchStats counts the events emitted for each watched contract, and the
+// last block that had one, for GET /watches. A nil matchStats counts
+// nothing.
+type matchStats struct {
+	mu    syncpkg.Mutex
+	stats map[string]contractMatches
+}
+
+type contractMatches struct {
+	count     uint64
+	lastBlock uint64
+}
+
+func newMatchStats() *matchStats {
+	return &matchStats{stats: make(map[string]contractMatches)}
+}
+
+func (m *matchStats) record(contract string, block uint64) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	contract = stringspkg.ToLower(contract)
+	s := m.stats[contract]
+	s.count++
+	s.lastBlock = max(s.lastBlock, block)
+	m.stats[contract] = s
+}
+
+func (m *matchStats) get(contract string) (count, lastBlock uint64) {
+	if m == nil {
+		return 0, 0
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.stats[stringspkg.ToLower(contract)]
+	return s.count, s.lastBlock
+}
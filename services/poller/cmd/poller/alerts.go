@@ -0,0 +1,209 @@
+package main
+
+import (
+	contextpkg "context"
+	encodingjson "encoding/json"
+	mathbig "math/big"
+	nethttppkg "net/http"
+	stringspkg "strings"
+	syncpkg "sync"
+
+	"github.com/IBM/sarama"
+)
+
+// spikeAlerter keeps a rolling average of each watched contract's effective
+// gas price over its last window txs, and raises a gasSpike alert when a tx
+// pays more than multiplier times that average. Alerts go to topic, to
+// webhook, or both.
+type spikeAlerter struct {
+	multiplier float64
+	window     int
+	// minSamples is how many txs a contract needs before its average is
+	// trusted as a baseline
+	minSamples int
+
+	pub   *publisher
+	topic string
+	// webhook posts are queued for a goroutine of their own, as its
+	// retries must not hold up the poll loop
+	webhook      *WebhookSink
+	webhookQueue chan []byte
+	webhookDone  chan struct{}
+
+	mu     syncpkg.Mutex
+	prices map[string]*priceWindow
+}
+
+func newSpikeAlerter(multiplier float64, window, minSamples int) *spikeAlerter {
+	return &spikeAlerter{
+		multiplier: multiplier,
+		window:     window,
+		minSamples: min(minSamples, window),
+		prices:     make(map[string]*priceWindow),
+	}
+}
+
+// priceWindow is a ring of a contract's most recent gas prices in wei, with
+// their running sum.
+type priceWindow struct {
+	samples []*mathbig.Int
+	next    int
+	sum     *mathbig.Int
+}
+
+func (w *priceWindow) add(price *mathbig.Int, size int) {
+	if len(w.samples) < size {
+		w.samples = append(w.samples, price)
+	} else {
+		w.sum.Sub(w.sum, w.samples[w.next])
+		w.samples[w.next] = price
+		w.next = (w.next + 1) % size
+	}
+	w.sum.Add(w.sum, price)
+}
+
+// gasSpikeAlert is sent for a tx whose effective gas price exceeds its
+// contract's baseline by the configured multiple.
+type gasSpikeAlert struct {
+	Type                 string  `json:"type"`
+	TenantID             string  `json:"tenantId"`
+	ChainID              uint64  `json:"chainId"`
+	Contract             string  `json:"contract"`
+	TxHash               string  `json:"txHash"`
+	EventID              string  `json:"eventId"`
+	BlockNumber          uint64  `json:"blockNumber"`
+	Timestamp            uint64  `json:"timestamp"`
+	ObservedGasPriceWei  string  `json:"observedGasPriceWei"`
+	BaselineGasPriceWei  string  `json:"baselineGasPriceWei"`
+	ObservedGasPriceGwei float64 `json:"observedGasPriceGwei"`
+	BaselineGasPriceGwei float64 `json:"baselineGasPriceGwei"`
+	Ratio                float64 `json:"ratio"`
+	Multiplier           float64 `json:"multiplier"`
+	Samples              int     `json:"samples"`
+}
+
+// observe records ev's gas price in its contract's window, first checking
+// it against the window's average, and sends an alert if it is a spike.
+// Events without a receipt have no price and are ignored.
+func (a *spikeAlerter) observe(ctx contextpkg.Context, ev *GasEvent) {
+	price, ok := new(mathbig.Int).SetString(ev.EffectiveGasPriceWei, 10)
+	if !ok {
+		return
+	}
+	alert := a.check(ev, price)
+	if alert == nil {
+		return
+	}
+	gasSpikes.WithLabelValues(ev.Contract).Inc()
+	logFor("alerts").Warn("gas price spike", "contract", ev.Contract, "txHash", ev.TxHash,
+		"observedGwei", alert.ObservedGasPriceGwei, "baselineGwei", alert.BaselineGasPriceGwei, "ratio", alert.Ratio)
+	value, _ := encodingjson.Marshal(alert)
+	if a.topic != "" {
+		err := a.pub.send(ctx, &sarama.ProducerMessage{
+			Topic:   a.topic,
+			Key:     sarama.StringEncoder(ev.TenantID + ":" + ev.Contract),
+			Value:   sarama.ByteEncoder(value),
+			Headers: messageHeaders{tenant: ev.TenantID, chainID: ev.ChainID, contract: ev.Contract}.records(),
+		})
+		if err != nil {
+			logFor("alerts").Error("send gas spike alert", "topic", a.topic, "err", err)
+		}
+	}
+	if a.webhook != nil {
+		select {
+		case a.webhookQueue <- value:
+		default:
+			logFor("alerts").Error("alert webhook queue full, dropping alert", "contract", ev.Contract, "txHash", ev.TxHash)
+		}
+	}
+}
+
+// startWebhook sends alerts to sink as well, queueing up to queue of them
+// while it is slow or retrying.
+func (a *spikeAlerter) startWebhook(sink *WebhookSink, queue int) {
+	a.webhook = sink
+	a.webhookQueue = make(chan []byte, queue)
+	a.webhookDone = make(chan struct{})
+	go func() {
+		defer close(a.webhookDone)
+		for value := range a.webhookQueue {
+			_ = sink.Emit(contextpkg.Background(), "", value)
+		}
+	}()
+}
+
+// close sends the alerts still queued for the webhook.
+func (a *spikeAlerter) close() {
+	if a.webhook == nil {
+		return
+	}
+	close(a.webhookQueue)
+	<-a.webhookDone
+	_ = a.webhook.Close()
+}
+
+// check adds price to ev's contract window and returns the alert to send,
+// or nil. The tx is compared with the average before it joins the window.
+func (a *spikeAlerter) check(ev *GasEvent, price *mathbig.Int) *gasSpikeAlert {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	w := a.prices[ev.Contract]
+	if w == nil {
+		w = &priceWindow{sum: new(mathbig.Int)}
+		a.prices[ev.Contract] = w
+	}
+	defer w.add(price, a.window)
+	n := len(w.samples)
+	if n < a.minSamples || w.sum.Sign() == 0 {
+		return nil
+	}
+	baseline := new(mathbig.Float).Quo(new(mathbig.Float).SetInt(w.sum), new(mathbig.Float).SetInt64(int64(n)))
+	ratio, _ := new(mathbig.Float).Quo(new(mathbig.Float).SetInt(price), baseline).Float64()
+	if ratio <= a.multiplier {
+		return nil
+	}
+	baselineWei, _ := baseline.Int(nil)
+	gweiDiv := mathbig.NewFloat(1e9)
+	observedGwei, _ := new(mathbig.Float).Quo(new(mathbig.Float).SetInt(price), gweiDiv).Float64()
+	baselineGwei, _ := new(mathbig.Float).Quo(baseline, gweiDiv).Float64()
+	return &gasSpikeAlert{
+		Type:                 "gasSpike",
+		TenantID:             ev.TenantID,
+		ChainID:              ev.ChainID,
+		Contract:             ev.Contract,
+		TxHash:               ev.TxHash,
+		EventID:              ev.EventID,
+		BlockNumber:          ev.BlockNumber,
+		Timestamp:            ev.Timestamp,
+		ObservedGasPriceWei:  price.String(),
+		BaselineGasPriceWei:  baselineWei.String(),
+		ObservedGasPriceGwei: observedGwei,
+		BaselineGasPriceGwei: baselineGwei,
+		Ratio:                ratio,
+		Multiplier:           a.multiplier,
+		Samples:              n,
+	}
+}
+
+// reset forgets contract's baseline, or every contract's when it is empty,
+// so alerting restarts from fresh samples, e.g. after an expected change
+// in its gas use.
+func (a *spikeAlerter) reset(contract string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if contract == "" {
+		clear(a.prices)
+		return
+	}
+	delete(a.prices, stringspkg.ToLower(contract))
+}
+
+// register adds POST /alerts/reset[?contract=0x...] to mux.
+func (a *spikeAlerter) register(mux *nethttppkg.ServeMux) {
+	mux.HandleFunc("POST /alerts/reset", func(w nethttppkg.ResponseWriter, r *nethttppkg.Request) {
+		contract := r.URL.Query().Get("contract")
+		a.reset(contract)
+		logFor("alerts").Info("gas spike baseline reset", "contract", contract)
+		w.WriteHeader(nethttppkg.StatusNoContent)
+	})
+}
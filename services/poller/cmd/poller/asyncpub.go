@@ -0,0 +1,77 @@
+package main
+
+import (
+	"github.com/IBM/sarama"
+)
+
+// asyncSender feeds messages to an async producer so a block's events are
+// in flight together instead of paying a Kafka round trip each. At most
+// cap(inflight) messages are unacknowledged at once; beyond that enqueue
+// blocks, pushing back on the poll loop rather than dropping events.
+type asyncSender struct {
+	pub      *publisher
+	producer sarama.AsyncProducer
+	inflight chan struct{}
+	drained  chan struct{}
+}
+
+func newAsyncSender(pub *publisher, producer sarama.AsyncProducer, maxInflight int) *asyncSender {
+	if maxInflight < 1 {
+		maxInflight = 1
+	}
+	a := &asyncSender{
+		pub:      pub,
+		producer: producer,
+		inflight: make(chan struct{}, maxInflight),
+		drained:  make(chan struct{}),
+	}
+	kafkaInflightLimit.Set(float64(maxInflight))
+	go a.drain()
+	return a
+}
+
+func (a *asyncSender) enqueue(msg *sarama.ProducerMessage) *delivery {
+	d := &delivery{done: make(chan error, 1)}
+	msg.Metadata = d
+	a.inflight <- struct{}{}
+	kafkaInflight.Inc()
+	a.producer.Input() <- msg
+	return d
+}
+
+// drain settles every delivery as the producer reports it. Sarama has
+// already retried a message by the time it shows up on Errors.
+func (a *asyncSender) drain() {
+	defer close(a.drained)
+	successes, errs := a.producer.Successes(), a.producer.Errors()
+	for successes != nil || errs != nil {
+		select {
+		case msg, ok := <-successes:
+			if !ok {
+				successes = nil
+				continue
+			}
+			kafkaSent.Inc()
+			logFor("kafka").Debug("kafka sent", "topic", msg.Topic, "partition", msg.Partition, "offset", msg.Offset)
+			<-a.inflight
+			kafkaInflight.Dec()
+			msg.Metadata.(*delivery).done <- nil
+		case perr, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			kafkaSendErrors.Inc()
+			logFor("kafka").Warn("kafka send failed", "topic", perr.Msg.Topic, "err", perr.Err)
+			<-a.inflight
+			kafkaInflight.Dec()
+			perr.Msg.Metadata.(*delivery).done <- a.pub.giveUp(perr.Msg, perr.Err)
+		}
+	}
+}
+
+// close flushes buffered messages and waits for all outcomes.
+func (a *asyncSender) close() {
+	a.producer.AsyncClose()
+	<-a.drained
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	bytespkg "bytes"
+	contextpkg "context"
+	_ "embed"
+	binarypkg "encoding/binary"
+	encodingjson "encoding/json"
+	fmtpkg "fmt"
+	nethttppkg "net/http"
+	urlpkg "net/url"
+	stringspkg "strings"
+	timepkg "time"
+
+	"github.com/linkedin/goavro/v2"
+)
+
+// gasEventSchema is the Avro schema of GasEvent and must list every field
+// it can set: encoding an event with an unknown field fails. Fields not on
+// every event are nullable with a null default, so adding one keeps the
+// schema backward compatible.
+//
+//go:embed gas_event.avsc
+var gasEventSchema string
+
+// avroEncoder encodes gas events for OUTPUT_FORMAT=avro in the Confluent
+// wire format: a zero magic byte, the 4-byte schema ID, then the Avro
+// binary.
+type avroEncoder struct {
+	codec    *goavro.Codec
+	schemaID uint32
+}
+
+// newAvroEncoder registers gasEventSchema under subject with the schema
+// registry at registryURL, which returns the existing ID when the schema
+// is already registered.
+func newAvroEncoder(ctx contextpkg.Context, registryURL, subject string) (*avroEncoder, error) {
+	// standard JSON, so the JSON events already built can be converted
+	// without wrapping union values
+	codec, err := goavro.NewCodecForStandardJSON(gasEventSchema)
+	if err != nil {
+		return nil, fmtpkg.Errorf("gas event schema: %w", err)
+	}
+	id, err := registerSchema(ctx, registryURL, subject, "AVRO", codec.Schema())
+	if err != nil {
+		return nil, err
+	}
+	return &avroEncoder{codec: codec, schemaID: id}, nil
+}
+
+// encode converts an event's JSON to the wire format.
+func (e *avroEncoder) encode(value []byte) ([]byte, error) {
+	native, _, err := e.codec.NativeFromTextual(value)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 5, 5+len(value))
+	binarypkg.BigEndian.PutUint32(out[1:], e.schemaID)
+	return e.codec.BinaryFromNative(out, native)
+}
+
+// registerSchema registers schema, of schemaType AVRO or PROTOBUF, under
+// subject and returns its ID.
+func registerSchema(ctx contextpkg.Context, registryURL, subject, schemaType, schema string) (uint32, error) {
+	body, _ := encodingjson.Marshal(map[string]string{"schemaType": schemaType, "schema": schema})
+	endpoint := stringspkg.TrimRight(registryURL, "/") + "/subjects/" + urlpkg.PathEscape(subject) + "/versions"
+	ctx, cancel := contextpkg.WithTimeout(ctx, 10*timepkg.Second)
+	defer cancel()
+	req, err := nethttppkg.NewRequestWithContext(ctx, "POST", endpoint, bytespkg.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+	resp, err := nethttppkg.DefaultClient.Do(req)
+	if err != nil {
+		return 0, fmtpkg.Errorf("register schema: %w", err)
+	}
+	defer resp.Body.Close()
+	var out struct {
+		ID      uint32 `json:"id"`
+		Message string `json:"message"`
+	}
+	_ = encodingjson.NewDecoder(resp.Body).Decode(&out)
+	if resp.StatusCode != nethttppkg.StatusOK {
+		return 0, fmtpkg.Errorf("register schema for %s: status %d: %s", subject, resp.StatusCode, out.Message)
+	}
+	return out.ID, nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	contextpkg "context"
+	encodingjson "encoding/json"
+	errorspkg "errors"
+	fmtpkg "fmt"
+	iofspkg "io/fs"
+	mathbig "math/big"
+	ospkg "os"
+	stringspkg "strings"
+	syncpkg "sync"
+	timepkg "time"
+
+	typespkg "github.com/ethereum/go-ethereum/core/types"
+)
+
+// backfillJob replays a historical block range for one contract. Next is
+// the first block not yet processed and is persisted so an interrupted job
+// resumes where it stopped.
+type backfillJob struct {
+	ID       string `json:"id"`
+	Contract string `json:"contract"`
+	From     uint64 `json:"from"`
+	To       uint64 `json:"to"`
+	Next     uint64 `json:"next"`
+}
+
+// backfiller runs backfill jobs alongside live tailing. Jobs share the
+// poller's RPC client and producer but never touch the live cursor.
+type backfiller struct {
+	p          *poller
+	statePath  string
+	blockDelay timepkg.Duration
+
+	mu   syncpkg.Mutex
+	jobs map[string]*backfillJob
+	wg   syncpkg.WaitGroup
+}
+
+func newBackfiller(p *poller, statePath string, blockDelay timepkg.Duration) *backfiller {
+	return &backfiller{p: p, statePath: statePath, blockDelay: blockDelay, jobs: make(map[string]*backfillJob)}
+}
+
+// resume restarts every unfinished job found in the state file.
+func (b *backfiller) resume(ctx contextpkg.Context) error {
+	data, err := ospkg.ReadFile(b.statePath)
+	if errorspkg.Is(err, iofspkg.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var jobs []*backfillJob
+	if err := encodingjson.Unmarshal(data, &jobs); err != nil {
+		return fmtpkg.Errorf("parse %s: %w", b.statePath, err)
+	}
+	for _, j := range jobs {
+		logFor("backfill").Info("resuming backfill", "job", j.ID, "block", j.Next)
+		b.start(ctx, j)
+	}
+	return nil
+}
+
+// Start queues a backfill of contract over [from, to]. A job already running
+// for the same range is left alone.
+func (b *backfiller) Start(ctx contextpkg.Context, contract string, from, to uint64) error {
+	if from > to {
+		return fmtpkg.Errorf("backfill range %d-%d is empty", from, to)
+	}
+	contract = stringspkg.ToLower(contract)
+	j := &backfillJob{
+		ID:       fmtpkg.Sprintf("%s:%d-%d", contract, from, to),
+		Contract: contract,
+		From:     from,
+		To:       to,
+		Next:     from,
+	}
+	b.mu.Lock()
+	_, running := b.jobs[j.ID]
+	b.mu.Unlock()
+	if running {
+		logFor("backfill").Info("backfill already running", "job", j.ID)
+		return nil
+	}
+	logFor("backfill").Info("starting backfill", "job", j.ID, "from", from, "to", to, "blocks", to-from+1)
+	b.start(ctx, j)
+	return nil
+}
+
+func (b *backfiller) start(ctx contextpkg.Context, j *backfillJob) {
+	b.mu.Lock()
+	b.jobs[j.ID] = j
+	b.mu.Unlock()
+	b.save()
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		b.run(ctx, j)
+	}()
+}
+
+// Wait blocks until every running job has stopped.
+func (b *backfiller) Wait() { b.wg.Wait() }
+
+func (b *backfiller) run(ctx contextpkg.Context, j *backfillJob) {
+	work := contextpkg.WithoutCancel(ctx)
+	lastReport := timepkg.Now()
+	errWait := backoffTimer{b: b.p.errBackoff}
+	for ctx.Err() == nil {
+		b.mu.Lock()
+		bn := j.Next
+		b.mu.Unlock()
+		if bn > j.To {
+			break
+		}
+		blk, err := withRetry(work, b.p.rpcRetry, func() (*typespkg.Block, error) {
+			return b.p.client.BlockByNumber(work, new(mathbig.Int).SetUint64(bn))
+		})
+		if err == nil {
+			_, err = b.p.processBlock(work, blk, blockOpts{source: "backfill", contract: j.Contract})
+		}
+		if err != nil {
+			logFor("backfill").Warn("backfill block failed", "job", j.ID, "block", bn, "err", err)
+			errWait.wait(ctx)
+			continue
+		}
+		errWait.reset()
+		b.mu.Lock()
+		j.Next = bn + 1
+		b.mu.Unlock()
+		b.save()
+		if timepkg.Since(lastReport) >= 30*timepkg.Second {
+			done := bn - j.From + 1
+			logFor("backfill").Info("backfill progress", "job", j.ID, "block", bn, "done", done, "total", j.To-j.From+1, "remaining", j.To-bn)
+			lastReport = timepkg.Now()
+		}
+		sleepCtx(ctx, b.blockDelay)
+	}
+	if ctx.Err() != nil {
+		logFor("backfill").Info("backfill stopped, will resume on restart", "job", j.ID, "block", j.Next)
+		return
+	}
+	backfillsCompleted.Inc()
+	logFor("backfill").Info("backfill complete", "job", j.ID, "contract", j.Contract, "blocks", j.To-j.From+1)
+	b.mu.Lock()
+	delete(b.jobs, j.ID)
+	b.mu.Unlock()
+	b.save()
+}
+
+// save persists every unfinished job.
+func (b *backfiller) save() {
+	b.mu.Lock()
+	jobs := make([]*backfillJob, 0, len(b.jobs))
+	for _, j := range b.jobs {
+		cp := *j
+		jobs = append(jobs, &cp)
+	}
+	b.mu.Unlock()
+	data, _ := encodingjson.Marshal(jobs)
+	if err := writeFileAtomic(b.statePath, data); err != nil {
+		logFor("backfill").Error("save backfill state", "err", err)
+	}
+}
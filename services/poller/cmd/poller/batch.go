@@ -0,0 +1,143 @@
+package main
+
+import (
+	contextpkg "context"
+	encodingjson "encoding/json"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	typespkg "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// batchCall sends elems as one JSON-RPC batch to the active endpoint. The
+// returned error is for the batch as a whole; each element carries its own.
+func (f *failoverClient) batchCall(ctx contextpkg.Context, kind string, elems []rpc.BatchElem) error {
+	method := "batch:" + elems[0].Method
+	ep, err := f.ready(ctx, len(elems))
+	if err != nil {
+		return err
+	}
+	err = f.withTimeout(ctx, ep, method, kind, func(ctx contextpkg.Context) error {
+		return ep.client.Client().BatchCallContext(ctx, elems)
+	})
+	if err == nil {
+		// a provider may rate limit individual elements of a batch
+		for _, e := range elems {
+			if isRateLimited(e.Error) {
+				err = e.Error
+				break
+			}
+		}
+	}
+	f.report(ep, err)
+	return err
+}
+
+// BlocksByNumber fetches full blocks in one batch. errs[i] is set for every
+// block that could not be fetched, ethereum.NotFound if the node does not
+// have it yet.
+func (f *failoverClient) BlocksByNumber(ctx contextpkg.Context, numbers []uint64) ([]*typespkg.Block, []error) {
+	raws := make([]encodingjson.RawMessage, len(numbers))
+	elems := make([]rpc.BatchElem, len(numbers))
+	for i, n := range numbers {
+		elems[i] = rpc.BatchElem{Method: "eth_getBlockByNumber", Args: []any{hexutil.EncodeUint64(n), true}, Result: &raws[i]}
+	}
+	blocks := make([]*typespkg.Block, len(numbers))
+	errs := make([]error, len(numbers))
+	if err := f.batchCall(ctx, callBlock, elems); err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return blocks, errs
+	}
+	for i, e := range elems {
+		if e.Error != nil {
+			errs[i] = e.Error
+			continue
+		}
+		blocks[i], errs[i] = decodeBlock(raws[i])
+	}
+	return blocks, errs
+}
+
+// decodeBlock turns an eth_getBlockByNumber result with full transactions
+// into a block. Uncles are not fetched; nothing here needs them and the
+// block hash comes from the header alone.
+func decodeBlock(raw encodingjson.RawMessage) (*typespkg.Block, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, ethereum.NotFound
+	}
+	var head typespkg.Header
+	if err := encodingjson.Unmarshal(raw, &head); err != nil {
+		return nil, err
+	}
+	var body struct {
+		Transactions []*typespkg.Transaction `json:"transactions"`
+		Withdrawals  []*typespkg.Withdrawal  `json:"withdrawals"`
+	}
+	if err := encodingjson.Unmarshal(raw, &body); err != nil {
+		return nil, err
+	}
+	return typespkg.NewBlockWithHeader(&head).WithBody(typespkg.Body{
+		Transactions: body.Transactions,
+		Withdrawals:  body.Withdrawals,
+	}), nil
+}
+
+// TransactionReceipts fetches receipts in one batch, with per-receipt errors
+// as for BlocksByNumber.
+func (f *failoverClient) TransactionReceipts(ctx contextpkg.Context, hashes []common.Hash) ([]*typespkg.Receipt, []error) {
+	receipts := make([]*typespkg.Receipt, len(hashes))
+	elems := make([]rpc.BatchElem, len(hashes))
+	for i, h := range hashes {
+		elems[i] = rpc.BatchElem{Method: "eth_getTransactionReceipt", Args: []any{h}, Result: &receipts[i]}
+	}
+	errs := make([]error, len(hashes))
+	if err := f.batchCall(ctx, callReceipt, elems); err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return receipts, errs
+	}
+	for i, e := range elems {
+		switch {
+		case e.Error != nil:
+			errs[i] = e.Error
+		case receipts[i] == nil:
+			errs[i] = ethereum.NotFound
+		}
+	}
+	return receipts, errs
+}
+
+// batchRetry fetches keys size at a time, retrying only the keys that
+// failed, with b's backoff between rounds. It returns what was fetched and,
+// for keys it gave up on, their last error.
+func batchRetry[K comparable, V any](ctx contextpkg.Context, b backoff, size int, keys []K, fetch func([]K) ([]V, []error)) (map[K]V, map[K]error) {
+	got := make(map[K]V, len(keys))
+	failed := make(map[K]error)
+	pending := keys
+	for attempt := 0; len(pending) > 0; attempt++ {
+		var retryKeys []K
+		for start := 0; start < len(pending); start += size {
+			chunk := pending[start:min(start+size, len(pending))]
+			vals, errs := fetch(chunk)
+			for i, k := range chunk {
+				if errs[i] != nil {
+					failed[k] = errs[i]
+					retryKeys = append(retryKeys, k)
+					continue
+				}
+				delete(failed, k)
+				got[k] = vals[i]
+			}
+		}
+		pending = retryKeys
+		if len(pending) == 0 || attempt >= b.attempts-1 || !sleepCtx(ctx, b.delay(attempt)) {
+			break
+		}
+	}
+	return got, failed
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	contextpkg "context"
+	encodingjson "encoding/json"
+	fmtpkg "fmt"
+	iopkg "io"
+	nethttppkg "net/http"
+	urlpkg "net/url"
+	strconvpkg "strconv"
+	stringspkg "strings"
+	timepkg "time"
+)
+
+// watchesClient lists a tenant's watches from the API, following its
+// pagination: a nextCursor in the response is passed back as ?cursor=, and
+// hasMore or totalPages ask for the next ?page=. A response with none of
+// them is the last page, so an API that does not paginate is read in one
+// request.
+type watchesClient struct {
+	base   string
+	tenant string
+	// pageSize is sent as ?limit=
+	pageSize int
+	// maxPages and timeout stop a buggy API from paging forever
+	maxPages int
+	timeout  timepkg.Duration
+}
+
+// watchesPage is one response of GET /internal/onchain/watches.
+type watchesPage struct {
+	Items []struct {
+		Contract   string              `json:"contract"`
+		Address    string              `json:"address"`
+		Type       string              `json:"type"`
+		Methods    []string            `json:"methods"`
+		MinCostEth encodingjson.Number `json:"minCostEth"`
+	} `json:"items"`
+	NextCursor string `json:"nextCursor"`
+	HasMore    bool   `json:"hasMore"`
+	TotalPages int    `json:"totalPages"`
+}
+
+// fetch returns every page of the tenant's watches. Anything other than a
+// 200 with a parseable body, on any page, is an error.
+func (c *watchesClient) fetch(ctx contextpkg.Context) ([]watch, error) {
+	ctx, cancel := contextpkg.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	var (
+		watches []watch
+		cursor  string
+	)
+	page := 1
+	for ; ; page++ {
+		if page > c.maxPages {
+			return nil, fmtpkg.Errorf("GET watches: still more after %d pages (WATCHES_MAX_PAGES)", c.maxPages)
+		}
+		out, err := c.page(ctx, cursor, page)
+		if err != nil {
+			return nil, err
+		}
+		for _, it := range out.Items {
+			addr := it.Address
+			if addr == "" {
+				addr = it.Contract
+			}
+			watches = append(watches, watch{Address: addr, Type: it.Type, Methods: it.Methods, MinCostEth: it.MinCostEth.String()})
+		}
+		if out.NextCursor != "" {
+			if out.NextCursor == cursor {
+				return nil, fmtpkg.Errorf("GET watches: page %d repeats cursor %q", page, cursor)
+			}
+			cursor = out.NextCursor
+			continue
+		}
+		// an empty page ends the listing even if the API claims more
+		if cursor == "" && len(out.Items) > 0 && (out.HasMore || page < out.TotalPages) {
+			continue
+		}
+		break
+	}
+	logFor("watches").Info("fetched watches", "watches", len(watches), "pages", page)
+	return watches, nil
+}
+
+func (c *watchesClient) page(ctx contextpkg.Context, cursor string, page int) (*watchesPage, error) {
+	q := urlpkg.Values{"tenantId": {c.tenant}, "limit": {strconvpkg.Itoa(c.pageSize)}}
+	if cursor != "" {
+		q.Set("cursor", cursor)
+	} else {
+		q.Set("page", strconvpkg.Itoa(page))
+	}
+	req, err := nethttppkg.NewRequestWithContext(ctx, "GET", c.base+"/internal/onchain/watches?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := nethttppkg.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := iopkg.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != nethttppkg.StatusOK {
+		return nil, fmtpkg.Errorf("GET watches page %d: status %d: %.200s", page, resp.StatusCode, body)
+	}
+	var out watchesPage
+	if err := encodingjson.Unmarshal(body, &out); err != nil {
+		return nil, fmtpkg.Errorf("GET watches page %d: decode: %w", page, err)
+	}
+	return &out, nil
+}
+
+// bootstrapWatches loads the initial watch set, retrying with backoff until
+// deadline. It returns an error only if every attempt failed. Watches
+// already in the sets, loaded from the watch cache, that the API no longer
+// has are removed.
+func bootstrapWatches(ctx contextpkg.Context, api *watchesClient, targets, senders *WatchSet, deadline timepkg.Duration) error {
+	ctx, cancel := contextpkg.WithTimeout(ctx, deadline)
+	defer cancel()
+	b := backoff{base: timepkg.Second, max: 30 * timepkg.Second}
+	for attempt := 0; ; attempt++ {
+		watches, err := api.fetch(ctx)
+		if err == nil {
+			sinceTargets, sinceSenders := targets.Version(), senders.Version()
+			want := addWatches(watches, targets, senders)
+			for _, s := range []struct {
+				kind  string
+				set   *WatchSet
+				since uint64
+			}{{watchContract, targets, sinceTargets}, {watchFrom, senders, sinceSenders}} {
+				if _, removed := s.set.Sync(want[s.set], s.since); len(removed) > 0 {
+					logFor("watches").Info("dropped cached watches the API no longer has", "type", s.kind, "removed", removed)
+				}
+			}
+			logFor("watches").Info("loaded watches", "watches", len(watches), "contracts", targets.Len(), "senders", senders.Len())
+			return nil
+		}
+		logFor("watches").Warn("bootstrap watches failed", "attempt", attempt+1, "err", err)
+		if !sleepCtx(ctx, b.delay(attempt)) {
+			return fmtpkg.Errorf("gave up after %d attempts within %s: %w", attempt+1, deadline, err)
+		}
+	}
+}
+
+// addWatches adds watches to targets or senders by type, warning about and
+// skipping the invalid ones, and returns those added by set and lowercased
+// address.
+func addWatches(watches []watch, targets, senders *WatchSet) map[*WatchSet]map[string]watch {
+	added := map[*WatchSet]map[string]watch{targets: {}, senders: {}}
+	for _, w := range watches {
+		addr, ok := normalizeAddress(w.Address)
+		if !ok {
+			invalidWatches.Inc()
+			logFor("watches").Warn("invalid watch address, ignoring", "address", w.Address)
+			continue
+		}
+		w.Address = addr
+		set := setFor(w.Type, targets, senders)
+		if set == nil {
+			logFor("watches").Warn("unknown watch type, ignoring", "address", w.Address, "type", w.Type)
+			continue
+		}
+		minCost, err := parseEthAmount(w.MinCostEth)
+		if err != nil {
+			logFor("watches").Warn("invalid minCostEth, ignoring watch", "address", w.Address, "err", err)
+			continue
+		}
+		if bad := set.AddWithMinCost(w.Address, minCost, w.Methods...); len(bad) > 0 {
+			logFor("watches").Warn("ignoring invalid method selectors", "address", w.Address, "methods", bad)
+		}
+		added[set][stringspkg.ToLower(addr)] = w
+	}
+	return added
+}
+
+// reconcileWatches re-fetches the tenant's watches and brings targets and
+// senders in line with them, in case a watch request was missed. Changes
+// watch requests made while the fetch was in flight are kept. On error the
+// sets are left as they are.
+func reconcileWatches(ctx contextpkg.Context, api *watchesClient, targets, senders *WatchSet) error {
+	sinceTargets, sinceSenders := targets.Version(), senders.Version()
+	watches, err := api.fetch(ctx)
+	if err != nil {
+		return err
+	}
+	want := map[*WatchSet]map[string]watch{targets: {}, senders: {}}
+	for _, w := range watches {
+		// bootstrap already warned about the invalid ones
+		addr, ok := normalizeAddress(w.Address)
+		set := setFor(w.Type, targets, senders)
+		if _, err := parseEthAmount(w.MinCostEth); !ok || set == nil || err != nil {
+			continue
+		}
+		want[set][stringspkg.ToLower(addr)] = w
+	}
+	for _, s := range []struct {
+		kind  string
+		set   *WatchSet
+		since uint64
+	}{{watchContract, targets, sinceTargets}, {watchFrom, senders, sinceSenders}} {
+		added, removed := s.set.Sync(want[s.set], s.since)
+		if len(added) > 0 || len(removed) > 0 {
+			logFor("watches").Warn("watch set drifted from the API, reconciled", "type", s.kind, "added", added, "removed", removed)
+		}
+	}
+	return nil
+}
+
+// refreshWatches reconciles the watch sets with the API every interval
+// until ctx is done. While the API is down it retries with backoff, keeping
+// the current sets.
+func refreshWatches(ctx contextpkg.Context, api *watchesClient, targets, senders *WatchSet, every timepkg.Duration) {
+	failures := backoffTimer{b: backoff{base: 5 * timepkg.Second, max: every}}
+	for sleepCtx(ctx, every) {
+		for {
+			err := reconcileWatches(ctx, api, targets, senders)
+			if err == nil {
+				failures.reset()
+				break
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			logFor("watches").Warn("watch refresh failed, keeping current watches", "err", err)
+			if !failures.wait(ctx) {
+				return
+			}
+		}
+	}
+}
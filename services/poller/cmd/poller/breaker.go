@@ -0,0 +1,138 @@
+package main
+
+import (
+	contextpkg "context"
+	errorspkg "errors"
+	syncpkg "sync"
+	timepkg "time"
+
+	ethereum "github.com/ethereum/go-ethereum"
+)
+
+// breakerState is the state of the RPC circuit breaker.
+type breakerState int32
+
+const (
+	circuitClosed breakerState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case circuitOpen:
+		return "open"
+	case circuitHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+// errCircuitOpen is returned instead of making a call while the breaker is
+// open.
+var errCircuitOpen = errorspkg.New("rpc circuit open")
+
+// isRPCFailure reports whether err means the RPC side is unhealthy. NotFound
+// and an unsupported method are answers, a rate limit has its own pause and
+// a cancelled context is our own doing.
+func isRPCFailure(err error) bool {
+	return err != nil &&
+		!errorspkg.Is(err, ethereum.NotFound) &&
+		!isMethodNotFound(err) &&
+		!isRateLimited(err) &&
+		!errorspkg.Is(err, contextpkg.Canceled)
+}
+
+// circuitBreaker stops RPC calls after threshold consecutive failures, so a
+// hard-down provider is not hammered. After cooldown a single probe call is
+// let through (half-open): success closes the circuit, failure opens it for
+// another cooldown. onChange is called on every transition.
+type circuitBreaker struct {
+	threshold int
+	cooldown  timepkg.Duration
+	onChange  func(breakerState)
+
+	mu       syncpkg.Mutex
+	state    breakerState
+	failures int
+	openedAt timepkg.Time
+	probing  bool
+}
+
+// newCircuitBreaker returns nil, which never trips, when threshold is 0.
+func newCircuitBreaker(threshold int, cooldown timepkg.Duration, onChange func(breakerState)) *circuitBreaker {
+	if threshold <= 0 {
+		return nil
+	}
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown, onChange: onChange}
+}
+
+// allow reports whether a call may go out now. A call it allows must have
+// its outcome passed to record.
+func (b *circuitBreaker) allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case circuitOpen:
+		if timepkg.Since(b.openedAt) < b.cooldown {
+			return errCircuitOpen
+		}
+		b.set(circuitHalfOpen)
+		logFor("rpc").Info("rpc circuit half-open, probing", "failures", b.failures)
+	case circuitHalfOpen:
+		if b.probing {
+			return errCircuitOpen
+		}
+	default:
+		return nil
+	}
+	b.probing = true
+	return nil
+}
+
+// record feeds back the outcome of a call allow let through.
+func (b *circuitBreaker) record(err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	failed := isRPCFailure(err)
+	if b.state == circuitHalfOpen && b.probing {
+		b.probing = false
+		if failed {
+			b.failures++
+			b.open(err)
+			return
+		}
+		logFor("rpc").Info("rpc circuit closed, probe succeeded", "failures", b.failures)
+		b.failures = 0
+		b.set(circuitClosed)
+		return
+	}
+	if !failed {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.state == circuitClosed && b.failures >= b.threshold {
+		b.open(err)
+	}
+}
+
+// open trips the circuit. b.mu must be held.
+func (b *circuitBreaker) open(err error) {
+	b.openedAt = timepkg.Now()
+	b.set(circuitOpen)
+	logFor("rpc").Error("rpc circuit open", "failures", b.failures, "threshold", b.threshold, "cooldown", b.cooldown.String(), "err", err)
+}
+
+func (b *circuitBreaker) set(s breakerState) {
+	b.state = s
+	if b.onChange != nil {
+		b.onChange(s)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	timepkg "time"
+)
+
+// defaultPollInterval is used until the block time has been measured.
+const defaultPollInterval = 2 * timepkg.Second
+
+// blockCadence estimates the chain's block time from the timestamps of the
+// blocks the loop processes, so an idle loop polls about once per block:
+// often enough to keep up with fast L2s without spamming the RPC on slow
+// chains. Only the run loop uses it.
+type blockCadence struct {
+	min, max timepkg.Duration
+
+	// avg is a moving average of seconds per block over samples
+	// observations. Block timestamps have one-second resolution, so on
+	// sub-second chains single deltas are 0 or 1 and only the average is
+	// meaningful.
+	avg        float64
+	samples    int
+	lastNumber uint64
+	lastTime   uint64
+}
+
+// observe records a processed block. Blocks that do not follow the previous
+// one, as after a reorg rewind, only restart the measurement.
+func (c *blockCadence) observe(number, ts uint64) {
+	if c.lastNumber != 0 && number > c.lastNumber && ts >= c.lastTime {
+		per := float64(ts-c.lastTime) / float64(number-c.lastNumber)
+		if c.samples == 0 {
+			c.avg = per
+		} else {
+			c.avg += (per - c.avg) / 10
+		}
+		c.samples++
+	}
+	c.lastNumber, c.lastTime = number, ts
+}
+
+// interval is how long to wait for the next block, clamped to [min, max].
+func (c *blockCadence) interval() timepkg.Duration {
+	d := defaultPollInterval
+	if c.samples > 0 {
+		d = timepkg.Duration(c.avg * float64(timepkg.Second))
+	}
+	if c.min > 0 && d < c.min {
+		d = c.min
+	}
+	if c.max > 0 && d > c.max {
+		d = c.max
+	}
+	return d
+}
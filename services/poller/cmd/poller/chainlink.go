@@ -0,0 +1,101 @@
+package main
+
+import (
+	contextpkg "context"
+	errorspkg "errors"
+	fmtpkg "fmt"
+	mathbig "math/big"
+	syncpkg "sync"
+	timepkg "time"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Selectors of the Chainlink aggregator calls used here.
+var (
+	selLatestRoundData = common.FromHex("0xfeaf968c") // latestRoundData()
+	selDecimals        = common.FromHex("0x313ce567") // decimals()
+)
+
+// chainlinkFeed reads ETH/USD from a Chainlink aggregator over the poller's
+// own RPC connection, caching each answer for ttl.
+type chainlinkFeed struct {
+	client     chainClient
+	aggregator common.Address
+	ttl        timepkg.Duration
+
+	mu       syncpkg.Mutex
+	decimals int
+	price    float64
+	updated  timepkg.Time
+	fetched  timepkg.Time
+}
+
+func newChainlinkFeed(client chainClient, aggregator common.Address, ttl timepkg.Duration) *chainlinkFeed {
+	return &chainlinkFeed{client: client, aggregator: aggregator, ttl: ttl, decimals: -1}
+}
+
+// ETHUSD returns the cached round, reading a new one once ttl has passed.
+// updated is the round's updatedAt, so a feed that stopped updating ages
+// out through PRICE_MAX_AGE even while reads succeed. A failed read keeps
+// the previous round.
+func (c *chainlinkFeed) ETHUSD(ctx contextpkg.Context) (float64, timepkg.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if timepkg.Since(c.fetched) >= c.ttl {
+		// try again after ttl even on failure, so a broken feed does not
+		// cost an extra call per event
+		c.fetched = timepkg.Now()
+		if err := c.refresh(ctx); err != nil {
+			logFor("price").Warn("read Chainlink aggregator", "aggregator", c.aggregator.Hex(), "err", err)
+		}
+	}
+	return c.price, c.updated, !c.updated.IsZero()
+}
+
+// refresh reads latestRoundData and scales the answer by the aggregator's
+// decimals (8 for USD pairs). c.mu must be held.
+func (c *chainlinkFeed) refresh(ctx contextpkg.Context) error {
+	ctx, cancel := contextpkg.WithTimeout(ctx, 10*timepkg.Second)
+	defer cancel()
+	if c.decimals < 0 {
+		out, err := c.call(ctx, selDecimals)
+		if err != nil {
+			return fmtpkg.Errorf("decimals: %w", err)
+		}
+		if len(out) < 32 {
+			return errorspkg.New("decimals: short response")
+		}
+		c.decimals = int(new(mathbig.Int).SetBytes(out[:32]).Uint64())
+	}
+	out, err := c.call(ctx, selLatestRoundData)
+	if err != nil {
+		return fmtpkg.Errorf("latestRoundData: %w", err)
+	}
+	// (uint80 roundId, int256 answer, uint256 startedAt, uint256 updatedAt,
+	// uint80 answeredInRound), one 32-byte word each
+	if len(out) < 5*32 {
+		return errorspkg.New("latestRoundData: short response")
+	}
+	word := func(i int) *mathbig.Int { return new(mathbig.Int).SetBytes(out[i*32 : (i+1)*32]) }
+	roundID, answer, updatedAt, answeredIn := word(0), word(1), word(3), word(4)
+	// int256 is two's complement; a set top bit is a negative answer
+	if out[32]&0x80 != 0 || answer.Sign() == 0 {
+		return errorspkg.New("latestRoundData: non-positive answer")
+	}
+	if answeredIn.Cmp(roundID) < 0 {
+		return fmtpkg.Errorf("latestRoundData: round %s answered in earlier round %s", roundID, answeredIn)
+	}
+	scale := new(mathbig.Float).SetInt(new(mathbig.Int).Exp(mathbig.NewInt(10), mathbig.NewInt(int64(c.decimals)), nil))
+	price, _ := new(mathbig.Float).Quo(new(mathbig.Float).SetInt(answer), scale).Float64()
+	c.price = price
+	c.updated = timepkg.Unix(updatedAt.Int64(), 0)
+	notePriceUpdate(c.updated)
+	logFor("price").Debug("ETH/USD price updated", "price", price, "round", roundID.String(), "updatedAt", c.updated.UTC().Format(timepkg.RFC3339))
+	return nil
+}
+
+func (c *chainlinkFeed) call(ctx contextpkg.Context, selector []byte) ([]byte, error) {
+	return c.client.CallContract(ctx, ethereum.CallMsg{To: &c.aggregator, Data: selector}, nil)
+}
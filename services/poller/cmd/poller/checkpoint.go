@@ -0,0 +1,391 @@
+package main
+
+import (
+	bufiopkg "bufio"
+	encodingjson "encoding/json"
+	errorspkg "errors"
+	fmtpkg "fmt"
+	iopkg "io"
+	iofspkg "io/fs"
+	netpkg "net"
+	urlpkg "net/url"
+	ospkg "os"
+	filepathpkg "path/filepath"
+	strconvpkg "strconv"
+	stringspkg "strings"
+	syncpkg "sync"
+	timepkg "time"
+
+	"github.com/IBM/sarama"
+)
+
+// errNoCheckpoint is returned by Load when nothing has been saved yet.
+var errNoCheckpoint = errorspkg.New("no checkpoint")
+
+// Checkpointer persists the last fully processed block so a restart resumes
+// where the previous run stopped.
+type Checkpointer interface {
+	Load() (checkpointRecord, error)
+	Save(rec checkpointRecord) error
+}
+
+// checkpointRecord is the last processed block. Hash lets a restart notice
+// that the block was reorged away while the poller was down; checkpoints
+// written before it was added leave it empty.
+type checkpointRecord struct {
+	Block uint64 `json:"block"`
+	Hash  string `json:"hash,omitempty"`
+}
+
+// resumeFrom returns the block the loop should treat as already processed:
+// the saved checkpoint when there is one, otherwise the block before
+// start.block, otherwise tip. Hash is set only when resuming exactly at the
+// checkpoint. A checkpoint more than maxCatchup blocks behind
+// tip is clamped so an old checkpoint cannot trigger an unbounded backfill;
+// maxCatchup of 0 disables the clamp. An explicit start block is never
+// clamped.
+func resumeFrom(ckpt Checkpointer, tip, maxCatchup uint64, start startPoint) (checkpointRecord, error) {
+	if start.atHead {
+		logFor("checkpoint").Info("START_AT_HEAD set, starting at head", "block", tip)
+		return checkpointRecord{Block: tip}, nil
+	}
+	rec, err := ckpt.Load()
+	block := rec.Block
+	if errorspkg.Is(err, errNoCheckpoint) && start.block != nil {
+		first := *start.block
+		if first > tip {
+			logFor("checkpoint").Info("no checkpoint, start block is ahead of tip, waiting for the chain to catch up", "from", start.from, "block", first, "tip", tip)
+		} else {
+			logFor("checkpoint").Info("no checkpoint, catching up from start block", "from", start.from, "block", first, "behind", tip-first+1)
+		}
+		if first == 0 {
+			return checkpointRecord{}, nil
+		}
+		return checkpointRecord{Block: first - 1}, nil
+	}
+	if errorspkg.Is(err, errNoCheckpoint) {
+		logFor("checkpoint").Info("no checkpoint, starting at tip", "block", tip)
+		return checkpointRecord{Block: tip}, nil
+	}
+	if err != nil {
+		return checkpointRecord{}, err
+	}
+	if block > tip {
+		logFor("checkpoint").Info("checkpoint is ahead of tip, waiting for the chain to catch up", "block", block, "tip", tip)
+		return rec, nil
+	}
+	if maxCatchup > 0 && tip-block > maxCatchup {
+		logFor("checkpoint").Warn("checkpoint too far behind tip, skipping ahead", "block", block, "behind", tip-block, "skipTo", tip-maxCatchup, "maxCatchup", maxCatchup)
+		return checkpointRecord{Block: tip - maxCatchup}, nil
+	}
+	logFor("checkpoint").Info("resuming from checkpoint", "block", block)
+	return rec, nil
+}
+
+// nopCheckpointer never remembers anything, so every start begins at head.
+type nopCheckpointer struct{}
+
+func (nopCheckpointer) Load() (checkpointRecord, error) { return checkpointRecord{}, errNoCheckpoint }
+func (nopCheckpointer) Save(checkpointRecord) error     { return nil }
+
+// fileCheckpointer keeps the checkpoint in a small JSON file.
+type fileCheckpointer struct {
+	path string
+}
+
+func (f fileCheckpointer) Load() (checkpointRecord, error) {
+	var rec checkpointRecord
+	data, err := ospkg.ReadFile(f.path)
+	if errorspkg.Is(err, iofspkg.ErrNotExist) {
+		return rec, errNoCheckpoint
+	}
+	if err != nil {
+		return rec, err
+	}
+	if err := encodingjson.Unmarshal(data, &rec); err != nil {
+		return rec, fmtpkg.Errorf("parse %s: %w", f.path, err)
+	}
+	return rec, nil
+}
+
+func (f fileCheckpointer) Save(rec checkpointRecord) error {
+	data, _ := encodingjson.Marshal(rec)
+	return writeFileAtomic(f.path, data)
+}
+
+// writeFileAtomic writes data to a temporary file, syncs it and renames it
+// over path, so a crash leaves either the previous or the new contents on
+// disk, never a torn write.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ospkg.CreateTemp(filepathpkg.Dir(path), filepathpkg.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer ospkg.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := ospkg.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	// persist the rename itself
+	if dir, err := ospkg.Open(filepathpkg.Dir(path)); err == nil {
+		_ = dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
+
+// redisCheckpointer keeps the checkpoint under a single Redis key. It speaks
+// just enough RESP for GET/SET so the poller does not need a Redis client
+// library.
+type redisCheckpointer struct {
+	addr     string
+	password string
+	db       int
+	key      string
+
+	mu   syncpkg.Mutex
+	conn netpkg.Conn
+	rd   *bufiopkg.Reader
+}
+
+// newRedisCheckpointer accepts either host:port or a redis://[:password@]host:port[/db] URL.
+func newRedisCheckpointer(raw, key string) (*redisCheckpointer, error) {
+	r := &redisCheckpointer{addr: raw, key: key}
+	if stringspkg.HasPrefix(raw, "redis://") {
+		u, err := urlpkg.Parse(raw)
+		if err != nil {
+			return nil, fmtpkg.Errorf("CHECKPOINT_REDIS: %w", err)
+		}
+		r.addr = u.Host
+		if pw, ok := u.User.Password(); ok {
+			r.password = pw
+		}
+		if db := stringspkg.Trim(u.Path, "/"); db != "" {
+			n, err := strconvpkg.Atoi(db)
+			if err != nil {
+				return nil, fmtpkg.Errorf("CHECKPOINT_REDIS: invalid db %q", db)
+			}
+			r.db = n
+		}
+	}
+	if r.addr == "" {
+		return nil, errorspkg.New("CHECKPOINT_REDIS: missing address")
+	}
+	return r, nil
+}
+
+func (r *redisCheckpointer) Load() (checkpointRecord, error) {
+	var rec checkpointRecord
+	reply, err := r.do("GET", r.key)
+	if err != nil {
+		return rec, err
+	}
+	if reply == nil {
+		return rec, errNoCheckpoint
+	}
+	if err := encodingjson.Unmarshal(reply, &rec); err != nil {
+		return rec, fmtpkg.Errorf("parse redis key %s: %w", r.key, err)
+	}
+	return rec, nil
+}
+
+func (r *redisCheckpointer) Save(rec checkpointRecord) error {
+	data, _ := encodingjson.Marshal(rec)
+	_, err := r.do("SET", r.key, string(data))
+	return err
+}
+
+// do runs one command, dialing on first use and dropping the connection on
+// any error so the next call reconnects.
+func (r *redisCheckpointer) do(args ...string) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.conn == nil {
+		if err := r.dial(); err != nil {
+			return nil, err
+		}
+	}
+	reply, err := r.roundTrip(args...)
+	if err != nil {
+		r.conn.Close()
+		r.conn = nil
+	}
+	return reply, err
+}
+
+func (r *redisCheckpointer) dial() error {
+	conn, err := netpkg.DialTimeout("tcp", r.addr, 5*timepkg.Second)
+	if err != nil {
+		return err
+	}
+	r.conn, r.rd = conn, bufiopkg.NewReader(conn)
+	if r.password != "" {
+		if _, err := r.roundTrip("AUTH", r.password); err != nil {
+			conn.Close()
+			r.conn = nil
+			return fmtpkg.Errorf("redis auth: %w", err)
+		}
+	}
+	if r.db != 0 {
+		if _, err := r.roundTrip("SELECT", strconvpkg.Itoa(r.db)); err != nil {
+			conn.Close()
+			r.conn = nil
+			return fmtpkg.Errorf("redis select: %w", err)
+		}
+	}
+	return nil
+}
+
+func (r *redisCheckpointer) roundTrip(args ...string) ([]byte, error) {
+	_ = r.conn.SetDeadline(timepkg.Now().Add(5 * timepkg.Second))
+	var b stringspkg.Builder
+	fmtpkg.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmtpkg.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := iopkg.WriteString(r.conn, b.String()); err != nil {
+		return nil, err
+	}
+	line, err := r.rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = stringspkg.TrimRight(line, "\r\n")
+	if line == "" {
+		return nil, errorspkg.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+', ':':
+		return []byte(line[1:]), nil
+	case '-':
+		return nil, errorspkg.New("redis: " + line[1:])
+	case '$':
+		n, err := strconvpkg.Atoi(line[1:])
+		if err != nil {
+			return nil, fmtpkg.Errorf("redis: bad bulk length %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := iopkg.ReadFull(r.rd, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+	return nil, fmtpkg.Errorf("redis: unexpected reply %q", line)
+}
+
+// kafkaCheckpointer stores checkpoints on a compacted topic keyed by
+// tenant and chain, so the latest record for the key is the checkpoint.
+type kafkaCheckpointer struct {
+	brokers  []string
+	security kafkaSecurity
+	producer sarama.SyncProducer
+	topic    string
+	key      string
+	// timeout bounds Load, so an unavailable partition fails startup
+	// rather than hanging it
+	timeout timepkg.Duration
+}
+
+func (k kafkaCheckpointer) Save(rec checkpointRecord) error {
+	value, _ := encodingjson.Marshal(rec)
+	_, _, err := k.producer.SendMessage(&sarama.ProducerMessage{
+		Topic: k.topic,
+		Key:   sarama.StringEncoder(k.key),
+		Value: sarama.ByteEncoder(value),
+	})
+	return err
+}
+
+// Load reads every partition of the topic up to its current high-water mark
+// and returns the newest record for our key. It fails if a partition
+// reports an error or the whole read takes longer than timeout.
+func (k kafkaCheckpointer) Load() (checkpointRecord, error) {
+	cfg := k.security.config()
+	cfg.Consumer.Return.Errors = true
+	client, err := sarama.NewClient(k.brokers, cfg)
+	if err != nil {
+		return checkpointRecord{}, err
+	}
+	defer client.Close()
+	partitions, err := client.Partitions(k.topic)
+	if errorspkg.Is(err, sarama.ErrUnknownTopicOrPartition) {
+		return checkpointRecord{}, errNoCheckpoint
+	}
+	if err != nil {
+		return checkpointRecord{}, err
+	}
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return checkpointRecord{}, err
+	}
+	defer consumer.Close()
+
+	deadline := timepkg.NewTimer(k.timeout)
+	defer deadline.Stop()
+	var (
+		found  bool
+		latest checkpointRecord
+		offset int64 = -1
+	)
+	for _, part := range partitions {
+		hw, err := client.GetOffset(k.topic, part, sarama.OffsetNewest)
+		if err != nil {
+			return checkpointRecord{}, err
+		}
+		oldest, err := client.GetOffset(k.topic, part, sarama.OffsetOldest)
+		if err != nil {
+			return checkpointRecord{}, err
+		}
+		if hw <= oldest {
+			continue
+		}
+		pc, err := consumer.ConsumePartition(k.topic, part, oldest)
+		if err != nil {
+			return checkpointRecord{}, err
+		}
+		err = func() error {
+			defer pc.Close()
+			for {
+				select {
+				case msg, ok := <-pc.Messages():
+					if !ok {
+						return errorspkg.New("partition consumer closed")
+					}
+					if string(msg.Key) == k.key && msg.Offset > offset {
+						var rec checkpointRecord
+						if err := encodingjson.Unmarshal(msg.Value, &rec); err == nil {
+							found, latest, offset = true, rec, msg.Offset
+						}
+					}
+					if msg.Offset >= hw-1 {
+						return nil
+					}
+				case err := <-pc.Errors():
+					return err
+				case <-deadline.C:
+					return fmtpkg.Errorf("not read up to offset %d within %s", hw-1, k.timeout)
+				}
+			}
+		}()
+		if err != nil {
+			return checkpointRecord{}, fmtpkg.Errorf("read checkpoint topic %s partition %d: %w", k.topic, part, err)
+		}
+	}
+	if !found {
+		return checkpointRecord{}, errNoCheckpoint
+	}
+	return latest, nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	encodingjson "encoding/json"
+	errorspkg "errors"
+	filepathpkg "path/filepath"
+	stringspkg "strings"
+	syncpkg "sync"
+	testingpkg "testing"
+	timepkg "time"
+
+	"github.com/IBM/sarama"
+)
+
+// TestFileCheckpointerConcurrent saves and loads the checkpoint file from
+// several goroutines at once. Run it with -race; a reader must also never
+// see a torn write.
+func TestFileCheckpointerConcurrent(t *testingpkg.T) {
+	ckpt := fileCheckpointer{path: filepathpkg.Join(t.TempDir(), "poller.checkpoint")}
+	const rounds = 50
+
+	var wg syncpkg.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 1; i <= rounds; i++ {
+				if err := ckpt.Save(checkpointRecord{Block: uint64(i), Hash: "0xabc"}); err != nil {
+					t.Errorf("Save: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				rec, err := ckpt.Load()
+				if errorspkg.Is(err, errNoCheckpoint) {
+					continue
+				}
+				if err != nil {
+					t.Errorf("Load: %v", err)
+					return
+				}
+				if rec.Block < 1 || rec.Block > rounds || rec.Hash != "0xabc" {
+					t.Errorf("Load = %+v, not a record that was saved", rec)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	rec, err := ckpt.Load()
+	if err != nil || rec.Block != rounds {
+		t.Fatalf("Load after every Save = %+v, %v; want block %d", rec, err, rounds)
+	}
+}
+
+func TestResumeFrom(t *testingpkg.T) {
+	saved := fileCheckpointer{path: filepathpkg.Join(t.TempDir(), "poller.checkpoint")}
+	if err := saved.Save(checkpointRecord{Block: 900, Hash: "0x01"}); err != nil {
+		t.Fatal(err)
+	}
+	none := nopCheckpointer{}
+	block := func(n uint64) *uint64 { return &n }
+
+	for _, tc := range []struct {
+		name       string
+		ckpt       Checkpointer
+		tip        uint64
+		maxCatchup uint64
+		start      startPoint
+		want       checkpointRecord
+	}{
+		{name: "no checkpoint starts at tip", ckpt: none, tip: 1000, want: checkpointRecord{Block: 1000}},
+		{name: "resumes at checkpoint", ckpt: saved, tip: 1000, maxCatchup: 500, want: checkpointRecord{Block: 900, Hash: "0x01"}},
+		{name: "clamps an old checkpoint", ckpt: saved, tip: 1000, maxCatchup: 50, want: checkpointRecord{Block: 950}},
+		{name: "no clamp with maxCatchup 0", ckpt: saved, tip: 100000, want: checkpointRecord{Block: 900, Hash: "0x01"}},
+		{name: "checkpoint ahead of tip", ckpt: saved, tip: 800, want: checkpointRecord{Block: 900, Hash: "0x01"}},
+		{name: "at head ignores checkpoint", ckpt: saved, tip: 1000, start: startPoint{atHead: true}, want: checkpointRecord{Block: 1000}},
+		{name: "start block without checkpoint", ckpt: none, tip: 1000, maxCatchup: 10, start: startPoint{block: block(100)}, want: checkpointRecord{Block: 99}},
+		{name: "start block 0", ckpt: none, tip: 1000, start: startPoint{block: block(0)}, want: checkpointRecord{}},
+		{name: "checkpoint wins over start block", ckpt: saved, tip: 1000, start: startPoint{block: block(100)}, want: checkpointRecord{Block: 900, Hash: "0x01"}},
+	} {
+		t.Run(tc.name, func(t *testingpkg.T) {
+			got, err := resumeFrom(tc.ckpt, tc.tip, tc.maxCatchup, tc.start)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Errorf("resumeFrom = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+// TestKafkaCheckpointerLoad reads a checkpoint topic from a mock broker:
+// the newest record for the key wins, and a partition that never delivers
+// up to its high-water mark fails the load at the timeout instead of
+// hanging startup.
+func TestKafkaCheckpointerLoad(t *testingpkg.T) {
+	const topic = "onchain-gas-checkpoints"
+	record := func(block uint64) sarama.Encoder {
+		value, _ := encodingjson.Marshal(checkpointRecord{Block: block})
+		return sarama.ByteEncoder(value)
+	}
+	for _, tc := range []struct {
+		name      string
+		fetch     *sarama.MockFetchResponse
+		wantBlock uint64
+		wantErr   bool
+	}{
+		{
+			name: "newest record for the key",
+			fetch: sarama.NewMockFetchResponse(t, 3).
+				SetMessageWithKey(topic, 0, 0, sarama.StringEncoder("tenant|1"), record(10)).
+				SetMessageWithKey(topic, 0, 1, sarama.StringEncoder("other|1"), record(99)).
+				SetMessageWithKey(topic, 0, 2, sarama.StringEncoder("tenant|1"), record(12)).
+				SetHighWaterMark(topic, 0, 3),
+			wantBlock: 12,
+		},
+		{
+			// the broker says there are 3 records but serves only one
+			name: "high-water mark never reached",
+			fetch: sarama.NewMockFetchResponse(t, 1).
+				SetMessageWithKey(topic, 0, 0, sarama.StringEncoder("tenant|1"), record(10)).
+				SetHighWaterMark(topic, 0, 3),
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testingpkg.T) {
+			broker := sarama.NewMockBroker(t, 1)
+			defer broker.Close()
+			broker.SetHandlerByMap(map[string]sarama.MockResponse{
+				"MetadataRequest": sarama.NewMockMetadataResponse(t).
+					SetBroker(broker.Addr(), broker.BrokerID()).
+					SetLeader(topic, 0, broker.BrokerID()),
+				"OffsetRequest": sarama.NewMockOffsetResponse(t).
+					SetOffset(topic, 0, sarama.OffsetOldest, 0).
+					SetOffset(topic, 0, sarama.OffsetNewest, 3),
+				"FetchRequest": tc.fetch,
+			})
+			k := kafkaCheckpointer{brokers: []string{broker.Addr()}, topic: topic, key: "tenant|1", timeout: 200 * timepkg.Millisecond}
+			started := timepkg.Now()
+			rec, err := k.Load()
+			if tc.wantErr {
+				if err == nil || !stringspkg.Contains(err.Error(), "partition 0") {
+					t.Fatalf("Load = %+v, %v; want an error reading partition 0", rec, err)
+				}
+				if elapsed := timepkg.Since(started); elapsed > 5*timepkg.Second {
+					t.Errorf("Load took %s to fail, want about the 200ms timeout", elapsed)
+				}
+				return
+			}
+			if err != nil || rec.Block != tc.wantBlock {
+				t.Fatalf("Load = %+v, %v; want block %d", rec, err, tc.wantBlock)
+			}
+		})
+	}
+}
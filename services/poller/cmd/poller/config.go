@@ -0,0 +1,239 @@
+package main
+
+import (
+	contextpkg "context"
+	errorspkg "errors"
+	fmtpkg "fmt"
+	mathbig "math/big"
+	ospkg "os"
+	strconvpkg "strconv"
+	timepkg "time"
+
+	"github.com/IBM/sarama"
+)
+
+// The variables read with getenvUint, getenvBool, getenvFloat and
+// getenvDuration, checked up front by validateConfig. Those helpers exit on
+// the first bad value they meet, possibly after minutes of startup.
+var (
+	uintEnvs = []string{
+		"BACKFILL_BATCH_SIZE", "BLOCK_MAX_ATTEMPTS", "BLOCK_WORKERS", "CATCHUP_LOG_EVERY",
+		"CONFIRMATIONS", "DEDUP_CACHE_SIZE", "KAFKA_FLUSH_BYTES", "KAFKA_FLUSH_MESSAGES", "KAFKA_SEND_ATTEMPTS",
+		"KAFKA_TOPIC_PARTITIONS", "KAFKA_TOPIC_REPLICATION", "LAG_ALERT_BLOCKS", "MAX_BLOCKS_PER_TICK",
+		"MAX_CATCHUP_BLOCKS", "MAX_WATCHES", "PRODUCER_MAX_INFLIGHT", "READY_MAX_LAG", "RECEIPT_ATTEMPTS",
+		"REORG_DEPTH", "RPC_BATCH_SIZE", "RPC_BREAKER_THRESHOLD", "RPC_BURST", "RPC_FAILOVER_AFTER",
+		"RPC_HEAD_REGRESSIONS", "RPC_MAX_RETRIES", "SPIKE_MIN_SAMPLES", "SPIKE_WINDOW", "START_BLOCK",
+		"START_OFFSET", "WATCHES_MAX_PAGES", "WATCHES_PAGE_SIZE", "WATCH_DEBOUNCE_MS", "WEBHOOK_ATTEMPTS",
+		"WEBHOOK_BATCH_SIZE",
+	}
+	boolEnvs = []string{
+		"ADDRESS_CHECKSUM", "BOOTSTRAP_OPTIONAL", "COST_INCLUDES_BLOBS", "FOURBYTE_LOOKUP", "KAFKA_CHECK_TOPICS",
+		"KAFKA_CREATE_TOPICS", "KAFKA_IDEMPOTENT", "KAFKA_TLS_INSECURE_SKIP_VERIFY", "KAFKA_TRANSACTIONS", "PRECISE_FEES",
+		"START_AT_HEAD", "SUMMARY_ENABLED", "TRACE_ENABLED",
+	}
+	floatEnvs = []string{
+		"BACKOFF_FACTOR", "RPC_MAX_RPS", "RPC_RPS", "SPIKE_MULTIPLIER",
+	}
+	durationEnvs = []string{
+		"BACKFILL_BLOCK_DELAY", "BOOTSTRAP_DEADLINE", "CATCHUP_BLOCK_DELAY", "CHAINLINK_CACHE_TTL", "CHECKPOINT_LOAD_TIMEOUT",
+		"DEAD_LETTER_REPLAY_INTERVAL", "DEDUP_TTL", "ERROR_BACKOFF_BASE", "ERROR_BACKOFF_MAX", "KAFKA_FLUSH_FREQUENCY",
+		"KAFKA_SEND_MAX_DELAY", "MAX_POLL_INTERVAL", "MIN_POLL_INTERVAL", "PRICE_MAX_AGE", "PRICE_POLL_INTERVAL",
+		"READY_RPC_TIMEOUT", "RECEIPT_RETRY_DELAY", "RPC_BACKOFF_BASE", "RPC_BACKOFF_MAX", "RPC_BREAKER_COOLDOWN",
+		"RPC_HEALTH_INTERVAL", "RPC_RATE_LIMIT_PAUSE", "RPC_STATS_LOG_INTERVAL", "RPC_TIMEOUT_BLOCK", "RPC_TIMEOUT_HEAD",
+		"RPC_TIMEOUT_RECEIPT", "SHUTDOWN_TIMEOUT", "WATCHES_FETCH_TIMEOUT", "WATCH_CACHE_INTERVAL",
+		"WATCH_REFRESH_INTERVAL", "WEBHOOK_BATCH_INTERVAL", "WEBHOOK_TIMEOUT",
+	}
+)
+
+// startupConfig is what validateConfig checks beyond the environment's
+// syntax.
+type startupConfig struct {
+	rpcURLs []string
+	// chainID is CHAIN_ID, nil when unset
+	chainID *mathbig.Int
+	// brokers and topics are checked only with SINK=kafka
+	kafka   bool
+	brokers string
+	topics  []string
+}
+
+// validateConfig checks the configuration before the poller starts: that
+// every numeric setting parses, that the RPC endpoints answer on CHAIN_ID
+// when it is set, and with Kafka that a broker answers and the topics exist
+// or will be created. It returns every problem it finds, not just the
+// first, so a bad deployment is fixed in one go.
+func validateConfig(ctx contextpkg.Context, cfg startupConfig) error {
+	var problems []error
+	problems = append(problems, checkEnvSyntax()...)
+	problems = append(problems, checkRPC(ctx, cfg.rpcURLs, cfg.chainID)...)
+	if cfg.kafka {
+		problems = append(problems, checkKafka(cfg.brokers, cfg.topics)...)
+	}
+	return errorspkg.Join(problems...)
+}
+
+// featureTopic is the topic in key, or def, when the boolean flag is on,
+// and empty otherwise. Unlike getenvBool it does not exit on a bad flag,
+// which validateConfig reports instead.
+func featureTopic(flag, key, def string) string {
+	if on, _ := strconvpkg.ParseBool(ospkg.Getenv(flag)); !on {
+		return ""
+	}
+	return getenv(key, def)
+}
+
+// checkEnvSyntax parses each numeric, boolean and duration variable that
+// is set.
+func checkEnvSyntax() []error {
+	var problems []error
+	check := func(names []string, kind string, parse func(string) error) {
+		for _, name := range names {
+			if v := ospkg.Getenv(name); v != "" && parse(v) != nil {
+				problems = append(problems, fmtpkg.Errorf("%s=%q: not a valid %s", name, v, kind))
+			}
+		}
+	}
+	check(uintEnvs, "non-negative integer", func(v string) error {
+		_, err := strconvpkg.ParseUint(v, 10, 64)
+		return err
+	})
+	check(boolEnvs, "boolean", func(v string) error {
+		_, err := strconvpkg.ParseBool(v)
+		return err
+	})
+	check(floatEnvs, "number", func(v string) error {
+		_, err := strconvpkg.ParseFloat(v, 64)
+		return err
+	})
+	check(durationEnvs, "duration (such as 500ms or 5m)", func(v string) error {
+		_, err := timepkg.ParseDuration(v)
+		return err
+	})
+	for _, name := range []string{"CHAIN_ID", "EXPECTED_CHAIN_ID"} {
+		if v := ospkg.Getenv(name); v != "" {
+			if _, ok := new(mathbig.Int).SetString(v, 10); !ok {
+				problems = append(problems, fmtpkg.Errorf("%s=%q: not a valid chain ID", name, v))
+			}
+		}
+	}
+	// BACKFILL_FROM is a block number or, as parseStartPoint reads it, an
+	// RFC3339 time
+	if v := ospkg.Getenv("BACKFILL_FROM"); v != "" {
+		if _, err := strconvpkg.ParseUint(v, 10, 64); err != nil {
+			if _, err := timepkg.Parse(timepkg.RFC3339, v); err != nil {
+				problems = append(problems, fmtpkg.Errorf("BACKFILL_FROM=%q: not a valid block number or RFC3339 time", v))
+			}
+		}
+	}
+	if _, err := parseEthAmount(ospkg.Getenv("MIN_COST_ETH")); err != nil {
+		problems = append(problems, fmtpkg.Errorf("MIN_COST_ETH: %w", err))
+	}
+	return problems
+}
+
+// checkRPC asks every endpoint for its chain ID. An endpoint on another
+// chain than want is a problem, and so is no endpoint answering; a single
+// unreachable one among several is only logged, as failover covers it.
+func checkRPC(ctx contextpkg.Context, urls []string, want *mathbig.Int) []error {
+	var problems []error
+	answered := 0
+	for _, raw := range urls {
+		name := endpointName(raw)
+		id, err := endpointChainID(ctx, raw)
+		if err != nil {
+			logFor("config").Warn("RPC endpoint unreachable", "endpoint", name, "err", err)
+			continue
+		}
+		answered++
+		if want != nil && id.Cmp(want) != 0 {
+			problems = append(problems, fmtpkg.Errorf("RPC endpoint %s serves chain %s, not CHAIN_ID %s", name, id, want))
+		}
+	}
+	if answered == 0 && len(urls) > 0 {
+		problems = append(problems, fmtpkg.Errorf("no RPC endpoint answered (tried %d)", len(urls)))
+	}
+	return problems
+}
+
+func endpointChainID(ctx contextpkg.Context, raw string) (*mathbig.Int, error) {
+	ctx, cancel := contextpkg.WithTimeout(ctx, 10*timepkg.Second)
+	defer cancel()
+	ep, err := dialEndpoint(ctx, raw)
+	if err != nil {
+		return nil, err
+	}
+	defer ep.client.Close()
+	return ep.client.ChainID(ctx)
+}
+
+// checkKafka checks that a broker answers and that each of topics exists,
+// or will be created: by the poller with KAFKA_CREATE_TOPICS, or by the
+// cluster when its auto.create.topics.enable is on.
+func checkKafka(brokerList string, topics []string) []error {
+	security, err := kafkaSecurityFromEnv()
+	if err != nil {
+		return []error{fmtpkg.Errorf("kafka security: %w", err)}
+	}
+	brokers, err := parseBrokers(brokerList)
+	if err != nil {
+		return []error{fmtpkg.Errorf("KAFKA_BROKERS: %w", err)}
+	}
+	if _, _, err := bootstrapBroker(brokers, security.config()); err != nil {
+		return []error{err}
+	}
+	admin, err := sarama.NewClusterAdmin(brokers, security.config())
+	if err != nil {
+		return []error{fmtpkg.Errorf("kafka admin: %w", err)}
+	}
+	defer admin.Close()
+	existing, err := admin.ListTopics()
+	if err != nil {
+		return []error{fmtpkg.Errorf("list kafka topics: %w", err)}
+	}
+	var missing []string
+	for _, t := range topics {
+		if _, ok := existing[t]; t != "" && !ok {
+			missing = append(missing, t)
+		}
+	}
+	if create, _ := strconvpkg.ParseBool(ospkg.Getenv("KAFKA_CREATE_TOPICS")); len(missing) == 0 || create {
+		return nil
+	}
+	auto, err := autoCreatesTopics(admin)
+	if err != nil {
+		// not knowing is no reason to refuse to start
+		logFor("config").Warn("could not tell whether the cluster creates topics, assuming it does", "missing", missing, "err", err)
+		return nil
+	}
+	if auto {
+		logFor("config").Info("kafka topics missing, the cluster will create them on first use", "topics", missing)
+		return nil
+	}
+	var problems []error
+	for _, t := range missing {
+		problems = append(problems, fmtpkg.Errorf("kafka topic %s does not exist and will not be created (set KAFKA_CREATE_TOPICS=true)", t))
+	}
+	return problems
+}
+
+// autoCreatesTopics reports the controller's auto.create.topics.enable.
+func autoCreatesTopics(admin sarama.ClusterAdmin) (bool, error) {
+	controller, err := admin.Controller()
+	if err != nil {
+		return false, err
+	}
+	entries, err := admin.DescribeConfig(sarama.ConfigResource{
+		Type:        sarama.BrokerResource,
+		Name:        strconvpkg.Itoa(int(controller.ID())),
+		ConfigNames: []string{"auto.create.topics.enable"},
+	})
+	if err != nil {
+		return false, err
+	}
+	for _, e := range entries {
+		if e.Name == "auto.create.topics.enable" {
+			return e.Value == "true", nil
+		}
+	}
+	return false, errorspkg.New("auto.create.topics.enable not reported")
+}
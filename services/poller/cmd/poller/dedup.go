@@ -0,0 +1,72 @@
+package main
+
+import (
+	listpkg "container/list"
+	syncpkg "sync"
+	timepkg "time"
+)
+
+// dedupCache remembers recently emitted (tx hash, block hash) pairs so a
+// block visited twice, by a restart from an older checkpoint or a backfill
+// overlapping the live loop, does not emit its events again. It is a bounded
+// LRU whose entries also expire after ttl. A nil cache remembers nothing.
+type dedupCache struct {
+	size int
+	ttl  timepkg.Duration
+
+	mu    syncpkg.Mutex
+	order *listpkg.List // front is most recently used
+	items map[string]*listpkg.Element
+}
+
+type dedupEntry struct {
+	key  string
+	seen timepkg.Time
+}
+
+func newDedupCache(size int, ttl timepkg.Duration) *dedupCache {
+	if size <= 0 {
+		return nil
+	}
+	return &dedupCache{size: size, ttl: ttl, order: listpkg.New(), items: make(map[string]*listpkg.Element)}
+}
+
+// Seen reports whether key was added within ttl.
+func (d *dedupCache) Seen(key string) bool {
+	if d == nil {
+		return false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	el, ok := d.items[key]
+	if !ok {
+		return false
+	}
+	if d.ttl > 0 && timepkg.Since(el.Value.(*dedupEntry).seen) > d.ttl {
+		d.order.Remove(el)
+		delete(d.items, key)
+		return false
+	}
+	d.order.MoveToFront(el)
+	return true
+}
+
+// Add records key, evicting the least recently used entry when full.
+func (d *dedupCache) Add(key string) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if el, ok := d.items[key]; ok {
+		el.Value.(*dedupEntry).seen = timepkg.Now()
+		d.order.MoveToFront(el)
+		return
+	}
+	d.items[key] = d.order.PushFront(&dedupEntry{key: key, seen: timepkg.Now()})
+	if d.order.Len() > d.size {
+		oldest := d.order.Back()
+		d.order.Remove(oldest)
+		delete(d.items, oldest.Value.(*dedupEntry).key)
+	}
+}
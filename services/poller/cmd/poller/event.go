@@ -0,0 +1,98 @@
+package main
+
+import "github.com/ethereum/go-ethereum/common"
+
+// GasEvent is the message sent to the gas topic for each matched tx. Its
+// JSON is the event's canonical form: the Avro schema (gas_event.avsc) and
+// the protobuf message (gaseventpb) mirror its field names.
+//
+// Fields are in the order encoding/json sorts map keys, which is the order
+// events were sent in before this struct existed, so the JSON is unchanged
+// byte for byte. Pointers are optional numbers that can legitimately be
+// zero, such as the base fee of a legacy tx. status and logsCount are on
+// every event: without a receipt, status is unknown and logsCount null.
+type GasEvent struct {
+	BaseFeeGwei           *float64   `json:"baseFeeGwei,omitempty"`
+	BaseFeePerGasWei      string     `json:"baseFeePerGasWei,omitempty"`
+	BaseFeeWei            string     `json:"baseFeeWei,omitempty"`
+	BlobBaseFeeGwei       *float64   `json:"blobBaseFeeGwei,omitempty"`
+	BlobBaseFeeWei        string     `json:"blobBaseFeeWei,omitempty"`
+	BlobCostEth           *float64   `json:"blobCostEth,omitempty"`
+	BlobCostWei           string     `json:"blobCostWei,omitempty"`
+	BlobGasUsed           *uint64    `json:"blobGasUsed,omitempty"`
+	BlockNumber           uint64     `json:"blockNumber"`
+	CallCount             int        `json:"callCount,omitempty"`
+	CallGasUsed           *uint64    `json:"callGasUsed,omitempty"`
+	CallReverted          bool       `json:"callReverted,omitempty"`
+	CallType              string     `json:"callType,omitempty"`
+	ChainID               uint64     `json:"chainId"`
+	Contract              string     `json:"contract"`
+	CostEth               *float64   `json:"costEth,omitempty"`
+	CostUsd               *float64   `json:"costUsd,omitempty"`
+	CostWei               string     `json:"costWei,omitempty"`
+	Depth                 int        `json:"depth,omitempty"`
+	EffectiveGasPriceGwei *float64   `json:"effectiveGasPriceGwei,omitempty"`
+	EffectiveGasPriceWei  string     `json:"effectiveGasPriceWei,omitempty"`
+	EthUsdPrice           *float64   `json:"ethUsdPrice,omitempty"`
+	EventID               string     `json:"eventId"`
+	FeeModel              string     `json:"feeModel,omitempty"`
+	From                  string     `json:"from"`
+	GasUsed               *uint64    `json:"gasUsed,omitempty"`
+	LogsCount             *int       `json:"logsCount"`
+	MatchReason           string     `json:"matchReason"`
+	MethodName            string     `json:"methodName"`
+	MethodSignature       string     `json:"methodSignature"`
+	NetworkName           string     `json:"networkName,omitempty"`
+	PriorityFeeGwei       *float64   `json:"priorityFeeGwei,omitempty"`
+	PriorityFeePerGasWei  string     `json:"priorityFeePerGasWei,omitempty"`
+	PriorityFeeWei        string     `json:"priorityFeeWei,omitempty"`
+	ReceiptMissing        bool       `json:"receiptMissing,omitempty"`
+	Reorged               bool       `json:"reorged,omitempty"`
+	Replaces              string     `json:"replaces,omitempty"`
+	RevertReason          string     `json:"revertReason,omitempty"`
+	RevertedCostEth       *float64   `json:"revertedCostEth,omitempty"`
+	RevertedCostWei       string     `json:"revertedCostWei,omitempty"`
+	Source                string     `json:"source"`
+	Status                string     `json:"status"`
+	TenantID              string     `json:"tenantId"`
+	Timestamp             uint64     `json:"timestamp"`
+	To                    string     `json:"to"`
+	Transfers             []Transfer `json:"transfers,omitempty"`
+	TxHash                string     `json:"txHash"`
+}
+
+// Transfer is an ERC-20 Transfer event the watched contract emitted. Value
+// is a decimal string so large amounts survive JSON.
+type Transfer struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Value string `json:"value"`
+}
+
+// checksummed returns a copy of ev with its addresses in EIP-55 form, for
+// ADDRESS_CHECKSUM. Everything inside the poller keeps the lowercase form
+// it matches on.
+func (ev *GasEvent) checksummed() *GasEvent {
+	out := *ev
+	out.Contract = checksumAddress(ev.Contract)
+	out.From = checksumAddress(ev.From)
+	out.To = checksumAddress(ev.To)
+	if ev.Transfers != nil {
+		out.Transfers = make([]Transfer, len(ev.Transfers))
+		for i, t := range ev.Transfers {
+			out.Transfers[i] = Transfer{From: checksumAddress(t.From), To: checksumAddress(t.To), Value: t.Value}
+		}
+	}
+	return &out
+}
+
+// checksumAddress is the EIP-55 form of a hex address; anything else, such
+// as an empty string, is returned as it is.
+func checksumAddress(s string) string {
+	if !common.IsHexAddress(s) {
+		return s
+	}
+	return common.HexToAddress(s).Hex()
+}
+
+func ptr[T any](v T) *T { return &v }
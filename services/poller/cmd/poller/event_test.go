@@ -0,0 +1,70 @@
+package main
+
+import (
+	contextpkg "context"
+	mathbig "math/big"
+	stringspkg "strings"
+	testingpkg "testing"
+)
+
+// eip55Vectors are checksummed addresses from the EIP-55 spec.
+var eip55Vectors = []string{
+	"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed",
+	"0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d359",
+	"0xdbF03B407c01E7cD3CBea99509d93f8DDDC8C6FB",
+	"0xD1220A0cf47c7B9Be7A2E6BA89F429762e7b9aDb",
+}
+
+func TestChecksumAddress(t *testingpkg.T) {
+	for _, want := range eip55Vectors {
+		for _, in := range []string{stringspkg.ToLower(want), "0x" + stringspkg.ToUpper(want[2:]), want} {
+			if got := checksumAddress(in); got != want {
+				t.Errorf("checksumAddress(%q) = %q, want %q", in, got, want)
+			}
+		}
+	}
+	for _, in := range []string{"", "0x1234", "not an address"} {
+		if got := checksumAddress(in); got != in {
+			t.Errorf("checksumAddress(%q) = %q, want it unchanged", in, got)
+		}
+	}
+}
+
+// TestAddressChecksumOutput processes a block with ADDRESS_CHECKSUM on and
+// checks the event carries EIP-55 addresses while its key keeps the
+// lowercase form the poller matches on.
+func TestAddressChecksumOutput(t *testingpkg.T) {
+	chain := newFakeChain(1, testContract, 1)
+	p, sink, _ := newTestPoller(chain, testContract, 0)
+	p.checksumAddresses = true
+	blk, _ := chain.BlockByNumber(contextpkg.Background(), mathbig.NewInt(1))
+	if _, err := p.processBlock(contextpkg.Background(), blk, blockOpts{source: "live"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(sink.events) != 1 {
+		t.Fatalf("emitted %d events, want 1", len(sink.events))
+	}
+	ev := sink.events[0]
+	if ev.Contract != eip55Vectors[0] || ev.To != eip55Vectors[0] {
+		t.Errorf("contract %q, to %q; want %q", ev.Contract, ev.To, eip55Vectors[0])
+	}
+	if want := "tenant:" + stringspkg.ToLower(eip55Vectors[0]); sink.keys[0] != want {
+		t.Errorf("key = %q, want %q", sink.keys[0], want)
+	}
+
+	// checksummed converts transfers too, on a copy
+	lc := stringspkg.ToLower
+	lower := &GasEvent{
+		Contract:  lc(eip55Vectors[1]),
+		From:      lc(eip55Vectors[2]),
+		To:        lc(eip55Vectors[1]),
+		Transfers: []Transfer{{From: lc(eip55Vectors[2]), To: lc(eip55Vectors[3]), Value: "1"}},
+	}
+	out := lower.checksummed()
+	if out.Contract != eip55Vectors[1] || out.From != eip55Vectors[2] || out.Transfers[0].To != eip55Vectors[3] {
+		t.Errorf("checksummed = %+v", out)
+	}
+	if lower.Contract != lc(eip55Vectors[1]) || lower.Transfers[0].To != lc(eip55Vectors[3]) {
+		t.Error("checksummed changed the event it copied")
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	contextpkg "context"
+	encodingjson "encoding/json"
+	errorspkg "errors"
+	fmtpkg "fmt"
+	mathbig "math/big"
+	syncpkg "sync"
+	atomicpkg "sync/atomic"
+	timepkg "time"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	typespkg "github.com/ethereum/go-ethereum/core/types"
+)
+
+// rpcCodeError is a JSON-RPC error with a code, as rpc.Error.
+type rpcCodeError struct{ code int }
+
+func (e rpcCodeError) Error() string  { return fmtpkg.Sprintf("rpc error %d", e.code) }
+func (e rpcCodeError) ErrorCode() int { return e.code }
+
+var errNotFound = errorspkg.New("not found")
+
+// fakeChain is a chainClient over an in-memory chain whose blocks hold
+// legacy txs to one contract. Only blocks up to head exist.
+type fakeChain struct {
+	mu       syncpkg.Mutex
+	blocks   []*typespkg.Block
+	receipts map[common.Hash]*typespkg.Receipt
+	head     uint64
+	// blockReceipts makes BlockReceipts answer instead of reporting
+	// method-not-found
+	blockReceipts bool
+	// latency is added to every call, as a network round trip
+	latency timepkg.Duration
+
+	receiptCalls      atomicpkg.Int64
+	blockReceiptCalls atomicpkg.Int64
+}
+
+// newFakeChain builds blocks 0..n with txsPerBlock txs each to contract,
+// with head at n.
+func newFakeChain(n uint64, contract common.Address, txsPerBlock int) *fakeChain {
+	c := &fakeChain{receipts: make(map[common.Hash]*typespkg.Receipt), head: n}
+	parent := common.Hash{}
+	nonce := uint64(0)
+	for bn := uint64(0); bn <= n; bn++ {
+		var txs []*typespkg.Transaction
+		for i := 0; i < txsPerBlock; i++ {
+			txs = append(txs, typespkg.NewTx(&typespkg.LegacyTx{
+				Nonce:    nonce,
+				To:       &contract,
+				Gas:      21000,
+				GasPrice: mathbig.NewInt(2_000_000_000),
+				Data:     []byte{0xa9, 0x05, 0x9c, 0xbb},
+			}))
+			nonce++
+		}
+		blk := typespkg.NewBlockWithHeader(&typespkg.Header{
+			ParentHash: parent,
+			Number:     new(mathbig.Int).SetUint64(bn),
+			Time:       1_700_000_000 + bn*12,
+			BaseFee:    mathbig.NewInt(1_000_000_000),
+			Difficulty: new(mathbig.Int),
+		}).WithBody(typespkg.Body{Transactions: txs})
+		for _, tx := range txs {
+			c.receipts[tx.Hash()] = &typespkg.Receipt{
+				Status:            typespkg.ReceiptStatusSuccessful,
+				TxHash:            tx.Hash(),
+				GasUsed:           21000,
+				Logs:              []*typespkg.Log{},
+				EffectiveGasPrice: tx.GasPrice(),
+				BlockHash:         blk.Hash(),
+				BlockNumber:       blk.Number(),
+			}
+		}
+		c.blocks = append(c.blocks, blk)
+		parent = blk.Hash()
+	}
+	return c
+}
+
+func (c *fakeChain) setHead(n uint64) {
+	c.mu.Lock()
+	c.head = n
+	c.mu.Unlock()
+}
+
+func (c *fakeChain) block(number *mathbig.Int) (*typespkg.Block, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := c.head
+	if number != nil {
+		if number.Sign() < 0 {
+			// block tags such as safe and finalized
+			return nil, rpcCodeError{code: invalidParams}
+		}
+		n = number.Uint64()
+	}
+	if n > c.head || n >= uint64(len(c.blocks)) {
+		return nil, errNotFound
+	}
+	return c.blocks[n], nil
+}
+
+func (c *fakeChain) BlockByNumber(_ contextpkg.Context, number *mathbig.Int) (*typespkg.Block, error) {
+	c.roundTrip()
+	return c.block(number)
+}
+
+func (c *fakeChain) HeaderByNumber(_ contextpkg.Context, number *mathbig.Int) (*typespkg.Header, error) {
+	c.roundTrip()
+	blk, err := c.block(number)
+	if err != nil {
+		return nil, err
+	}
+	return blk.Header(), nil
+}
+
+func (c *fakeChain) roundTrip() {
+	if c.latency > 0 {
+		timepkg.Sleep(c.latency)
+	}
+}
+
+func (c *fakeChain) TransactionReceipt(_ contextpkg.Context, hash common.Hash) (*typespkg.Receipt, error) {
+	c.roundTrip()
+	c.receiptCalls.Add(1)
+	return c.receipt(hash)
+}
+
+func (c *fakeChain) receipt(hash common.Hash) (*typespkg.Receipt, error) {
+	if r, ok := c.receipts[hash]; ok {
+		return r, nil
+	}
+	return nil, errNotFound
+}
+
+func (c *fakeChain) BlockReceipts(_ contextpkg.Context, hash common.Hash) ([]*typespkg.Receipt, error) {
+	c.roundTrip()
+	c.blockReceiptCalls.Add(1)
+	if !c.blockReceipts {
+		return nil, rpcCodeError{code: methodNotFound}
+	}
+	for _, blk := range c.blocks {
+		if blk.Hash() == hash {
+			out := make([]*typespkg.Receipt, 0, len(blk.Transactions()))
+			for _, tx := range blk.Transactions() {
+				out = append(out, c.receipts[tx.Hash()])
+			}
+			return out, nil
+		}
+	}
+	return nil, errNotFound
+}
+
+func (c *fakeChain) BlocksByNumber(_ contextpkg.Context, numbers []uint64) ([]*typespkg.Block, []error) {
+	c.roundTrip()
+	blocks, errs := make([]*typespkg.Block, len(numbers)), make([]error, len(numbers))
+	for i, n := range numbers {
+		blocks[i], errs[i] = c.block(new(mathbig.Int).SetUint64(n))
+	}
+	return blocks, errs
+}
+
+func (c *fakeChain) TransactionReceipts(_ contextpkg.Context, hashes []common.Hash) ([]*typespkg.Receipt, []error) {
+	c.roundTrip()
+	c.receiptCalls.Add(1)
+	receipts, errs := make([]*typespkg.Receipt, len(hashes)), make([]error, len(hashes))
+	for i, h := range hashes {
+		receipts[i], errs[i] = c.receipt(h)
+	}
+	return receipts, errs
+}
+
+func (c *fakeChain) ChainID(contextpkg.Context) (*mathbig.Int, error) { return mathbig.NewInt(1), nil }
+
+func (c *fakeChain) CallContract(contextpkg.Context, ethereum.CallMsg, *mathbig.Int) ([]byte, error) {
+	return nil, rpcCodeError{code: methodNotFound}
+}
+
+func (c *fakeChain) TraceBlock(contextpkg.Context, uint64) ([]callFrame, error) {
+	return nil, rpcCodeError{code: methodNotFound}
+}
+
+func (c *fakeChain) Close() {}
+
+// failingClient fails its first failures head, block and receipt calls,
+// then passes them on to chainClient.
+type failingClient struct {
+	chainClient
+	failures int64
+	calls    atomicpkg.Int64
+}
+
+var errTransient = errorspkg.New("503 service unavailable")
+
+func (c *failingClient) fail() bool { return c.calls.Add(1) <= c.failures }
+
+func (c *failingClient) HeaderByNumber(ctx contextpkg.Context, number *mathbig.Int) (*typespkg.Header, error) {
+	if c.fail() {
+		return nil, errTransient
+	}
+	return c.chainClient.HeaderByNumber(ctx, number)
+}
+
+func (c *failingClient) BlockByNumber(ctx contextpkg.Context, number *mathbig.Int) (*typespkg.Block, error) {
+	if c.fail() {
+		return nil, errTransient
+	}
+	return c.chainClient.BlockByNumber(ctx, number)
+}
+
+func (c *failingClient) TransactionReceipt(ctx contextpkg.Context, hash common.Hash) (*typespkg.Receipt, error) {
+	if c.fail() {
+		return nil, errTransient
+	}
+	return c.chainClient.TransactionReceipt(ctx, hash)
+}
+
+// recordingSink keeps the gas events emitted to it, with their keys and
+// topics.
+type recordingSink struct {
+	mu     syncpkg.Mutex
+	events []GasEvent
+	keys   []string
+	topics []string
+}
+
+func (s *recordingSink) Emit(ctx contextpkg.Context, key string, value []byte) error {
+	var ev GasEvent
+	if err := encodingjson.Unmarshal(value, &ev); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, ev)
+	s.keys = append(s.keys, key)
+	m, _ := ctx.Value(sinkMessageKey{}).(sinkMessage)
+	s.topics = append(s.topics, m.topic)
+	return nil
+}
+
+func (s *recordingSink) Close() error { return nil }
+
+func (s *recordingSink) blocks() []uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]uint64, 0, len(s.events))
+	for _, ev := range s.events {
+		out = append(out, ev.BlockNumber)
+	}
+	return out
+}
+
+// recordingCheckpointer keeps the last block saved.
+type recordingCheckpointer struct {
+	last atomicpkg.Uint64
+}
+
+func (r *recordingCheckpointer) Load() (checkpointRecord, error) {
+	return checkpointRecord{}, errNoCheckpoint
+}
+
+func (r *recordingCheckpointer) Save(rec checkpointRecord) error {
+	r.last.Store(rec.Block)
+	return nil
+}
+
+// waitFor polls cond until it holds or a few seconds pass.
+func waitFor(cond func() bool) bool {
+	deadline := timepkg.Now().Add(5 * timepkg.Second)
+	for timepkg.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		timepkg.Sleep(5 * timepkg.Millisecond)
+	}
+	return cond()
+}
+
+// newTestPoller is a poller on client that watches contract and emits to a
+// recordingSink, polling every few milliseconds.
+func newTestPoller(client chainClient, contract common.Address, confirmations uint64) (*poller, *recordingSink, *recordingCheckpointer) {
+	sink := &recordingSink{}
+	ckpt := &recordingCheckpointer{}
+	tips, _ := newTipSource(client, "latest", confirmations)
+	targets := NewWatchSet()
+	targets.Add(contract.Hex())
+	fast := backoff{attempts: 3, base: timepkg.Millisecond, max: 5 * timepkg.Millisecond}
+	p := &poller{
+		client:       client,
+		pub:          &publisher{sink: sink},
+		topic:        "onchain-gas",
+		tenant:       "tenant",
+		chainID:      mathbig.NewInt(1),
+		targets:      targets,
+		senders:      NewWatchSet(),
+		recent:       newBlockRing(64),
+		ckpt:         ckpt,
+		health:       &health{},
+		tips:         tips,
+		cadence:      blockCadence{min: 5 * timepkg.Millisecond, max: 5 * timepkg.Millisecond},
+		partitionKey: partitionByContract,
+		rpcRetry:     fast,
+		receiptRetry: fast,
+		errBackoff:   fast,
+	}
+	p.blockMaxAttempts = 3
+	return p, sink, ckpt
+}
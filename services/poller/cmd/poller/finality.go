@@ -0,0 +1,96 @@
+package main
+
+import (
+	contextpkg "context"
+	errorspkg "errors"
+	fmtpkg "fmt"
+	mathbig "math/big"
+	syncpkg "sync"
+
+	typespkg "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// tipSource decides which block the loop treats as the tip: the latest
+// block minus confirmations, or the node's safe or finalized block.
+type tipSource struct {
+	client        chainClient
+	confirmations uint64
+
+	mu syncpkg.Mutex
+	// tag is rpc.SafeBlockNumber or rpc.FinalizedBlockNumber, nil for latest
+	tag  *mathbig.Int
+	name string
+}
+
+// newTipSource parses FINALITY: latest, safe or finalized.
+func newTipSource(client chainClient, finality string, confirmations uint64) (*tipSource, error) {
+	t := &tipSource{client: client, confirmations: confirmations, name: finality}
+	switch finality {
+	case "latest":
+	case "safe":
+		t.tag = mathbig.NewInt(int64(rpc.SafeBlockNumber))
+	case "finalized":
+		t.tag = mathbig.NewInt(int64(rpc.FinalizedBlockNumber))
+	default:
+		return nil, fmtpkg.Errorf("FINALITY: unknown value %q", finality)
+	}
+	return t, nil
+}
+
+func (t *tipSource) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tag != nil {
+		return t.name
+	}
+	return fmtpkg.Sprintf("%d confirmations", t.confirmations)
+}
+
+// tip returns the highest block the loop may process. pending, a head from
+// the newHeads subscription, saves a round trip when tracking latest; it is
+// ignored for safe and finalized, which only the node can answer.
+func (t *tipSource) tip(ctx contextpkg.Context, pending *typespkg.Header) (uint64, error) {
+	t.mu.Lock()
+	tag := t.tag
+	t.mu.Unlock()
+	if tag == nil && pending != nil {
+		return confirmedTip(pending.Number.Uint64(), t.confirmations), nil
+	}
+	if tag != nil {
+		hdr, err := t.client.HeaderByNumber(ctx, tag)
+		if err == nil {
+			return hdr.Number.Uint64(), nil
+		}
+		// a timeout, 5xx or rate limit says nothing about the tag, and is
+		// retried with it
+		if !isTagUnsupported(err) {
+			return 0, err
+		}
+		logFor("rpc").Warn("RPC does not support the block tag, falling back to latest", "tag", t.name, "confirmations", t.confirmations, "err", err)
+		t.mu.Lock()
+		t.tag = nil
+		t.mu.Unlock()
+	}
+	// only the tip number is needed here, so avoid downloading the body
+	head, err := t.client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	return confirmedTip(head.Number.Uint64(), t.confirmations), nil
+}
+
+// invalidParams is the JSON-RPC error code a node returns for arguments it
+// does not understand, such as a block tag older than the Merge.
+const invalidParams = -32602
+
+// isTagUnsupported reports whether err is the node refusing the safe or
+// finalized tag itself, rather than failing the call.
+func isTagUnsupported(err error) bool {
+	var rpcErr rpc.Error
+	if !errorspkg.As(err, &rpcErr) {
+		return false
+	}
+	code := rpcErr.ErrorCode()
+	return code == methodNotFound || code == invalidParams
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	strconvpkg "strconv"
+
+	"github.com/IBM/sarama"
+)
+
+// schemaVersion is sent with every message; bump it when the JSON of an
+// event changes in a way consumers must know about.
+const schemaVersion = "1"
+
+// Record header names.
+const (
+	headerTenant        = "tenantId"
+	headerChain         = "chainId"
+	headerContract      = "contract"
+	headerSchemaVersion = "schemaVersion"
+	headerSource        = "source"
+
+	// set on messages sent to KAFKA_TOPIC_DLQ
+	headerDLQTopic    = "dlqOriginalTopic"
+	headerDLQError    = "dlqError"
+	headerDLQAttempts = "dlqAttempts"
+
+	// set on watch requests sent to KAFKA_TOPIC_WATCH_REJECTS
+	headerRejectReason = "rejectReason"
+)
+
+// messageHeaders are the routing fields every produced message carries as
+// Kafka record headers, so consumers can filter on them without decoding
+// the value. Empty fields are left out.
+type messageHeaders struct {
+	tenant   string
+	chainID  uint64
+	contract string
+	source   string
+}
+
+func (h messageHeaders) records() []sarama.RecordHeader {
+	out := []sarama.RecordHeader{{Key: []byte(headerSchemaVersion), Value: []byte(schemaVersion)}}
+	add := func(key, value string) {
+		if value != "" {
+			out = append(out, sarama.RecordHeader{Key: []byte(key), Value: []byte(value)})
+		}
+	}
+	add(headerTenant, h.tenant)
+	if h.chainID != 0 {
+		add(headerChain, strconvpkg.FormatUint(h.chainID, 10))
+	}
+	add(headerContract, h.contract)
+	add(headerSource, h.source)
+	return out
+}
+
+// headers returns the record headers for a message about contract.
+func (p *poller) headers(contract, source string) []sarama.RecordHeader {
+	return messageHeaders{tenant: p.tenant, chainID: p.chainID.Uint64(), contract: contract, source: source}.records()
+}
+
+// headerValue returns the value of the first header named key.
+func headerValue(headers []*sarama.RecordHeader, key string) (string, bool) {
+	for _, h := range headers {
+		if h != nil && string(h.Key) == key {
+			return string(h.Value), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	contextpkg "context"
+	stringspkg "strings"
+	atomicpkg "sync/atomic"
+	timepkg "time"
+
+	typespkg "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// headFallbackPoll bounds how long the loop trusts a quiet subscription
+// before checking the tip itself.
+const headFallbackPoll = 30 * timepkg.Second
+
+// headFeed delivers new chain heads from a newHeads subscription and keeps
+// resubscribing when it drops. Only the latest head is kept since the loop
+// just needs the tip; missed heads are covered by the loop's last cursor.
+type headFeed struct {
+	url    string
+	notify chan *typespkg.Header
+	active atomicpkg.Bool
+	// seen is the highest head number delivered so far
+	seen uint64
+}
+
+func newHeadFeed(url string) *headFeed {
+	return &headFeed{url: url, notify: make(chan *typespkg.Header, 1)}
+}
+
+func isWebSocketURL(u string) bool {
+	return stringspkg.HasPrefix(u, "ws://") || stringspkg.HasPrefix(u, "wss://")
+}
+
+// firstWebSocketURL returns the first ws/wss endpoint in urls, if any.
+func firstWebSocketURL(urls []string) string {
+	for _, u := range urls {
+		if isWebSocketURL(u) {
+			return u
+		}
+	}
+	return ""
+}
+
+func (f *headFeed) run(ctx contextpkg.Context) {
+	b := backoff{base: timepkg.Second, max: timepkg.Minute}
+	for attempt := 0; ctx.Err() == nil; attempt++ {
+		err := f.subscribe(ctx, func() { attempt = 0 })
+		f.active.Store(false)
+		if ctx.Err() != nil {
+			return
+		}
+		logFor("heads").Warn("newHeads subscription down, polling until it is back", "endpoint", endpointName(f.url), "err", err)
+		sleepCtx(ctx, b.delay(attempt))
+	}
+}
+
+// subscribe holds one subscription until it fails or ctx ends. connected is
+// called once the subscription is established.
+func (f *headFeed) subscribe(ctx contextpkg.Context, connected func()) error {
+	c, err := ethclient.DialContext(ctx, f.url)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	ch := make(chan *typespkg.Header, 16)
+	sub, err := c.SubscribeNewHead(ctx, ch)
+	if err != nil {
+		return err
+	}
+	defer sub.Unsubscribe()
+	logFor("heads").Info("subscribed to newHeads", "endpoint", endpointName(f.url))
+	f.active.Store(true)
+	connected()
+	// Heads announced while the subscription was down are never resent, so
+	// hand the loop the current head now: it processes everything after its
+	// cursor up to it instead of waiting for the next block.
+	if h, err := c.HeaderByNumber(ctx, nil); err == nil {
+		if n := h.Number.Uint64(); f.seen > 0 && n > f.seen+1 {
+			logFor("heads").Info("filling heads missed while resubscribing", "from", f.seen+1, "to", n)
+		}
+		f.push(h)
+	}
+	for {
+		select {
+		case h := <-ch:
+			f.push(h)
+		case err := <-sub.Err():
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// push replaces any undelivered head with h.
+func (f *headFeed) push(h *typespkg.Header) {
+	if n := h.Number.Uint64(); n > f.seen {
+		f.seen = n
+	}
+	for {
+		select {
+		case f.notify <- h:
+			return
+		default:
+		}
+		select {
+		case <-f.notify:
+		default:
+		}
+	}
+}
+
+// waitForHead blocks until there may be a new head: a subscription
+// notification (returned), or the poll interval when no subscription is
+// live (nil, meaning the loop should fetch the tip itself). The poll
+// interval follows the measured block time.
+func (p *poller) waitForHead(ctx contextpkg.Context) *typespkg.Header {
+	if p.heads == nil || !p.heads.active.Load() {
+		d := p.cadence.interval()
+		pollInterval.Set(d.Seconds())
+		sleepCtx(ctx, d)
+		return nil
+	}
+	t := timepkg.NewTimer(headFallbackPoll)
+	defer t.Stop()
+	select {
+	case h := <-p.heads.notify:
+		return h
+	case <-t.C:
+	case <-ctx.Done():
+	}
+	return nil
+}
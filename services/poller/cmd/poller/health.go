@@ -0,0 +1,79 @@
+package main
+
+import (
+	encodingjson "encoding/json"
+	nethttppkg "net/http"
+	atomicpkg "sync/atomic"
+	timepkg "time"
+)
+
+// health tracks what the liveness and readiness probes report. Fields are
+// set from the poll loop and read by the HTTP handlers.
+type health struct {
+	maxLag     uint64
+	rpcTimeout timepkg.Duration
+	// bootstrapRequired is false with BOOTSTRAP_OPTIONAL, where a failed
+	// bootstrap is reported but does not hold readiness back
+	bootstrapRequired bool
+
+	running     atomicpkg.Bool
+	rpcReady    atomicpkg.Bool
+	kafkaReady  atomicpkg.Bool
+	bootstrapOK atomicpkg.Bool
+	lag         atomicpkg.Uint64
+	// tip is the confirmed tip lag is measured against, last the last
+	// block processed
+	tip  atomicpkg.Uint64
+	last atomicpkg.Uint64
+	// rpcCircuit is the RPC circuit breaker's breakerState
+	rpcCircuit atomicpkg.Int32
+	// lastRPC is the unix nano time of the last successful head fetch
+	lastRPC atomicpkg.Int64
+}
+
+func (h *health) rpcSucceeded() {
+	h.lastRPC.Store(timepkg.Now().UnixNano())
+}
+
+func (h *health) register(mux *nethttppkg.ServeMux) {
+	mux.HandleFunc("/healthz", func(w nethttppkg.ResponseWriter, r *nethttppkg.Request) {
+		if !h.running.Load() {
+			nethttppkg.Error(w, "starting", nethttppkg.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/readyz", func(w nethttppkg.ResponseWriter, r *nethttppkg.Request) {
+		checks, ready := h.readiness()
+		w.Header().Set("Content-Type", "application/json")
+		if !ready {
+			w.WriteHeader(nethttppkg.StatusServiceUnavailable)
+		}
+		_ = encodingjson.NewEncoder(w).Encode(map[string]any{
+			"ready":        ready,
+			"checks":       checks,
+			"bootstrap_ok": h.bootstrapOK.Load(),
+			"rpc_circuit":  breakerState(h.rpcCircuit.Load()).String(),
+		})
+	})
+}
+
+// readiness reports every check by name and whether all of them pass.
+func (h *health) readiness() (map[string]bool, bool) {
+	lastRPC := h.lastRPC.Load()
+	checks := map[string]bool{
+		"rpc":        h.rpcReady.Load(),
+		"kafka":      h.kafkaReady.Load(),
+		"lag":        h.lag.Load() <= h.maxLag,
+		"rpcRecent":  lastRPC != 0 && timepkg.Since(timepkg.Unix(0, lastRPC)) <= h.rpcTimeout,
+		"rpcCircuit": breakerState(h.rpcCircuit.Load()) == circuitClosed,
+	}
+	if h.bootstrapRequired {
+		checks["bootstrap"] = h.bootstrapOK.Load()
+	}
+	ready := true
+	for _, ok := range checks {
+		ready = ready && ok
+	}
+	return checks, ready
+}
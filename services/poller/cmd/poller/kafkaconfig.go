@@ -0,0 +1,168 @@
+package main
+
+import (
+	errorspkg "errors"
+	fmtpkg "fmt"
+	netpkg "net"
+	strconvpkg "strconv"
+	stringspkg "strings"
+	timepkg "time"
+
+	"github.com/IBM/sarama"
+)
+
+// producerSettings are the delivery knobs shared by the sync and async
+// producers.
+type producerSettings struct {
+	// idempotent makes the broker drop duplicates of a retried send, so a
+	// retry cannot reorder or double an event within a partition
+	idempotent  bool
+	acks        string
+	compression string
+	// flush* batch messages in the producer; zero leaves sarama's default
+	// of sending as soon as possible
+	flushFrequency timepkg.Duration
+	flushMessages  int
+	flushBytes     int
+}
+
+func producerSettingsFromEnv() producerSettings {
+	return producerSettings{
+		idempotent:     getenvBool("KAFKA_IDEMPOTENT", true),
+		acks:           getenv("KAFKA_ACKS", "all"),
+		compression:    getenv("KAFKA_COMPRESSION", "none"),
+		flushFrequency: getenvDuration("KAFKA_FLUSH_FREQUENCY", 0),
+		flushMessages:  int(getenvUint("KAFKA_FLUSH_MESSAGES", 0)),
+		flushBytes:     int(getenvUint("KAFKA_FLUSH_BYTES", 0)),
+	}
+}
+
+// parseBrokers splits a comma-separated broker list, checking that every
+// entry is host:port.
+func parseBrokers(s string) ([]string, error) {
+	var brokers []string
+	for _, b := range stringspkg.Split(s, ",") {
+		b = stringspkg.TrimSpace(b)
+		if b == "" {
+			continue
+		}
+		host, port, err := netpkg.SplitHostPort(b)
+		if err != nil {
+			return nil, fmtpkg.Errorf("broker %q: %w", b, err)
+		}
+		if n, err := strconvpkg.ParseUint(port, 10, 16); host == "" || err != nil || n == 0 {
+			return nil, fmtpkg.Errorf("broker %q: want host:port", b)
+		}
+		brokers = append(brokers, b)
+	}
+	if len(brokers) == 0 {
+		return nil, errorspkg.New("no brokers")
+	}
+	return brokers, nil
+}
+
+// bootstrapBroker fetches cluster metadata from the first of brokers that
+// answers, as the sarama clients will, and returns it with the number of
+// brokers in the cluster. Seeds that fail are logged, so a stale entry in
+// the list shows up before it matters.
+func bootstrapBroker(brokers []string, cfg *sarama.Config) (string, int, error) {
+	var lastErr error
+	for _, addr := range brokers {
+		b := sarama.NewBroker(addr)
+		err := b.Open(cfg)
+		var md *sarama.MetadataResponse
+		if err == nil {
+			md, err = b.GetMetadata(&sarama.MetadataRequest{Version: 1})
+			b.Close()
+		}
+		if err != nil {
+			logFor("kafka").Warn("kafka seed broker unreachable", "broker", addr, "err", err)
+			lastErr = err
+			continue
+		}
+		return addr, len(md.Brokers), nil
+	}
+	return "", 0, fmtpkg.Errorf("no kafka broker answered (tried %s): %w", stringspkg.Join(brokers, ", "), lastErr)
+}
+
+// newProducers connects the sync producer, which carries everything but the
+// gas events, and unless mode is sync the async producer that carries them.
+func newProducers(brokers []string, security kafkaSecurity, settings producerSettings, mode string, attempts int) (sarama.SyncProducer, sarama.AsyncProducer, error) {
+	if mode != "sync" && mode != "async" {
+		return nil, nil, fmtpkg.Errorf("PRODUCER_MODE: unknown mode %q (sync or async)", mode)
+	}
+	cfg := security.config()
+	cfg.Producer.Return.Successes = true
+	// sarama's default, set explicitly because per-contract ordering
+	// depends on equal keys always hashing to the same partition
+	cfg.Producer.Partitioner = sarama.NewHashPartitioner
+	if err := settings.apply(cfg); err != nil {
+		return nil, nil, err
+	}
+	producer, err := sarama.NewSyncProducer(brokers, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	if mode == "sync" {
+		return producer, nil, nil
+	}
+	acfg := security.config()
+	acfg.Producer.Return.Successes = true
+	acfg.Producer.Partitioner = sarama.NewHashPartitioner
+	acfg.Producer.Retry.Max = attempts - 1
+	acfg.Producer.Retry.Backoff = 250 * timepkg.Millisecond
+	if err := settings.apply(acfg); err != nil {
+		producer.Close()
+		return nil, nil, fmtpkg.Errorf("async producer: %w", err)
+	}
+	asyncProducer, err := sarama.NewAsyncProducer(brokers, acfg)
+	if err != nil {
+		producer.Close()
+		return nil, nil, fmtpkg.Errorf("async producer: %w", err)
+	}
+	return producer, asyncProducer, nil
+}
+
+var kafkaAcks = map[string]sarama.RequiredAcks{
+	"all":    sarama.WaitForAll,
+	"leader": sarama.WaitForLocal,
+	"none":   sarama.NoResponse,
+}
+
+var kafkaCompression = map[string]sarama.CompressionCodec{
+	"none":   sarama.CompressionNone,
+	"gzip":   sarama.CompressionGZIP,
+	"snappy": sarama.CompressionSnappy,
+	"lz4":    sarama.CompressionLZ4,
+	"zstd":   sarama.CompressionZSTD,
+}
+
+// apply sets s on cfg and validates the result, including sarama's own
+// checks, so a bad combination fails at startup rather than on first send.
+func (s producerSettings) apply(cfg *sarama.Config) error {
+	acks, ok := kafkaAcks[s.acks]
+	if !ok {
+		return fmtpkg.Errorf("KAFKA_ACKS: unknown value %q (all, leader or none)", s.acks)
+	}
+	codec, ok := kafkaCompression[s.compression]
+	if !ok {
+		return fmtpkg.Errorf("KAFKA_COMPRESSION: unknown codec %q (none, gzip, snappy, lz4 or zstd)", s.compression)
+	}
+	if s.idempotent {
+		if acks != sarama.WaitForAll {
+			return errorspkg.New("KAFKA_IDEMPOTENT requires KAFKA_ACKS=all")
+		}
+		if cfg.Producer.Retry.Max < 1 {
+			return errorspkg.New("KAFKA_IDEMPOTENT requires producer retries (KAFKA_SEND_ATTEMPTS > 1)")
+		}
+		cfg.Producer.Idempotent = true
+		// more than one request in flight could reorder a retried batch
+		cfg.Net.MaxOpenRequests = 1
+	}
+	cfg.Producer.RequiredAcks = acks
+	cfg.Producer.Compression = codec
+	cfg.Producer.Flush.Frequency = s.flushFrequency
+	cfg.Producer.Flush.Messages = s.flushMessages
+	cfg.Producer.Flush.Bytes = s.flushBytes
+	return cfg.Validate()
+}
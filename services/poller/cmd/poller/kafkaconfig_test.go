@@ -0,0 +1,80 @@
+package main
+
+import (
+	stringspkg "strings"
+	testingpkg "testing"
+	timepkg "time"
+
+	"github.com/IBM/sarama"
+)
+
+func TestProducerSettingsApply(t *testingpkg.T) {
+	defaults := producerSettings{idempotent: true, acks: "all", compression: "none"}
+	for _, tc := range []struct {
+		name     string
+		settings producerSettings
+		// noRetries turns off the producer's own retries
+		noRetries bool
+		wantErr   string
+		check     func(t *testingpkg.T, cfg *sarama.Config)
+	}{
+		{
+			name:     "defaults",
+			settings: defaults,
+			check: func(t *testingpkg.T, cfg *sarama.Config) {
+				if !cfg.Producer.Idempotent || cfg.Net.MaxOpenRequests != 1 {
+					t.Errorf("idempotent = %v with %d requests in flight, want true with 1", cfg.Producer.Idempotent, cfg.Net.MaxOpenRequests)
+				}
+				if cfg.Producer.RequiredAcks != sarama.WaitForAll || cfg.Producer.Compression != sarama.CompressionNone {
+					t.Errorf("acks %v, compression %v; want all, none", cfg.Producer.RequiredAcks, cfg.Producer.Compression)
+				}
+			},
+		},
+		{
+			name:     "leader acks without idempotence",
+			settings: producerSettings{acks: "leader", compression: "zstd"},
+			check: func(t *testingpkg.T, cfg *sarama.Config) {
+				if cfg.Producer.Idempotent || cfg.Net.MaxOpenRequests != sarama.NewConfig().Net.MaxOpenRequests {
+					t.Errorf("idempotent = %v with %d requests in flight, want sarama's defaults", cfg.Producer.Idempotent, cfg.Net.MaxOpenRequests)
+				}
+				if cfg.Producer.RequiredAcks != sarama.WaitForLocal || cfg.Producer.Compression != sarama.CompressionZSTD {
+					t.Errorf("acks %v, compression %v; want leader, zstd", cfg.Producer.RequiredAcks, cfg.Producer.Compression)
+				}
+			},
+		},
+		{
+			name:     "batching",
+			settings: producerSettings{acks: "none", compression: "lz4", flushFrequency: 50 * timepkg.Millisecond, flushMessages: 500, flushBytes: 1 << 20},
+			check: func(t *testingpkg.T, cfg *sarama.Config) {
+				f := cfg.Producer.Flush
+				if f.Frequency != 50*timepkg.Millisecond || f.Messages != 500 || f.Bytes != 1<<20 {
+					t.Errorf("flush = %+v, want every 50ms, 500 messages or 1MiB", f)
+				}
+			},
+		},
+		{name: "unknown acks", settings: producerSettings{acks: "2", compression: "none"}, wantErr: "KAFKA_ACKS"},
+		{name: "unknown codec", settings: producerSettings{acks: "all", compression: "brotli"}, wantErr: "KAFKA_COMPRESSION"},
+		{name: "idempotent with leader acks", settings: producerSettings{idempotent: true, acks: "leader", compression: "none"}, wantErr: "requires KAFKA_ACKS=all"},
+		{name: "idempotent without retries", settings: defaults, noRetries: true, wantErr: "requires producer retries"},
+		// left to sarama's own validation
+		{name: "negative flush frequency", settings: producerSettings{acks: "all", compression: "none", flushFrequency: -timepkg.Second}, wantErr: "Flush.Frequency"},
+	} {
+		t.Run(tc.name, func(t *testingpkg.T) {
+			cfg := kafkaSecurity{}.config()
+			if tc.noRetries {
+				cfg.Producer.Retry.Max = 0
+			}
+			err := tc.settings.apply(cfg)
+			if tc.wantErr != "" {
+				if err == nil || !stringspkg.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("err = %v, want one mentioning %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			tc.check(t, cfg)
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	tlspkg "crypto/tls"
+	x509pkg "crypto/x509"
+	errorspkg "errors"
+	fmtpkg "fmt"
+	ospkg "os"
+	stringspkg "strings"
+
+	"github.com/IBM/sarama"
+	"github.com/xdg-go/scram"
+)
+
+// kafkaSecurity is how every Kafka connection the poller opens (producers,
+// the watch consumer group and the checkpoint reader) authenticates. The
+// zero value is plaintext.
+type kafkaSecurity struct {
+	protocol  string
+	tls       *tlspkg.Config
+	mechanism string
+	user      string
+	password  string
+}
+
+// kafkaSecurityFromEnv reads KAFKA_SECURITY_PROTOCOL (PLAINTEXT, SSL,
+// SASL_PLAINTEXT or SASL_SSL, as in the Java client) and the KAFKA_TLS_* and
+// KAFKA_SASL_* settings it needs. Certificates and credentials are loaded
+// here, so a missing file or mismatched setting fails at startup instead of
+// as a broker error on first connect.
+func kafkaSecurityFromEnv() (kafkaSecurity, error) {
+	s := kafkaSecurity{protocol: stringspkg.ToUpper(getenv("KAFKA_SECURITY_PROTOCOL", "PLAINTEXT"))}
+	var useTLS, useSASL bool
+	switch s.protocol {
+	case "PLAINTEXT":
+	case "SSL":
+		useTLS = true
+	case "SASL_PLAINTEXT":
+		useSASL = true
+	case "SASL_SSL":
+		useTLS, useSASL = true, true
+	default:
+		return s, fmtpkg.Errorf("KAFKA_SECURITY_PROTOCOL: unknown protocol %q (PLAINTEXT, SSL, SASL_PLAINTEXT or SASL_SSL)", s.protocol)
+	}
+
+	caFile := getenv("KAFKA_TLS_CA_FILE", "")
+	certFile := getenv("KAFKA_TLS_CERT_FILE", "")
+	keyFile := getenv("KAFKA_TLS_KEY_FILE", "")
+	insecure := getenvBool("KAFKA_TLS_INSECURE_SKIP_VERIFY", false)
+	if !useTLS && (caFile != "" || certFile != "" || keyFile != "" || insecure) {
+		return s, fmtpkg.Errorf("KAFKA_TLS_* is set but KAFKA_SECURITY_PROTOCOL=%s does not use TLS", s.protocol)
+	}
+	if useTLS {
+		s.tls = &tlspkg.Config{MinVersion: tlspkg.VersionTLS12, InsecureSkipVerify: insecure}
+		if caFile != "" {
+			pem, err := ospkg.ReadFile(caFile)
+			if err != nil {
+				return s, fmtpkg.Errorf("KAFKA_TLS_CA_FILE: %w", err)
+			}
+			s.tls.RootCAs = x509pkg.NewCertPool()
+			if !s.tls.RootCAs.AppendCertsFromPEM(pem) {
+				return s, fmtpkg.Errorf("KAFKA_TLS_CA_FILE: no PEM certificates in %s", caFile)
+			}
+		}
+		if (certFile == "") != (keyFile == "") {
+			return s, errorspkg.New("KAFKA_TLS_CERT_FILE and KAFKA_TLS_KEY_FILE must be set together")
+		}
+		if certFile != "" {
+			cert, err := tlspkg.LoadX509KeyPair(certFile, keyFile)
+			if err != nil {
+				return s, fmtpkg.Errorf("kafka client certificate: %w", err)
+			}
+			s.tls.Certificates = []tlspkg.Certificate{cert}
+		}
+	}
+
+	mechanism := getenv("KAFKA_SASL_MECHANISM", "")
+	if !useSASL {
+		if mechanism != "" {
+			return s, fmtpkg.Errorf("KAFKA_SASL_MECHANISM is set but KAFKA_SECURITY_PROTOCOL=%s does not use SASL", s.protocol)
+		}
+		return s, nil
+	}
+	s.mechanism = stringspkg.ToUpper(mechanism)
+	if s.mechanism == "" {
+		s.mechanism = sarama.SASLTypePlaintext
+	}
+	switch s.mechanism {
+	case sarama.SASLTypePlaintext, sarama.SASLTypeSCRAMSHA256, sarama.SASLTypeSCRAMSHA512:
+	default:
+		return s, fmtpkg.Errorf("KAFKA_SASL_MECHANISM: unsupported mechanism %q (PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512)", mechanism)
+	}
+	var err error
+	if s.user, err = secretFromEnv("KAFKA_SASL_USERNAME"); err != nil {
+		return s, err
+	}
+	if s.password, err = secretFromEnv("KAFKA_SASL_PASSWORD"); err != nil {
+		return s, err
+	}
+	if s.user == "" || s.password == "" {
+		return s, fmtpkg.Errorf("KAFKA_SECURITY_PROTOCOL=%s needs KAFKA_SASL_USERNAME and KAFKA_SASL_PASSWORD (or their _FILE variants)", s.protocol)
+	}
+	return s, nil
+}
+
+// secretFromEnv returns the value of key or, when key_FILE is set instead,
+// the trimmed contents of that file, as mounted Kubernetes secrets are.
+func secretFromEnv(key string) (string, error) {
+	path := getenv(key+"_FILE", "")
+	if path == "" {
+		return getenv(key, ""), nil
+	}
+	if getenv(key, "") != "" {
+		return "", fmtpkg.Errorf("%s and %s_FILE are both set", key, key)
+	}
+	b, err := ospkg.ReadFile(path)
+	if err != nil {
+		return "", fmtpkg.Errorf("%s_FILE: %w", key, err)
+	}
+	return stringspkg.TrimSpace(string(b)), nil
+}
+
+// config returns a new sarama config with s applied.
+func (s kafkaSecurity) config() *sarama.Config {
+	cfg := sarama.NewConfig()
+	if s.tls != nil {
+		cfg.Net.TLS.Enable = true
+		cfg.Net.TLS.Config = s.tls
+	}
+	if s.mechanism != "" {
+		cfg.Net.SASL.Enable = true
+		cfg.Net.SASL.Mechanism = sarama.SASLMechanism(s.mechanism)
+		cfg.Net.SASL.User = s.user
+		cfg.Net.SASL.Password = s.password
+		switch s.mechanism {
+		case sarama.SASLTypeSCRAMSHA256:
+			cfg.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient { return &scramClient{hash: scram.SHA256} }
+		case sarama.SASLTypeSCRAMSHA512:
+			cfg.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient { return &scramClient{hash: scram.SHA512} }
+		}
+	}
+	return cfg
+}
+
+// scramClient adapts xdg-go/scram to sarama's SCRAMClient.
+type scramClient struct {
+	hash scram.HashGeneratorFcn
+	conv *scram.ClientConversation
+}
+
+func (c *scramClient) Begin(user, password, authzID string) error {
+	client, err := c.hash.NewClient(user, password, authzID)
+	if err != nil {
+		return err
+	}
+	c.conv = client.NewConversation()
+	return nil
+}
+
+func (c *scramClient) Step(challenge string) (string, error) {
+	return c.conv.Step(challenge)
+}
+
+func (c *scramClient) Done() bool {
+	return c.conv.Done()
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	fmtpkg "fmt"
+	slogpkg "log/slog"
+	ospkg "os"
+	stringspkg "strings"
+)
+
+// setupLogging makes a JSON slog logger at level (debug, info, warn or
+// error) the default, tagging every line with the tenant. Anything still
+// written through the standard log package goes through it at info level.
+func setupLogging(level, tenant string) error {
+	var lvl slogpkg.Level
+	if err := lvl.UnmarshalText([]byte(stringspkg.TrimSpace(level))); err != nil {
+		return fmtpkg.Errorf("LOG_LEVEL: unknown level %q", level)
+	}
+	h := slogpkg.NewJSONHandler(ospkg.Stderr, &slogpkg.HandlerOptions{Level: lvl})
+	logger := slogpkg.New(h)
+	if tenant != "" {
+		logger = logger.With("tenant", tenant)
+	}
+	slogpkg.SetDefault(logger)
+	return nil
+}
+
+// logFor returns the default logger tagged with component.
+func logFor(component string) *slogpkg.Logger {
+	return slogpkg.Default().With("component", component)
+}
+
+// fatal logs msg at error level and exits. It is for startup failures only;
+// once the poller is running, errors are logged and retried.
+func fatal(msg string, args ...any) {
+	slogpkg.Error(msg, args...)
+	ospkg.Exit(1)
+}
@@ -2,20 +2,20 @@ package main
 
 import (
 	contextpkg "context"
-	encodingjson "encoding/json"
-	iopkg "io"
-	logpkg "log"
 	mathbig "math/big"
 	nethttppkg "net/http"
 	ospkg "os"
-	hexpkg "encoding/hex"
-	stringspkg "strings"
+	signalpkg "os/signal"
+	strconvpkg "strconv"
+	syscallpkg "syscall"
 	timepkg "time"
 
 	"github.com/IBM/sarama"
-	"github.com/ethereum/go-ethereum/ethclient"
-	typespkg "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/example/gas-monitor-poller/internal/rpcstats"
 	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func getenv(key, def string) string {
@@ -26,192 +26,665 @@ func getenv(key, def string) string {
 	return v
 }
 
+func getenvUint(key string, def uint64) uint64 {
+	v := ospkg.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconvpkg.ParseUint(v, 10, 64)
+	if err != nil {
+		fatal("invalid number", "env", key, "value", v)
+	}
+	return n
+}
+
+// confirmedTip is the highest block that has at least confirmations blocks
+// built on top of it.
+func confirmedTip(head, confirmations uint64) uint64 {
+	if head < confirmations {
+		return 0
+	}
+	return head - confirmations
+}
+
+func getenvBool(key string, def bool) bool {
+	v := ospkg.Getenv(key)
+	if v == "" {
+		return def
+	}
+	b, err := strconvpkg.ParseBool(v)
+	if err != nil {
+		fatal("invalid boolean", "env", key, "value", v)
+	}
+	return b
+}
+
+func getenvFloat(key string, def float64) float64 {
+	v := ospkg.Getenv(key)
+	if v == "" {
+		return def
+	}
+	f, err := strconvpkg.ParseFloat(v, 64)
+	if err != nil {
+		fatal("invalid number", "env", key, "value", v)
+	}
+	return f
+}
+
+func getenvDuration(key string, def timepkg.Duration) timepkg.Duration {
+	v := ospkg.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := timepkg.ParseDuration(v)
+	if err != nil {
+		fatal("invalid duration", "env", key, "value", v)
+	}
+	return d
+}
+
+// sleepCtx waits for d or until ctx is cancelled, reporting whether the full
+// duration elapsed.
+func sleepCtx(ctx contextpkg.Context, d timepkg.Duration) bool {
+	t := timepkg.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
 func main() {
 	_ = godotenv.Load()
-	broker := getenv("KAFKA_BROKER", "kafka:9092")
-	topic := getenv("KAFKA_TOPIC", "onchain-gas")
-	rpcURL := getenv("ETH_RPC_URL", "")
+	// KAFKA_BROKER is the older, single-broker name
+	brokerList := getenv("KAFKA_BROKERS", getenv("KAFKA_BROKER", "kafka:9092"))
+	// KAFKA_TOPIC, CORRECTIONS_TOPIC and DEAD_LETTER_TOPIC are the older
+	// names of these variables
+	topic := getenv("KAFKA_TOPIC_GAS", getenv("KAFKA_TOPIC", "onchain-gas"))
+	watchTopic := getenv("KAFKA_TOPIC_WATCH_REQUESTS", "onchain-watch-requests")
+	correctionsTopic := getenv("KAFKA_TOPIC_CORRECTIONS", getenv("CORRECTIONS_TOPIC", "onchain-gas-corrections"))
+	dlqTopic := getenv("KAFKA_TOPIC_DLQ", getenv("DEAD_LETTER_TOPIC", "onchain-gas-dlq"))
+	// WATCH_DLQ_TOPIC is the older name of KAFKA_TOPIC_WATCH_REJECTS
+	watchDLQTopic := getenv("KAFKA_TOPIC_WATCH_REJECTS", getenv("WATCH_DLQ_TOPIC", "onchain-watch-requests-rejected"))
+	watchAckTopic := getenv("KAFKA_TOPIC_WATCH_ACKS", "onchain-watch-acks")
+	alertTopic := getenv("ALERT_TOPIC", "")
+	rpcURLs := parseRPCURLs(getenv("ETH_RPC_URLS", getenv("ETH_RPC_URL", "")))
 	tenant := getenv("TENANT_ID", "")
+	checkpointStore := getenv("CHECKPOINT_STORE", "file")
+	pollMode := getenv("POLL_MODE", "auto")
+	// the optional topics are named only when their feature is on
+	summaryTopic := featureTopic("SUMMARY_ENABLED", "SUMMARY_TOPIC", "onchain-gas-block-summaries")
+	markerTopic := featureTopic("KAFKA_TRANSACTIONS", "BLOCK_MARKER_TOPIC", "onchain-gas-block-markers")
+	var checkpointTopic string
+	if checkpointStore == "kafka" {
+		checkpointTopic = getenv("CHECKPOINT_TOPIC", "onchain-poller-checkpoints")
+	}
 
-	if rpcURL == "" || tenant == "" {
-		logpkg.Fatal("ETH_RPC_URL and TENANT_ID are required")
+	if err := setupLogging(getenv("LOG_LEVEL", "info"), tenant); err != nil {
+		fatal("logging setup", "err", err)
 	}
+	log := logFor("main")
 
-	targets := make(map[string]bool)
-	// bootstrap existing watches from API
-	apiBase := getenv("API_BASE", "http://api:4000")
-	func() {
-		req, _ := nethttppkg.NewRequest("GET", apiBase+"/internal/onchain/watches?tenantId="+tenant, nil)
-		resp, err := nethttppkg.DefaultClient.Do(req)
-		if err != nil {
-			logpkg.Printf("bootstrap watches: %v", err)
-			return
-		}
-		defer resp.Body.Close()
-		body, _ := iopkg.ReadAll(resp.Body)
-		var out struct{
-			Items []struct{ Contract string `json:"contract"` } `json:"items"`
-		}
-		_ = encodingjson.Unmarshal(body, &out)
-		for _, it := range out.Items {
-			targets[stringspkg.ToLower(it.Contract)] = true
-		}
-		logpkg.Printf("loaded %d watches", len(out.Items))
-	}()
+	if len(rpcURLs) == 0 || tenant == "" {
+		fatal("ETH_RPC_URL (or ETH_RPC_URLS) and TENANT_ID are required")
+	}
+	// EXPECTED_CHAIN_ID is the older name of CHAIN_ID; validateConfig
+	// rejects one that is not a number
+	var wantChainID *mathbig.Int
+	if raw := getenv("CHAIN_ID", getenv("EXPECTED_CHAIN_ID", "")); raw != "" {
+		wantChainID, _ = new(mathbig.Int).SetString(raw, 10)
+	}
 
-	client, err := ethclient.Dial(rpcURL)
+	ctx, stop := signalpkg.NotifyContext(contextpkg.Background(), syscallpkg.SIGINT, syscallpkg.SIGTERM)
+	defer stop()
+
+	// every problem is reported at once, before anything below can exit
+	// on the first
+	err := validateConfig(ctx, startupConfig{
+		rpcURLs: rpcURLs,
+		chainID: wantChainID,
+		kafka:   getenv("SINK", "kafka") == "kafka",
+		brokers: brokerList,
+		topics: []string{topic, watchTopic, correctionsTopic, dlqTopic, watchDLQTopic, watchAckTopic, alertTopic,
+			summaryTopic, markerTopic, checkpointTopic},
+	})
 	if err != nil {
-		logpkg.Fatalf("dial rpc: %v", err)
+		fatal("invalid configuration", "err", err)
 	}
-	defer client.Close()
+	log.Info("configuration checked")
+	reorgDepth := getenvUint("REORG_DEPTH", 64)
+	confirmations := getenvUint("CONFIRMATIONS", 3)
+	maxCatchup := getenvUint("MAX_CATCHUP_BLOCKS", 10000)
+
+	shutdownTimeout := getenvDuration("SHUTDOWN_TIMEOUT", 30*timepkg.Second)
+	go func() {
+		<-ctx.Done()
+		log.Info("shutdown requested, finishing in-flight block")
+		timepkg.AfterFunc(shutdownTimeout, func() {
+			log.Error("shutdown did not finish in time, forcing exit", "timeout", shutdownTimeout.String())
+			ospkg.Exit(1)
+		})
+	}()
 
-	cfg := sarama.NewConfig()
-	cfg.Producer.Return.Successes = true
-	producer, err := sarama.NewSyncProducer([]string{broker}, cfg)
+	shutdownTracing, err := setupTracing(ctx)
 	if err != nil {
-		logpkg.Fatalf("kafka producer: %v", err)
+		fatal("tracing setup", "err", err)
 	}
-	defer producer.Close()
 
-	// also consume dynamic watch updates
-	cfgC := sarama.NewConfig()
-	cfgC.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRoundRobin
-	consumer, err := sarama.NewConsumerGroup([]string{broker}, "onchain-watchers", cfgC)
+	bootstrapOptional := getenvBool("BOOTSTRAP_OPTIONAL", false)
+	hc := &health{
+		maxLag:            getenvUint("READY_MAX_LAG", 50),
+		rpcTimeout:        getenvDuration("READY_RPC_TIMEOUT", 60*timepkg.Second),
+		bootstrapRequired: !bootstrapOptional,
+	}
+	mux := nethttppkg.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	hc.register(mux)
+	go serveHTTP(ctx, "metrics/health", ":"+getenv("METRICS_PORT", "9090"), mux)
+
+	client, err := dialFailover(ctx, rpcURLs, int(getenvUint("RPC_FAILOVER_AFTER", 3)))
 	if err != nil {
-		logpkg.Fatalf("kafka consumer: %v", err)
+		fatal("dial rpc", "err", err)
 	}
-	go func() {
-		for {
-			err := consumer.Consume(contextpkg.Background(), []string{"onchain-watch-requests"}, consumerGroupHandler{targets: targets, tenant: tenant})
-			if err != nil {
-				logpkg.Printf("consume watch: %v", err)
-				timepkg.Sleep(2 * timepkg.Second)
+	defer client.Close()
+	client.rateLimitPause = getenvDuration("RPC_RATE_LIMIT_PAUSE", 5*timepkg.Second)
+	client.maxRegressions = int(getenvUint("RPC_HEAD_REGRESSIONS", 3))
+	stats := rpcstats.New(prometheus.DefaultRegisterer, "poller")
+	client.stats = stats
+	if every := getenvDuration("RPC_STATS_LOG_INTERVAL", timepkg.Minute); every > 0 {
+		go stats.LogEvery(ctx, every, logFor("rpc"))
+	}
+	client.timeouts = rpcTimeouts{
+		head:    getenvDuration("RPC_TIMEOUT_HEAD", 5*timepkg.Second),
+		block:   getenvDuration("RPC_TIMEOUT_BLOCK", 15*timepkg.Second),
+		receipt: getenvDuration("RPC_TIMEOUT_RECEIPT", 10*timepkg.Second),
+	}
+	// RPC_RPS is the same setting under the name other services use
+	client.limiter = newRPCLimiter(getenvFloat("RPC_RPS", getenvFloat("RPC_MAX_RPS", 0)), int(getenvUint("RPC_BURST", 0)))
+	client.breaker = newCircuitBreaker(int(getenvUint("RPC_BREAKER_THRESHOLD", 10)), getenvDuration("RPC_BREAKER_COOLDOWN", 30*timepkg.Second), func(s breakerState) {
+		hc.rpcCircuit.Store(int32(s))
+		rpcCircuitState.Set(float64(s))
+	})
+	hc.rpcReady.Store(true)
+	go client.healthLoop(ctx, getenvDuration("RPC_HEALTH_INTERVAL", 30*timepkg.Second))
+
+	var heads *headFeed
+	wsURL := getenv("ETH_WS_URL", firstWebSocketURL(rpcURLs))
+	if wsURL != "" && !isWebSocketURL(wsURL) {
+		fatal("ETH_WS_URL must be a ws:// or wss:// URL", "endpoint", endpointName(wsURL))
+	}
+	switch pollMode {
+	case "poll":
+	case "auto", "subscribe":
+		if wsURL == "" {
+			if pollMode == "subscribe" {
+				fatal("POLL_MODE=subscribe needs ETH_WS_URL or a ws:// or wss:// RPC URL")
 			}
+			break
 		}
-	}()
+		heads = newHeadFeed(wsURL)
+		go heads.run(ctx)
+	default:
+		fatal("POLL_MODE: unknown mode", "value", pollMode)
+	}
 
-	ctx := contextpkg.Background()
-	chainID, err := client.NetworkID(ctx)
+	// any other SINK replaces Kafka entirely
+	sinkKind := getenv("SINK", "kafka")
+	sink, err := sinkFromEnv(sinkKind)
 	if err != nil {
-		logpkg.Fatalf("network id: %v", err)
+		fatal("sink", "err", err)
+	}
+	useKafka := sink == nil
+	var (
+		brokers       []string
+		security      kafkaSecurity
+		settings      producerSettings
+		producer      sarama.SyncProducer
+		asyncProducer sarama.AsyncProducer
+	)
+	if useKafka {
+		if security, err = kafkaSecurityFromEnv(); err != nil {
+			fatal("kafka security", "err", err)
+		}
+		brokers, err = parseBrokers(brokerList)
+		if err != nil {
+			fatal("KAFKA_BROKERS", "err", err)
+		}
+		seed, clusterSize, err := bootstrapBroker(brokers, security.config())
+		if err != nil {
+			fatal("kafka metadata", "err", err)
+		}
+		log.Info("kafka metadata bootstrapped", "from", seed, "seeds", len(brokers), "clusterBrokers", clusterSize)
+		settings = producerSettingsFromEnv()
+		producerMode := getenv("PRODUCER_MODE", "async")
+		producer, asyncProducer, err = newProducers(brokers, security, settings, producerMode, int(getenvUint("KAFKA_SEND_ATTEMPTS", 5)))
+		if err != nil {
+			fatal("kafka producer", "err", err)
+		}
+		sink = &KafkaSink{producer: producer, topic: topic}
+		log.Info("kafka producer", "mode", producerMode, "security", security.protocol, "sasl", security.mechanism, "idempotent", settings.idempotent, "acks", settings.acks, "compression", settings.compression)
+	} else {
+		log.Info("publishing to sink instead of kafka", "sink", sinkKind)
 	}
-	// initialize last to current head on start to avoid backfill
-	head, err := client.BlockByNumber(ctx, nil)
+	hc.kafkaReady.Store(true)
+
+	chainID, err := client.ChainID(ctx)
 	if err != nil {
-		logpkg.Fatalf("get head: %v", err)
+		fatal("chain id", "err", err)
+	}
+	if wantChainID != nil {
+		chainID = wantChainID
+	}
+	// every endpoint, now and after any failover, must serve this chain
+	if err := client.pinChain(ctx, chainID); err != nil {
+		fatal("chain id", "err", err)
 	}
-	last := head.Number().Uint64()
+	log.Info("chain id", "chainId", chainID.String())
 
-	for {
-		head, err := client.BlockByNumber(ctx, nil)
+	targets := NewWatchSet()
+	targets.trackRemovals()
+	senders := NewWatchSet()
+	// bootstrap existing watches from API
+	api := &watchesClient{
+		base:     getenv("API_BASE", "http://api:4000"),
+		tenant:   tenant,
+		pageSize: int(getenvUint("WATCHES_PAGE_SIZE", 500)),
+		maxPages: int(getenvUint("WATCHES_MAX_PAGES", 1000)),
+		timeout:  getenvDuration("WATCHES_FETCH_TIMEOUT", timepkg.Minute),
+	}
+	// the cached watches stand in for the API's until it answers, and are
+	// enough to start with if it never does
+	cache := &watchCache{path: getenv("WATCH_CACHE_FILE", "poller.watches.json"), tenant: tenant, chainID: chainID.Uint64(), targets: targets, senders: senders}
+	cached := cache.load()
+	if err := bootstrapWatches(ctx, api, targets, senders, getenvDuration("BOOTSTRAP_DEADLINE", 2*timepkg.Minute)); err != nil {
+		switch {
+		case cached > 0:
+			log.Warn("watch bootstrap failed, continuing with the cached watches", "watches", cached, "err", err)
+			hc.bootstrapOK.Store(true)
+		case !bootstrapOptional:
+			fatal("bootstrap watches", "err", err)
+		default:
+			log.Warn("watch bootstrap failed, continuing with EMPTY targets until watch requests arrive (BOOTSTRAP_OPTIONAL=true)", "err", err)
+		}
+	} else {
+		hc.bootstrapOK.Store(true)
+	}
+	cache.save()
+	go cache.run(ctx, getenvDuration("WATCH_CACHE_INTERVAL", 5*timepkg.Second))
+	if every := getenvDuration("WATCH_REFRESH_INTERVAL", 5*timepkg.Minute); every > 0 {
+		go refreshWatches(ctx, api, targets, senders, every)
+	}
+
+	var ckpt Checkpointer = nopCheckpointer{}
+	switch checkpointStore {
+	case "file":
+		ckpt = fileCheckpointer{path: getenv("CHECKPOINT_FILE", "poller.checkpoint")}
+	case "kafka":
+		if !useKafka {
+			fatal("CHECKPOINT_STORE=kafka requires SINK=kafka")
+		}
+		ckpt = kafkaCheckpointer{
+			brokers:  brokers,
+			security: security,
+			producer: producer,
+			topic:    checkpointTopic,
+			key:      tenant + "|" + chainID.String(),
+			timeout:  getenvDuration("CHECKPOINT_LOAD_TIMEOUT", 30*timepkg.Second),
+		}
+	case "redis":
+		r, err := newRedisCheckpointer(getenv("CHECKPOINT_REDIS", "redis:6379"), "gas-poller:checkpoint:"+tenant+":"+chainID.String())
 		if err != nil {
-			logpkg.Printf("block err: %v", err)
-			timepkg.Sleep(3 * timepkg.Second)
-			continue
+			fatal("checkpoint store", "err", err)
+		}
+		ckpt = r
+	case "none":
+	default:
+		fatal("CHECKPOINT_STORE: unknown store", "value", checkpointStore)
+	}
+	tips, err := newTipSource(client, getenv("FINALITY", "latest"), confirmations)
+	if err != nil {
+		fatal("tip source", "err", err)
+	}
+	tip, err := tips.tip(ctx, nil)
+	if err != nil {
+		fatal("get head", "err", err)
+	}
+	start, err := parseStartPoint(ctx, client, tip)
+	if err != nil {
+		fatal("start point", "err", err)
+	}
+	resumed, err := resumeFrom(ckpt, tip, maxCatchup, start)
+	if err != nil {
+		fatal("load checkpoint", "err", err)
+	}
+	last := resumed.Block
+	backoffFactor := getenvFloat("BACKOFF_FACTOR", 2)
+	// PARTITION_KEY is the variable's old name
+	keyStrategy := getenv("KAFKA_KEY_STRATEGY", getenv("PARTITION_KEY", partitionByContract))
+	partitionKey, ok := parsePartitionKey(keyStrategy)
+	if !ok {
+		fatal("KAFKA_KEY_STRATEGY: unknown strategy", "value", keyStrategy)
+	}
+	var prices PriceProvider
+	// the default staleness limit suits a polled feed; Chainlink only
+	// writes a new ETH/USD round hourly when the price is steady
+	priceMaxAge := 5 * timepkg.Minute
+	priceURL, aggregator := getenv("PRICE_FEED_URL", ""), getenv("CHAINLINK_ETHUSD", "")
+	switch {
+	case priceURL != "" && aggregator != "":
+		fatal("PRICE_FEED_URL and CHAINLINK_ETHUSD are mutually exclusive")
+	case priceURL != "":
+		feed := newHTTPPriceFeed(priceURL)
+		go feed.run(ctx, getenvDuration("PRICE_POLL_INTERVAL", timepkg.Minute))
+		prices = feed
+	case aggregator != "":
+		addr, ok := normalizeAddress(aggregator)
+		if !ok {
+			fatal("CHAINLINK_ETHUSD: invalid address", "value", aggregator)
+		}
+		prices = newChainlinkFeed(client, common.HexToAddress(addr), getenvDuration("CHAINLINK_CACHE_TTL", timepkg.Minute))
+		priceMaxAge = 2 * timepkg.Hour
+	}
+	methods, err := newMethodResolver(getenv("ABI_DIR", ""), getenvBool("FOURBYTE_LOOKUP", false))
+	if err != nil {
+		fatal("load ABIs", "err", err)
+	}
+	pub := &publisher{
+		producer: producer,
+		retry: backoff{
+			attempts: int(getenvUint("KAFKA_SEND_ATTEMPTS", 5)),
+			base:     250 * timepkg.Millisecond,
+			max:      getenvDuration("KAFKA_SEND_MAX_DELAY", 10*timepkg.Second),
+		},
+		sink:           sink,
+		dlqTopic:       dlqTopic,
+		deadLetterPath: getenv("DEAD_LETTER_FILE", ""),
+	}
+	pub.registerReplay(mux)
+	if asyncProducer != nil {
+		pub.async = newAsyncSender(pub, asyncProducer, int(getenvUint("PRODUCER_MAX_INFLIGHT", 1000)))
+	}
+	var (
+		avro     *avroEncoder
+		protobuf *protobufEncoder
+	)
+	outputFormat := getenv("OUTPUT_FORMAT", "json")
+	registry := getenv("SCHEMA_REGISTRY_URL", "")
+	// Confluent's default TopicNameStrategy
+	subject := topic + "-value"
+	switch outputFormat {
+	case "json":
+	case "avro":
+		if registry == "" {
+			fatal("OUTPUT_FORMAT=avro requires SCHEMA_REGISTRY_URL")
+		}
+		if avro, err = newAvroEncoder(ctx, registry, subject); err != nil {
+			fatal("avro setup", "err", err)
+		}
+		log.Info("encoding gas events as avro", "subject", subject, "schemaId", avro.schemaID)
+	case "protobuf":
+		if protobuf, err = newProtobufEncoder(ctx, registry, subject); err != nil {
+			fatal("protobuf setup", "err", err)
+		}
+		log.Info("encoding gas events as protobuf", "subject", subject, "schemaId", protobuf.schemaID)
+	default:
+		fatal("OUTPUT_FORMAT: unknown format", "value", outputFormat)
+	}
+	if !useKafka && outputFormat != "json" {
+		fatal("SINK writes JSON lines; unset OUTPUT_FORMAT or use SINK=kafka", "format", outputFormat)
+	}
+	// KAFKA_TRANSACTIONS sends each block all-or-nothing instead of the
+	// best-effort delivery above; without Kafka it is off
+	if !useKafka {
+		markerTopic = ""
+	}
+	if markerTopic != "" {
+		txnProducer, txnID, err := newTxnProducer(brokers, security, settings, tenant, chainID.Uint64())
+		if err != nil {
+			fatal("kafka transactional producer", "err", err)
+		}
+		pub.txn = txnProducer
+		log.Info("kafka transactions enabled", "transactionalId", txnID, "markerTopic", markerTopic)
+	}
+	createTopics := getenvBool("KAFKA_CREATE_TOPICS", false)
+	if useKafka && (createTopics || getenvBool("KAFKA_CHECK_TOPICS", false)) {
+		topics := []kafkaTopic{
+			{name: topic},
+			{name: watchTopic},
+			{name: correctionsTopic},
+			{name: dlqTopic},
+			{name: watchDLQTopic},
+			{name: watchAckTopic},
+			{name: alertTopic},
+			{name: summaryTopic},
+			{name: markerTopic},
+			{name: checkpointTopic, compacted: true},
+		}
+		partitions := int32(getenvUint("KAFKA_TOPIC_PARTITIONS", 3))
+		replication := int16(getenvUint("KAFKA_TOPIC_REPLICATION", 1))
+		if err := ensureTopics(brokers, security, topics, createTopics, partitions, replication); err != nil {
+			fatal("kafka topics", "err", err)
+		}
+	}
+	if useKafka {
+		go pub.replayLoop(ctx, getenvDuration("DEAD_LETTER_REPLAY_INTERVAL", timepkg.Minute))
+	}
+	minCost, err := parseEthAmount(getenv("MIN_COST_ETH", ""))
+	if err != nil {
+		fatal("MIN_COST_ETH", "err", err)
+	}
+	// SPIKE_MULTIPLIER turns on gas spike alerts, to ALERT_TOPIC and/or
+	// ALERT_WEBHOOK_URL
+	var alerts *spikeAlerter
+	if multiplier := getenvFloat("SPIKE_MULTIPLIER", 0); multiplier > 0 {
+		alerts = newSpikeAlerter(multiplier, int(getenvUint("SPIKE_WINDOW", 100)), int(getenvUint("SPIKE_MIN_SAMPLES", 10)))
+		alerts.pub = pub
+		alerts.topic = alertTopic
+		if url := getenv("ALERT_WEBHOOK_URL", ""); url != "" {
+			retry := backoff{attempts: int(getenvUint("WEBHOOK_ATTEMPTS", 5)), base: 500 * timepkg.Millisecond, max: 30 * timepkg.Second}
+			alerts.startWebhook(newWebhookSink(url, getenv("ALERT_WEBHOOK_SECRET", ""), getenvDuration("WEBHOOK_TIMEOUT", 10*timepkg.Second), retry, 1, 0), 100)
 		}
-		if head.Number().Uint64() <= last {
-			timepkg.Sleep(2 * timepkg.Second)
-			continue
+		if alerts.topic == "" && alerts.webhook == nil {
+			fatal("SPIKE_MULTIPLIER needs ALERT_TOPIC or ALERT_WEBHOOK_URL")
 		}
-		for bn := last + 1; bn <= head.Number().Uint64(); bn++ {
-			blk, err := client.BlockByNumber(ctx, mathbig.NewInt(int64(bn)))
+		if alerts.window == 0 {
+			fatal("SPIKE_WINDOW must be at least 1")
+		}
+		alerts.register(mux)
+		log.Info("gas spike alerts enabled", "multiplier", multiplier, "window", alerts.window, "topic", alerts.topic, "webhook", alerts.webhook != nil)
+	}
+	p := &poller{
+		client:  client,
+		pub:     pub,
+		topic:   topic,
+		tenant:  tenant,
+		chainID: chainID,
+		network: getenv("NETWORK_NAME", networkNames[chainID.Uint64()]),
+		targets: targets,
+		senders: senders,
+		recent:  newBlockRing(reorgDepth),
+		ckpt:    ckpt,
+		health:  hc,
+		heads:   heads,
+		methods: methods,
+		dedup:   newDedupCache(int(getenvUint("DEDUP_CACHE_SIZE", 10000)), getenvDuration("DEDUP_TTL", timepkg.Hour)),
+
+		correctionsTopic: correctionsTopic,
+		partitionKey:     partitionKey,
+
+		includeBlobCost:   getenvBool("COST_INCLUDES_BLOBS", false),
+		preciseFees:       getenvBool("PRECISE_FEES", false),
+		checksumAddresses: getenvBool("ADDRESS_CHECKSUM", false),
+		minCost:           minCost,
+		alerts:            alerts,
+		summaryTopic:      summaryTopic,
+		markerTopic:       markerTopic,
+		avro:              avro,
+		protobuf:          protobuf,
+		prices:            prices,
+		priceMaxAge:       getenvDuration("PRICE_MAX_AGE", priceMaxAge),
+
+		tips:         tips,
+		last:         last,
+		catchupDelay: getenvDuration("CATCHUP_BLOCK_DELAY", 50*timepkg.Millisecond),
+		cadence: blockCadence{
+			min: getenvDuration("MIN_POLL_INTERVAL", 250*timepkg.Millisecond),
+			max: getenvDuration("MAX_POLL_INTERVAL", 15*timepkg.Second),
+		},
+
+		maxBlocksPerTick: getenvUint("MAX_BLOCKS_PER_TICK", 50),
+		catchupBatch:     getenvUint("BACKFILL_BATCH_SIZE", 0),
+		catchupLogEvery:  getenvUint("CATCHUP_LOG_EVERY", 1000),
+		lagAlert:         getenvUint("LAG_ALERT_BLOCKS", 100),
+
+		blockMaxAttempts: int(getenvUint("BLOCK_MAX_ATTEMPTS", 5)),
+		rpcBatch:         int(getenvUint("RPC_BATCH_SIZE", 20)),
+		blockWorkers:     int(getenvUint("BLOCK_WORKERS", 4)),
+		rpcRetry: backoff{
+			attempts: int(getenvUint("RPC_MAX_RETRIES", 3)) + 1,
+			base:     getenvDuration("RPC_BACKOFF_BASE", 500*timepkg.Millisecond),
+			max:      getenvDuration("RPC_BACKOFF_MAX", 10*timepkg.Second),
+			factor:   backoffFactor,
+		},
+		receiptRetry: backoff{
+			attempts: int(getenvUint("RECEIPT_ATTEMPTS", 5)),
+			base:     getenvDuration("RECEIPT_RETRY_DELAY", timepkg.Second),
+			max:      4 * timepkg.Second,
+			factor:   backoffFactor,
+		},
+		errBackoff: backoff{
+			base:   getenvDuration("ERROR_BACKOFF_BASE", timepkg.Second),
+			max:    getenvDuration("ERROR_BACKOFF_MAX", timepkg.Minute),
+			factor: backoffFactor,
+		},
+	}
+
+	if resumed.Hash != "" {
+		// the first block's parent is checked against the checkpointed hash,
+		// so a block reorged away while we were down is replayed
+		p.recent.Put(last, common.HexToHash(resumed.Hash), nil)
+	}
+	if last < tip {
+		p.catchupUntil, p.resuming = tip, true
+		catchingUp.Set(1)
+	}
+	p.batch.probe(ctx, client)
+	// the admin server is off unless it has a token to check
+	if token := getenv("ADMIN_TOKEN", ""); token != "" {
+		admin := &adminServer{token: token, targets: targets, senders: senders, matches: newMatchStats(), health: hc}
+		p.matches = admin.matches
+		if useKafka {
+			admin.kafka = func() error {
+				_, _, err := bootstrapBroker(brokers, security.config())
+				return err
+			}
+		}
+		go serveHTTP(ctx, "admin", ":"+getenv("ADMIN_PORT", "6060"), admin.handler())
+	}
+	if p.tracer.enabled = getenvBool("TRACE_ENABLED", false); p.tracer.enabled {
+		log.Info("tracing blocks to match internal calls")
+	}
+
+	backfill := newBackfiller(p, getenv("BACKFILL_STATE_FILE", "poller.backfill.json"), getenvDuration("BACKFILL_BLOCK_DELAY", 100*timepkg.Millisecond))
+	if err := backfill.resume(ctx); err != nil {
+		fatal("resume backfills", "err", err)
+	}
+
+	// also consume dynamic watch updates, except without Kafka, where the
+	// bootstrapped watches are all there is
+	var consumer sarama.ConsumerGroup
+	if useKafka {
+		cfgC := security.config()
+		cfgC.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRoundRobin
+		cfgC.Consumer.Return.Errors = true
+		// a group with no committed offset starts from the oldest request,
+		// so those sent while no poller ran are applied; replaying them is
+		// harmless
+		switch initial := getenv("KAFKA_CONSUMER_INITIAL_OFFSET", "oldest"); initial {
+		case "oldest":
+			cfgC.Consumer.Offsets.Initial = sarama.OffsetOldest
+		case "newest":
+			cfgC.Consumer.Offsets.Initial = sarama.OffsetNewest
+		default:
+			fatal("KAFKA_CONSUMER_INITIAL_OFFSET must be oldest or newest", "value", initial)
+		}
+		group := getenv("KAFKA_CONSUMER_GROUP", "onchain-watchers")
+		if consumer, err = sarama.NewConsumerGroup(brokers, group, cfgC); err != nil {
+			fatal("kafka consumer", "err", err)
+		}
+		go func() {
+			for err := range consumer.Errors() {
+				kafkaConsumerErrors.Inc()
+				logFor("kafka").Warn("watch request consumer error", "group", group, "err", err)
+			}
+		}()
+	}
+	// the hostname is the container ID under Docker
+	hostname, _ := ospkg.Hostname()
+	handler := consumerGroupHandler{
+		ctx:        ctx,
+		targets:    targets,
+		senders:    senders,
+		tenant:     tenant,
+		chainID:    chainID.Uint64(),
+		backfill:   backfill,
+		pub:        pub,
+		dlqTopic:   watchDLQTopic,
+		ackTopic:   watchAckTopic,
+		instance:   getenv("POLLER_INSTANCE_ID", hostname),
+		maxWatches: int(getenvUint("MAX_WATCHES", 0)),
+		cache:      cache,
+		tip: func(ctx contextpkg.Context) (uint64, error) {
+			return tips.tip(ctx, nil)
+		},
+	}
+	if debounce := getenvUint("WATCH_DEBOUNCE_MS", 500); debounce > 0 {
+		handler.debounce = newWatchDebouncer(timepkg.Duration(debounce)*timepkg.Millisecond, handler.applied)
+	}
+	consumerDone := make(chan struct{})
+	consumeWait := backoffTimer{b: p.errBackoff}
+	go func() {
+		defer close(consumerDone)
+		for consumer != nil && ctx.Err() == nil {
+			err := consumer.Consume(ctx, []string{watchTopic}, handler)
 			if err != nil {
-				logpkg.Printf("block %d err: %v", bn, err)
+				log.Warn("consume watch requests", "err", err)
+				consumeWait.wait(ctx)
 				continue
 			}
-			for _, tx := range blk.Transactions() {
-				if tx.To() == nil { // contract creation
-					continue
-				}
-				to := stringspkg.ToLower(tx.To().Hex())
-				if !targets[to] {
-					continue
-				}
-				rec, err := client.TransactionReceipt(ctx, tx.Hash())
-				if err != nil {
-					continue
-				}
-				from := ""
-				if tx != nil {
-					// derive sender
-					signer := typespkg.LatestSignerForChainID(chainID)
-					addr, err := typespkg.Sender(signer, tx)
-					if err == nil {
-						from = stringspkg.ToLower(addr.Hex())
-					}
-				}
-				methodSig := ""
-				if data := tx.Data(); len(data) >= 4 {
-					methodSig = "0x" + hexpkg.EncodeToString(data[:4])
-				}
-				// fees
-				effPriceWei := new(mathbig.Int)
-				if rec.EffectiveGasPrice != nil {
-					effPriceWei = rec.EffectiveGasPrice
-				} else if tx.GasPrice() != nil {
-					effPriceWei = tx.GasPrice()
-				}
-				baseFeeWei := blk.BaseFee()
-				priorityWei := new(mathbig.Int).Sub(effPriceWei, baseFeeWei)
-				if priorityWei.Sign() < 0 { priorityWei = mathbig.NewInt(0) }
-				// convert to gwei floats
-				gweiDiv := mathbig.NewFloat(1e9)
-				effGwei := new(mathbig.Float).Quo(new(mathbig.Float).SetInt(effPriceWei), gweiDiv)
-				baseGwei := new(mathbig.Float).Quo(new(mathbig.Float).SetInt(baseFeeWei), gweiDiv)
-				prioGwei := new(mathbig.Float).Quo(new(mathbig.Float).SetInt(priorityWei), gweiDiv)
-				effGweiF, _ := effGwei.Float64()
-				baseGweiF, _ := baseGwei.Float64()
-				prioGweiF, _ := prioGwei.Float64()
-				// cost in ETH
-				weiPerEth := mathbig.NewFloat(1e18)
-				gasUsedF := new(mathbig.Float).SetInt64(int64(rec.GasUsed))
-				costWeiF := new(mathbig.Float).Mul(new(mathbig.Float).SetInt(effPriceWei), gasUsedF)
-				costEthF := new(mathbig.Float).Quo(costWeiF, weiPerEth)
-				costEth, _ := costEthF.Float64()
-				payload := map[string]any{
-					"tenantId": tenant,
-					"contract": to,
-					"txHash": tx.Hash().Hex(),
-					"blockNumber": blk.Number().Uint64(),
-					"timestamp": blk.Time(),
-					"from": from,
-					"to": to,
-					"methodSignature": methodSig,
-					"gasUsed": rec.GasUsed,
-					"effectiveGasPriceGwei": effGweiF,
-					"baseFeeGwei": baseGweiF,
-					"priorityFeeGwei": prioGweiF,
-					"costEth": costEth,
-				}
-				value, _ := encodingjson.Marshal(payload)
-				msg := &sarama.ProducerMessage{Topic: topic, Value: sarama.ByteEncoder(value)}
-				_, _, _ = producer.SendMessage(msg)
-			}
+			consumeWait.reset()
 		}
-		last = head.NumberU64()
+	}()
+
+	log.Info("processing blocks up to the tip", "source", tips.String(), "block", last)
+	p.run(ctx)
+
+	// the watch consumer can still start backfills and dead-letter requests
+	// until it stops, so wait for it before flushing the producer
+	<-consumerDone
+	if handler.debounce != nil {
+		// each session's Cleanup applies what it debounced; this catches
+		// anything left over
+		handler.debounce.flush()
+	}
+	cache.save()
+	backfill.Wait()
+	log.Info("shutting down, flushing producer")
+	pub.close()
+	if alerts != nil {
+		alerts.close()
 	}
+	if consumer != nil {
+		if err := consumer.Close(); err != nil {
+			log.Warn("close consumer", "err", err)
+		}
+	}
+	flushCtx, cancel := contextpkg.WithTimeout(contextpkg.Background(), 5*timepkg.Second)
+	if err := shutdownTracing(flushCtx); err != nil {
+		log.Warn("flush traces", "err", err)
+	}
+	cancel()
+	log.Info("shutdown complete", "block", p.last)
 }
-
-type consumerGroupHandler struct{ targets map[string]bool; tenant string }
-
-func (h consumerGroupHandler) Setup(s sarama.ConsumerGroupSession) error   { return nil }
-func (h consumerGroupHandler) Cleanup(s sarama.ConsumerGroupSession) error { return nil }
-func (h consumerGroupHandler) ConsumeClaim(s sarama.ConsumerGroupSession, c sarama.ConsumerGroupClaim) error {
-	for msg := range c.Messages() {
-		var payload struct{
-			TenantId string `json:"tenantId"`
-			Contract string `json:"contract"`
-			Action string `json:"action"`
-		}
-		_ = encodingjson.Unmarshal(msg.Value, &payload)
-		if payload.TenantId != h.tenant { continue }
-		address := stringspkg.ToLower(payload.Contract)
-		if payload.Action == "add" {
-			h.targets[address] = true
-		} else if payload.Action == "remove" {
-			delete(h.targets, address)
-		}
-		s.MarkMessage(msg, "")
-	}
-	return nil
-}
\ No newline at end of file
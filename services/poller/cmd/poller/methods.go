@@ -0,0 +1,152 @@
+package main
+
+import (
+	contextpkg "context"
+	encodingjson "encoding/json"
+	fmtpkg "fmt"
+	nethttppkg "net/http"
+	ospkg "os"
+	filepathpkg "path/filepath"
+	stringspkg "strings"
+	syncpkg "sync"
+	timepkg "time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+)
+
+const fourByteURL = "https://www.4byte.directory/api/v1/signatures/?hex_signature="
+
+// methodResolver turns 4-byte selectors into method signatures such as
+// transfer(address,uint256), using per-contract ABIs from ABI_DIR and,
+// optionally, the public 4byte.directory.
+type methodResolver struct {
+	abis map[string]*abi.ABI
+
+	fourByte bool
+	http     *nethttppkg.Client
+	mu       syncpkg.Mutex
+	// cache holds 4byte answers by selector, including misses as ""
+	cache map[string]string
+}
+
+// newMethodResolver loads every <address>.json file in dir. A file may hold
+// a bare ABI array or a build artifact with an "abi" field. An empty dir
+// loads nothing.
+func newMethodResolver(dir string, fourByte bool) (*methodResolver, error) {
+	r := &methodResolver{
+		abis:     make(map[string]*abi.ABI),
+		fourByte: fourByte,
+		http:     &nethttppkg.Client{Timeout: 5 * timepkg.Second},
+		cache:    make(map[string]string),
+	}
+	if dir == "" {
+		return r, nil
+	}
+	if _, err := ospkg.Stat(dir); err != nil {
+		return nil, fmtpkg.Errorf("ABI_DIR: %w", err)
+	}
+	files, err := filepathpkg.Glob(filepathpkg.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		data, err := ospkg.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		parsed, err := parseABI(data)
+		if err != nil {
+			return nil, fmtpkg.Errorf("ABI %s: %w", f, err)
+		}
+		addr := stringspkg.ToLower(stringspkg.TrimSuffix(filepathpkg.Base(f), ".json"))
+		r.abis[addr] = parsed
+	}
+	logFor("methods").Info("loaded contract ABIs", "count", len(r.abis), "dir", dir)
+	return r, nil
+}
+
+func parseABI(data []byte) (*abi.ABI, error) {
+	var artifact struct {
+		ABI encodingjson.RawMessage `json:"abi"`
+	}
+	if err := encodingjson.Unmarshal(data, &artifact); err == nil && len(artifact.ABI) > 0 {
+		data = artifact.ABI
+	}
+	parsed, err := abi.JSON(stringspkg.NewReader(string(data)))
+	if err != nil {
+		return nil, err
+	}
+	return &parsed, nil
+}
+
+// resolve returns the signature for selector called on contract, or "" when
+// neither the contract's ABI nor 4byte.directory knows it.
+func (r *methodResolver) resolve(ctx contextpkg.Context, contract string, selector []byte) string {
+	if r == nil || len(selector) < 4 {
+		return ""
+	}
+	if a, ok := r.abis[contract]; ok {
+		if m, err := a.MethodById(selector[:4]); err == nil {
+			return m.Sig
+		}
+	}
+	if !r.fourByte {
+		return ""
+	}
+	return r.lookupFourByte(ctx, fmtpkg.Sprintf("0x%x", selector[:4]))
+}
+
+// lookupFourByte asks 4byte.directory for a selector. When several
+// signatures collide the oldest registration wins, as it is almost always
+// the real one. Failed lookups are not cached so they are retried later.
+func (r *methodResolver) lookupFourByte(ctx contextpkg.Context, selector string) string {
+	r.mu.Lock()
+	sig, ok := r.cache[selector]
+	r.mu.Unlock()
+	if ok {
+		return sig
+	}
+	sig, err := r.fetchFourByte(ctx, selector)
+	if err != nil {
+		logFor("methods").Debug("4byte lookup failed", "selector", selector, "err", err)
+		return ""
+	}
+	r.mu.Lock()
+	r.cache[selector] = sig
+	r.mu.Unlock()
+	return sig
+}
+
+func (r *methodResolver) fetchFourByte(ctx contextpkg.Context, selector string) (string, error) {
+	req, err := nethttppkg.NewRequestWithContext(ctx, "GET", fourByteURL+selector, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := r.http.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != nethttppkg.StatusOK {
+		return "", fmtpkg.Errorf("status %d", resp.StatusCode)
+	}
+	var out struct {
+		Results []struct {
+			ID   int    `json:"id"`
+			Text string `json:"text_signature"`
+		} `json:"results"`
+	}
+	if err := encodingjson.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", err
+	}
+	best := -1
+	for i, res := range out.Results {
+		if best < 0 || res.ID < out.Results[best].ID {
+			best = i
+		}
+	}
+	if best < 0 {
+		return "", nil
+	}
+	return out.Results[best].Text, nil
+}
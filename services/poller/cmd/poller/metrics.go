@@ -0,0 +1,185 @@
+package main
+
+import (
+	contextpkg "context"
+	errorspkg "errors"
+	nethttppkg "net/http"
+	timepkg "time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	blocksProcessed = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "poller_blocks_processed_total",
+		Help: "Blocks fully processed by the poll loop",
+	})
+	blocksSkippedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "poller_blocks_skipped_total",
+		Help: "Blocks given up on after repeated fetch failures",
+	})
+	matchedTxs = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "poller_matched_txs_total",
+		Help: "Transactions that targeted a watched contract",
+	})
+	receiptsFailedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "poller_receipts_failed_total",
+		Help: "Matched transactions emitted without a receipt after retries",
+	})
+	duplicatesSuppressed = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "poller_duplicates_suppressed_total",
+		Help: "Matched transactions not re-emitted because the same tx and block were already sent",
+	})
+	kafkaSent = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "poller_kafka_messages_sent_total",
+		Help: "Messages acknowledged by Kafka",
+	})
+	kafkaSendErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "poller_kafka_send_errors_total",
+		Help: "Failed Kafka send attempts",
+	})
+	kafkaTxns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "poller_kafka_transactions_total",
+		Help: "Kafka transactions (KAFKA_TRANSACTIONS) by outcome: committed or aborted",
+	}, []string{"outcome"})
+	kafkaInflight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "poller_kafka_inflight",
+		Help: "Events handed to the async producer and not yet acknowledged",
+	})
+	kafkaInflightLimit = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "poller_kafka_inflight_limit",
+		Help: "PRODUCER_MAX_INFLIGHT, the async producer's in-flight bound",
+	})
+	webhookSent = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "poller_webhook_sent_total",
+		Help: "Messages delivered by SINK=webhook",
+	})
+	webhookFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "poller_webhook_failures_total",
+		Help: "Messages SINK=webhook dropped after its retries failed",
+	})
+	dlqPublished = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "poller_dlq_published_total",
+		Help: "Messages published to KAFKA_TOPIC_DLQ after their Kafka sends failed",
+	})
+	deadLettered = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "poller_dead_lettered_total",
+		Help: "Messages parked in the dead-letter file after Kafka sends failed",
+	})
+	deadLettersReplayed = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "poller_dead_letters_replayed_total",
+		Help: "Parked messages later delivered from the dead-letter file",
+	})
+	belowMinCost = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "poller_events_below_min_cost_total",
+		Help: "Matched txs not emitted because they cost less than their watch's minCostEth or MIN_COST_ETH",
+	})
+	gasSpikes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "poller_gas_spikes_total",
+		Help: "Txs whose effective gas price exceeded SPIKE_MULTIPLIER times their contract's rolling average",
+	}, []string{"contract"})
+	kafkaConsumerErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "poller_kafka_consumer_errors_total",
+		Help: "Errors reported by the watch request consumer group, such as failed rebalances or offset commits",
+	})
+	backfillsCompleted = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "poller_backfills_completed_total",
+		Help: "Backfill jobs, from backfill requests or adds with fromBlock, that reached their end block",
+	})
+	rpcHeadRegressions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "poller_rpc_head_regressions_total",
+		Help: "Latest blocks an endpoint returned below the highest already seen, by endpoint",
+	}, []string{"endpoint"})
+	invalidWatches = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "poller_invalid_watches_total",
+		Help: "Watches from the API or watch requests rejected for a malformed address",
+	})
+	watchRequestsDeadLettered = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "poller_watch_requests_dead_lettered_total",
+		Help: "Watch requests rejected as unprocessable, published to KAFKA_TOPIC_WATCH_REJECTS when set",
+	})
+	rpcErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "poller_rpc_errors_total",
+		Help: "Failed RPC calls",
+	})
+	rpcTimeoutsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "poller_rpc_timeouts_total",
+		Help: "RPC calls that hit their RPC_TIMEOUT_* limit, by call kind",
+	}, []string{"call"})
+	tracesFailed = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "poller_traces_failed_total",
+		Help: "Blocks whose call trace could not be fetched, so their internal calls were not matched",
+	})
+	revertedTxs = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "poller_reverted_txs_total",
+		Help: "Matched txs whose receipt status is reverted, by contract",
+	}, []string{"contract"})
+	rpcCircuitState = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "poller_rpc_circuit_state",
+		Help: "RPC circuit breaker state: 0 closed, 1 open, 2 half-open",
+	})
+	rateLimited = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "poller_rate_limited_total",
+		Help: "RPC calls rejected by the provider's rate limit, each pausing all RPC calls",
+	})
+	blockLagGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "poller_block_lag",
+		Help: "Confirmed tip minus last processed block",
+	})
+	fallingBehind = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "poller_falling_behind",
+		Help: "1 while block lag exceeds LAG_ALERT_BLOCKS",
+	})
+	catchingUp = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "poller_catching_up",
+		Help: "1 while replaying blocks up to the tip seen at startup, 0 while tailing live",
+	})
+	catchupRemaining = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "poller_catchup_remaining_blocks",
+		Help: "Blocks left before the current catch-up reaches its target",
+	})
+	rpcLimiterWait = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "poller_rpc_limiter_wait_seconds",
+		Help:    "Time RPC calls spent waiting on the RPC_MAX_RPS limiter",
+		Buckets: prometheus.ExponentialBuckets(0.001, 4, 8),
+	})
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "poller_price_age_seconds",
+		Help: "Seconds since the ETH/USD price was last updated, -1 before the first price",
+	}, func() float64 {
+		at := priceUpdatedAt.Load()
+		if at == 0 {
+			return -1
+		}
+		return timepkg.Since(timepkg.Unix(0, at)).Seconds()
+	})
+	blocksInPreparation = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "poller_blocks_in_preparation",
+		Help: "Blocks being fetched and prepared ahead of the loop (BLOCK_WORKERS)",
+	})
+	pollInterval = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "poller_poll_interval_seconds",
+		Help: "Current wait between polls for a new block, from the measured block time",
+	})
+	blockDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "poller_block_processing_seconds",
+		Help:    "Time spent processing a single block",
+		Buckets: prometheus.ExponentialBuckets(0.05, 2, 10),
+	})
+)
+
+// serveHTTP runs an HTTP server on addr until ctx is cancelled.
+func serveHTTP(ctx contextpkg.Context, name, addr string, handler nethttppkg.Handler) {
+	srv := &nethttppkg.Server{Addr: addr, Handler: handler, ReadHeaderTimeout: 5 * timepkg.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := contextpkg.WithTimeout(contextpkg.Background(), 5*timepkg.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+	logFor("http").Info("listening", "server", name, "addr", addr)
+	if err := srv.ListenAndServe(); err != nil && !errorspkg.Is(err, nethttppkg.ErrServerClosed) {
+		logFor("http").Error("server stopped", "server", name, "err", err)
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+// networkNames names the chains the poller is commonly run against, for the
+// networkName of events; NETWORK_NAME overrides it or names any other.
+var networkNames = map[uint64]string{
+	1:        "mainnet",
+	10:       "optimism",
+	56:       "bsc",
+	100:      "gnosis",
+	137:      "polygon",
+	324:      "zksync",
+	8453:     "base",
+	17000:    "holesky",
+	42161:    "arbitrum",
+	43114:    "avalanche",
+	59144:    "linea",
+	84532:    "base-sepolia",
+	421614:   "arbitrum-sepolia",
+	534352:   "scroll",
+	11155111: "sepolia",
+	11155420: "optimism-sepolia",
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	contextpkg "context"
+	sha256pkg "crypto/sha256"
+	hexpkg "encoding/hex"
+	mathbig "math/big"
+	stringspkg "strings"
+
+	typespkg "github.com/ethereum/go-ethereum/core/types"
+)
+
+// txPayload builds the gas event for tx. rec may be nil when the receipt
+// could not be fetched, in which case only the fields known from the block
+// are set and receiptMissing is true. methodName is empty when the selector
+// could not be resolved to a signature.
+func (p *poller) txPayload(ctx contextpkg.Context, blk *typespkg.Block, tx *typespkg.Transaction, rec *typespkg.Receipt) *GasEvent {
+	to := stringspkg.ToLower(tx.To().Hex())
+	from := p.sender(tx)
+	methodSig, methodName := "", ""
+	if data := tx.Data(); len(data) >= 4 {
+		methodSig = "0x" + hexpkg.EncodeToString(data[:4])
+		methodName = p.methods.resolve(ctx, to, data[:4])
+	}
+	ev := &GasEvent{
+		TenantID:        p.tenant,
+		ChainID:         p.chainID.Uint64(),
+		NetworkName:     p.network,
+		Contract:        to,
+		TxHash:          tx.Hash().Hex(),
+		EventID:         eventID(p.tenant, tx.Hash().Hex(), blk.Hash().Hex()),
+		BlockNumber:     blk.Number().Uint64(),
+		Timestamp:       blk.Time(),
+		From:            from,
+		To:              to,
+		MethodSignature: methodSig,
+		MethodName:      methodName,
+	}
+	if rec == nil {
+		ev.ReceiptMissing = true
+		ev.Status = "unknown"
+		return ev
+	}
+	reverted := rec.Status != typespkg.ReceiptStatusSuccessful
+	// a success that logged nothing often did nothing useful either
+	ev.LogsCount = ptr(len(rec.Logs))
+	ev.Status = "success"
+	if reverted {
+		ev.Status = "reverted"
+		ev.RevertReason = p.revertReason(ctx, blk, tx, from)
+	}
+	fees := feesOf(blk, tx, rec)
+	// convert to gwei floats
+	gweiDiv := mathbig.NewFloat(1e9)
+	effGwei := new(mathbig.Float).Quo(new(mathbig.Float).SetInt(fees.effectivePrice), gweiDiv)
+	effGweiF, _ := effGwei.Float64()
+	baseGweiF, _ := new(mathbig.Float).Quo(new(mathbig.Float).SetInt(fees.baseFee), gweiDiv).Float64()
+	prioGweiF, _ := new(mathbig.Float).Quo(new(mathbig.Float).SetInt(fees.priorityFee), gweiDiv).Float64()
+	// cost in ETH
+	weiPerEth := mathbig.NewFloat(1e18)
+	costEth, _ := new(mathbig.Float).Quo(new(mathbig.Float).SetInt(fees.cost), weiPerEth).Float64()
+	// exact amounts in wei go alongside the floats, or replace them with
+	// PRECISE_FEES
+	floats := !p.preciseFees
+	costWei := fees.cost
+	ev.GasUsed = ptr(rec.GasUsed)
+	ev.EffectiveGasPriceWei = fees.effectivePrice.String()
+	ev.BaseFeeWei = fees.baseFee.String()
+	ev.PriorityFeeWei = fees.priorityFee.String()
+	// the same amounts under the names some consumers read
+	ev.BaseFeePerGasWei, ev.PriorityFeePerGasWei = ev.BaseFeeWei, ev.PriorityFeeWei
+	if floats {
+		ev.EffectiveGasPriceGwei = ptr(effGweiF)
+		ev.BaseFeeGwei = ptr(baseGweiF)
+		ev.PriorityFeeGwei = ptr(prioGweiF)
+	}
+	ev.FeeModel = fees.model
+	if fees.blobCost != nil {
+		blobBaseGwei, _ := new(mathbig.Float).Quo(new(mathbig.Float).SetInt(rec.BlobGasPrice), gweiDiv).Float64()
+		blobCostEth, _ := new(mathbig.Float).Quo(new(mathbig.Float).SetInt(fees.blobCost), weiPerEth).Float64()
+		ev.BlobGasUsed = ptr(rec.BlobGasUsed)
+		ev.BlobBaseFeeWei = rec.BlobGasPrice.String()
+		ev.BlobCostWei = fees.blobCost.String()
+		if floats {
+			ev.BlobBaseFeeGwei = ptr(blobBaseGwei)
+			ev.BlobCostEth = ptr(blobCostEth)
+		}
+		if p.includeBlobCost {
+			costEth += blobCostEth
+			costWei = new(mathbig.Int).Add(costWei, fees.blobCost)
+		}
+	}
+	ev.CostWei = costWei.String()
+	if floats {
+		ev.CostEth = ptr(costEth)
+	}
+	if reverted {
+		// a reverted tx still pays for its gas; the cost is repeated here so
+		// consumers can total wasted spend without checking status
+		ev.RevertedCostWei = ev.CostWei
+		if floats {
+			ev.RevertedCostEth = ptr(costEth)
+		}
+	}
+	if price, ok := p.ethUSD(ctx); ok {
+		ev.EthUsdPrice = ptr(price)
+		ev.CostUsd = ptr(costEth * price)
+	}
+	ev.Transfers = erc20Transfers(rec, *tx.To())
+	return ev
+}
+
+// txFees are the exact wei amounts behind a gas event's fee fields.
+type txFees struct {
+	effectivePrice *mathbig.Int
+	baseFee        *mathbig.Int
+	priorityFee    *mathbig.Int
+	model          string
+	// cost is the execution gas alone, effectivePrice * gasUsed
+	cost *mathbig.Int
+	// blobCost is nil for txs without blob gas
+	blobCost *mathbig.Int
+}
+
+// belowMinCost reports whether ev costs less than the minimum of the watch
+// that matched it, or MIN_COST_ETH when that has none. The comparison is on
+// costWei, so it is exact. Events without a receipt have no cost and are
+// never suppressed.
+func (p *poller) belowMinCost(m txMatch, ev *GasEvent) bool {
+	set, addr := p.targets, m.to
+	if m.reason == watchFrom {
+		set, addr = p.senders, ev.From
+	}
+	min := set.MinCost(addr)
+	if min == nil {
+		min = p.minCost
+	}
+	if min == nil || ev.CostWei == "" {
+		return false
+	}
+	cost, ok := new(mathbig.Int).SetString(ev.CostWei, 10)
+	return ok && cost.Cmp(min) < 0
+}
+
+// feesOf works out tx's fees from its receipt.
+func feesOf(blk *typespkg.Block, tx *typespkg.Transaction, rec *typespkg.Receipt) txFees {
+	f := txFees{effectivePrice: new(mathbig.Int), model: "eip1559"}
+	if rec.EffectiveGasPrice != nil {
+		f.effectivePrice = rec.EffectiveGasPrice
+	} else if tx.GasPrice() != nil {
+		f.effectivePrice = tx.GasPrice()
+	}
+	// pre-London and non-EIP-1559 chains have no base fee: the whole gas
+	// price goes to the block producer, so it is all priority fee
+	f.baseFee = blk.BaseFee()
+	if f.baseFee == nil {
+		f.model = "legacy"
+		f.baseFee = new(mathbig.Int)
+	}
+	f.priorityFee = new(mathbig.Int).Sub(f.effectivePrice, f.baseFee)
+	if f.priorityFee.Sign() < 0 {
+		f.priorityFee = mathbig.NewInt(0)
+	}
+	f.cost = new(mathbig.Int).Mul(f.effectivePrice, new(mathbig.Int).SetUint64(rec.GasUsed))
+	// EIP-4844 blob gas is billed separately from execution gas; receipts
+	// from before Cancun leave it unset
+	if rec.BlobGasUsed > 0 && rec.BlobGasPrice != nil {
+		f.blobCost = new(mathbig.Int).Mul(rec.BlobGasPrice, new(mathbig.Int).SetUint64(rec.BlobGasUsed))
+	}
+	return f
+}
+
+// sender recovers tx's lowercased sender address, or "" if the signature
+// cannot be recovered.
+func (p *poller) sender(tx *typespkg.Transaction) string {
+	addr, err := typespkg.Sender(typespkg.LatestSignerForChainID(p.chainID), tx)
+	if err != nil {
+		return ""
+	}
+	return stringspkg.ToLower(addr.Hex())
+}
+
+// eventID identifies an event deterministically, so consumers can drop
+// duplicates: the same tx in the same block always gets the same ID, while
+// its re-inclusion in a different block after a reorg gets a new one.
+func eventID(tenant, txHash, blockHash string) string {
+	sum := sha256pkg.Sum256([]byte(tenant + "|" + txHash + "|" + blockHash))
+	return hexpkg.EncodeToString(sum[:])
+}
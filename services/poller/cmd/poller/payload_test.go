@@ -0,0 +1,248 @@
+package main
+
+import (
+	bytespkg "bytes"
+	contextpkg "context"
+	encodingjson "encoding/json"
+	mathbig "math/big"
+	testingpkg "testing"
+
+	typespkg "github.com/ethereum/go-ethereum/core/types"
+	"github.com/linkedin/goavro/v2"
+)
+
+// testBlock is block 1 holding tx, with baseFee nil for a pre-London block.
+func testBlock(tx *typespkg.Transaction, baseFee *mathbig.Int) *typespkg.Block {
+	return typespkg.NewBlockWithHeader(&typespkg.Header{
+		Number:     mathbig.NewInt(1),
+		Time:       1_438_269_988,
+		BaseFee:    baseFee,
+		Difficulty: new(mathbig.Int),
+	}).WithBody(typespkg.Body{Transactions: []*typespkg.Transaction{tx}})
+}
+
+func legacyTx(gasPrice *mathbig.Int) *typespkg.Transaction {
+	return typespkg.NewTx(&typespkg.LegacyTx{To: &testContract, Gas: 21000, GasPrice: gasPrice})
+}
+
+// TestFeesOfLegacyBlock runs feesOf on a pre-London block, which has no
+// base fee, with a receipt that has no effectiveGasPrice either, as nodes
+// return for such blocks.
+func TestFeesOfLegacyBlock(t *testingpkg.T) {
+	tx := legacyTx(mathbig.NewInt(20_000_000_000))
+	rec := &typespkg.Receipt{Status: typespkg.ReceiptStatusSuccessful, GasUsed: 21000}
+	f := feesOf(testBlock(tx, nil), tx, rec)
+
+	if f.model != "legacy" {
+		t.Errorf("model = %q, want legacy", f.model)
+	}
+	for _, c := range []struct {
+		name      string
+		got, want *mathbig.Int
+	}{
+		{"effectivePrice", f.effectivePrice, mathbig.NewInt(20_000_000_000)},
+		{"baseFee", f.baseFee, new(mathbig.Int)},
+		{"priorityFee", f.priorityFee, mathbig.NewInt(20_000_000_000)},
+		{"cost", f.cost, mathbig.NewInt(420_000_000_000_000)},
+	} {
+		if c.got == nil || c.got.Cmp(c.want) != 0 {
+			t.Errorf("%s = %v, want %v", c.name, c.got, c.want)
+		}
+	}
+	if f.blobCost != nil {
+		t.Errorf("blobCost = %v, want nil", f.blobCost)
+	}
+}
+
+// TestFeesOfPriceAboveBaseFee checks the EIP-1559 split, and that a price
+// below the base fee, which only a broken node reports, gives no negative
+// priority fee.
+func TestFeesOfPriceAboveBaseFee(t *testingpkg.T) {
+	tx := legacyTx(mathbig.NewInt(30))
+	rec := &typespkg.Receipt{GasUsed: 2, EffectiveGasPrice: mathbig.NewInt(30)}
+	f := feesOf(testBlock(tx, mathbig.NewInt(25)), tx, rec)
+	if f.model != "eip1559" || f.priorityFee.Int64() != 5 || f.cost.Int64() != 60 {
+		t.Errorf("fees = %+v, want eip1559 with priority fee 5 and cost 60", f)
+	}
+	f = feesOf(testBlock(tx, mathbig.NewInt(40)), tx, rec)
+	if f.priorityFee.Sign() != 0 {
+		t.Errorf("priorityFee = %v below the base fee, want 0", f.priorityFee)
+	}
+}
+
+// TestTxPayloadLegacyBlock builds the event for a tx in a block without a
+// base fee: the whole price is priority fee, and the base fee is reported
+// as zero rather than left out.
+func TestTxPayloadLegacyBlock(t *testingpkg.T) {
+	p := &poller{tenant: "tenant", chainID: mathbig.NewInt(1)}
+	tx := legacyTx(mathbig.NewInt(20_000_000_000))
+	rec := &typespkg.Receipt{Status: typespkg.ReceiptStatusSuccessful, GasUsed: 21000}
+	ev := p.txPayload(contextpkg.Background(), testBlock(tx, nil), tx, rec)
+
+	if ev.FeeModel != "legacy" {
+		t.Errorf("feeModel = %q, want legacy", ev.FeeModel)
+	}
+	if ev.BaseFeeGwei == nil || *ev.BaseFeeGwei != 0 || ev.BaseFeeWei != "0" {
+		t.Errorf("base fee = %v gwei, %q wei; want 0", ev.BaseFeeGwei, ev.BaseFeeWei)
+	}
+	if ev.PriorityFeeGwei == nil || *ev.PriorityFeeGwei != 20 || ev.PriorityFeeWei != "20000000000" {
+		t.Errorf("priority fee = %v gwei, %q wei; want the whole 20 gwei price", ev.PriorityFeeGwei, ev.PriorityFeeWei)
+	}
+	if ev.EffectiveGasPriceGwei == nil || *ev.EffectiveGasPriceGwei != 20 {
+		t.Errorf("effectiveGasPriceGwei = %v, want 20", ev.EffectiveGasPriceGwei)
+	}
+	if ev.CostWei != "420000000000000" {
+		t.Errorf("costWei = %q, want 420000000000000", ev.CostWei)
+	}
+	data, err := encodingjson.Marshal(ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytespkg.Contains(data, []byte(`"baseFeeGwei":0,`)) {
+		t.Errorf("JSON leaves out the zero base fee: %s", data)
+	}
+}
+
+// TestFeesExactPastFloat64 uses a cost of 100000100007000007 wei, which
+// float64 cannot hold: the ETH float no longer gives it back, while costWei
+// survives JSON exactly. PRECISE_FEES leaves the floats out.
+func TestFeesExactPastFloat64(t *testingpkg.T) {
+	const wantCost = "100000100007000007"
+	tx := legacyTx(mathbig.NewInt(100_000_000_007))
+	rec := &typespkg.Receipt{Status: typespkg.ReceiptStatusSuccessful, GasUsed: 1_000_001, EffectiveGasPrice: mathbig.NewInt(100_000_000_007)}
+	p := &poller{tenant: "tenant", chainID: mathbig.NewInt(1)}
+	ev := p.txPayload(contextpkg.Background(), testBlock(tx, mathbig.NewInt(100_000_000_000)), tx, rec)
+
+	data, err := encodingjson.Marshal(ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got GasEvent
+	if err := encodingjson.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.CostWei != wantCost || got.EffectiveGasPriceWei != "100000000007" || got.PriorityFeeWei != "7" {
+		t.Errorf("cost %s, price %s, priority fee %s wei; want %s, 100000000007, 7", got.CostWei, got.EffectiveGasPriceWei, got.PriorityFeeWei, wantCost)
+	}
+	fromFloat, _ := new(mathbig.Float).Mul(mathbig.NewFloat(*got.CostEth), mathbig.NewFloat(1e18)).Int(nil)
+	if fromFloat.String() == wantCost {
+		t.Fatalf("costEth %v round-trips exactly; pick a value float64 cannot hold", *got.CostEth)
+	}
+
+	p.preciseFees = true
+	ev = p.txPayload(contextpkg.Background(), testBlock(tx, mathbig.NewInt(100_000_000_000)), tx, rec)
+	if ev.CostEth != nil || ev.EffectiveGasPriceGwei != nil || ev.BaseFeeGwei != nil || ev.PriorityFeeGwei != nil {
+		t.Errorf("PRECISE_FEES kept float fields: %+v", ev)
+	}
+	if ev.CostWei != wantCost {
+		t.Errorf("PRECISE_FEES costWei = %s, want %s", ev.CostWei, wantCost)
+	}
+}
+
+// TestFeeWeiAdversarial checks the *Wei fields stay exact, through JSON and
+// Avro, for amounts at both ends of the range: a 1 wei tip on a 7 wei base
+// fee, the London minimum, and 10,000 gwei prices over a full block of gas.
+func TestFeeWeiAdversarial(t *testingpkg.T) {
+	codec, err := goavro.NewCodecForStandardJSON(gasEventSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	avro := &avroEncoder{codec: codec, schemaID: 1}
+	for _, tc := range []struct {
+		name                                   string
+		price, baseFee                         int64
+		gasUsed                                uint64
+		wantPrice, wantBase, wantTip, wantCost string
+	}{
+		{
+			name: "1 wei tip", price: 8, baseFee: 7, gasUsed: 21_000,
+			wantPrice: "8", wantBase: "7", wantTip: "1", wantCost: "168000",
+		},
+		{
+			name: "10,000 gwei, 30M gas", price: 10_000_000_000_000, baseFee: 9_999_999_999_999, gasUsed: 30_000_000,
+			wantPrice: "10000000000000", wantBase: "9999999999999", wantTip: "1", wantCost: "300000000000000000000",
+		},
+		{
+			name: "10,000 gwei and 1 wei", price: 10_000_000_000_001, baseFee: 10_000_000_000_000, gasUsed: 21_000,
+			wantPrice: "10000000000001", wantBase: "10000000000000", wantTip: "1", wantCost: "210000000000021000",
+		},
+		{
+			name: "no tip", price: 10_000_000_000_000, baseFee: 10_000_000_000_000, gasUsed: 21_000,
+			wantPrice: "10000000000000", wantBase: "10000000000000", wantTip: "0", wantCost: "210000000000000000",
+		},
+	} {
+		t.Run(tc.name, func(t *testingpkg.T) {
+			tx := legacyTx(mathbig.NewInt(tc.price))
+			rec := &typespkg.Receipt{Status: typespkg.ReceiptStatusSuccessful, GasUsed: tc.gasUsed, EffectiveGasPrice: mathbig.NewInt(tc.price)}
+			p := &poller{tenant: "tenant", chainID: mathbig.NewInt(1)}
+			ev := p.txPayload(contextpkg.Background(), testBlock(tx, mathbig.NewInt(tc.baseFee)), tx, rec)
+			data, err := encodingjson.Marshal(ev)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got GasEvent
+			if err := encodingjson.Unmarshal(data, &got); err != nil {
+				t.Fatal(err)
+			}
+			for _, c := range []struct{ field, got, want string }{
+				{"effectiveGasPriceWei", got.EffectiveGasPriceWei, tc.wantPrice},
+				{"baseFeeWei", got.BaseFeeWei, tc.wantBase},
+				{"priorityFeeWei", got.PriorityFeeWei, tc.wantTip},
+				{"baseFeePerGasWei", got.BaseFeePerGasWei, tc.wantBase},
+				{"priorityFeePerGasWei", got.PriorityFeePerGasWei, tc.wantTip},
+				{"costWei", got.CostWei, tc.wantCost},
+			} {
+				if c.got != c.want {
+					t.Errorf("%s = %q, want %q", c.field, c.got, c.want)
+				}
+			}
+			encoded, err := avro.encode(data)
+			if err != nil {
+				t.Fatalf("avro: %v", err)
+			}
+			native, _, err := codec.NativeFromBinary(encoded[5:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			record := native.(map[string]any)
+			for field, want := range map[string]string{
+				"effectiveGasPriceWei": tc.wantPrice,
+				"baseFeePerGasWei":     tc.wantBase,
+				"priorityFeePerGasWei": tc.wantTip,
+				"costWei":              tc.wantCost,
+			} {
+				if got := record[field].(map[string]any)["string"]; got != want {
+					t.Errorf("avro %s = %v, want %q", field, got, want)
+				}
+			}
+			// the floats are kept alongside, as close as float64 gets
+			if want := float64(tc.price) / 1e9; got.EffectiveGasPriceGwei == nil || *got.EffectiveGasPriceGwei != want {
+				t.Errorf("effectiveGasPriceGwei = %v, want %v", got.EffectiveGasPriceGwei, want)
+			}
+		})
+	}
+}
+
+// TestTxPayloadReceiptMissing builds the event for a tx whose receipt could
+// not be fetched: status and logsCount are still in the JSON, as unknown
+// and null, and no receipt field is made up.
+func TestTxPayloadReceiptMissing(t *testingpkg.T) {
+	p := &poller{tenant: "tenant", chainID: mathbig.NewInt(1)}
+	tx := legacyTx(mathbig.NewInt(20_000_000_000))
+	ev := p.txPayload(contextpkg.Background(), testBlock(tx, mathbig.NewInt(1)), tx, nil)
+	if !ev.ReceiptMissing || ev.Status != "unknown" || ev.LogsCount != nil {
+		t.Errorf("receiptMissing %v, status %q, logsCount %v; want true, unknown, nil", ev.ReceiptMissing, ev.Status, ev.LogsCount)
+	}
+	if ev.GasUsed != nil || ev.CostWei != "" || ev.EffectiveGasPriceWei != "" {
+		t.Errorf("event without a receipt has receipt fields: %+v", ev)
+	}
+	data, err := encodingjson.Marshal(ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"status":"unknown"`, `"logsCount":null`, `"receiptMissing":true`} {
+		if !bytespkg.Contains(data, []byte(want)) {
+			t.Errorf("JSON lacks %s: %s", want, data)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	contextpkg "context"
+	mathbig "math/big"
+
+	typespkg "github.com/ethereum/go-ethereum/core/types"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// pendingBlock is a block being fetched and prepared ahead of the loop.
+// Its fields are set once done is closed.
+type pendingBlock struct {
+	// ctx carries span, the block's process_block span, which ends once
+	// the block is emitted or dropped
+	ctx  contextpkg.Context
+	span trace.Span
+	done chan struct{}
+	blk  *typespkg.Block
+	prep *preparedBlock
+	err  error
+}
+
+// prepareAhead fetches block bn, unless blk already holds it, and prepares
+// it in the background. Up to BLOCK_WORKERS of these run while catching up;
+// the loop still checks parents and emits strictly in block order, so
+// events and checkpoints stay ordered and a pending block orphaned by a
+// reorg is simply dropped.
+func (p *poller) prepareAhead(ctx contextpkg.Context, bn uint64, blk *typespkg.Block) *pendingBlock {
+	ctx, span := tracer.Start(ctx, "process_block", trace.WithAttributes(attribute.Int64("block.number", int64(bn))))
+	pb := &pendingBlock{ctx: ctx, span: span, done: make(chan struct{}), blk: blk}
+	// the views are taken here, in block order, rather than when the
+	// preparation gets to it, so a watch change never applies to a block
+	// but not to a later one prepared before it
+	opts := blockOpts{source: "live", targets: p.targets.View(), senders: p.senders.View()}
+	blocksInPreparation.Inc()
+	go func() {
+		defer close(pb.done)
+		defer blocksInPreparation.Dec()
+		if pb.blk == nil {
+			pb.blk, pb.err = withRetry(ctx, p.rpcRetry, func() (*typespkg.Block, error) {
+				return p.client.BlockByNumber(ctx, mathbig.NewInt(int64(bn)))
+			})
+			if pb.err != nil {
+				return
+			}
+		}
+		span.SetAttributes(attribute.String("block.hash", pb.blk.Hash().Hex()))
+		pb.prep = p.prepareBlock(ctx, pb.blk, opts)
+	}()
+	return pb
+}
+
+// dropPending ends the spans of blocks prepared for nothing, because a
+// reorg or an error ended the tick before they were emitted.
+func dropPending(ahead map[uint64]*pendingBlock) {
+	for _, pb := range ahead {
+		pb.span.SetAttributes(attribute.Bool("block.dropped", true))
+		pb.span.End()
+	}
+}
+
+// prepareWindow is how many blocks may be prepared at once: BLOCK_WORKERS
+// while catching up, otherwise one.
+func (p *poller) prepareWindow() uint64 {
+	if p.catchupUntil != 0 && p.blockWorkers > 1 {
+		return uint64(p.blockWorkers)
+	}
+	return 1
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	contextpkg "context"
+	fmtpkg "fmt"
+	testingpkg "testing"
+	timepkg "time"
+)
+
+// BenchmarkBackfill catches up 50 blocks of 5 txs each, every RPC call
+// taking 200µs, with BLOCK_WORKERS at 1 and above. It reports blocks/s.
+func BenchmarkBackfill(b *testingpkg.B) {
+	const blocks = 50
+	chain := newFakeChain(blocks, testContract, 5)
+	chain.latency = 200 * timepkg.Microsecond
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmtpkg.Sprintf("workers=%d", workers), func(b *testingpkg.B) {
+			var elapsed timepkg.Duration
+			for i := 0; i < b.N; i++ {
+				p, _, ckpt := newTestPoller(chain, testContract, 0)
+				p.blockWorkers, p.catchupUntil = workers, blocks
+				ctx, cancel := contextpkg.WithCancel(contextpkg.Background())
+				done := make(chan struct{})
+				started := timepkg.Now()
+				go func() {
+					defer close(done)
+					p.run(ctx)
+				}()
+				if !waitFor(func() bool { return ckpt.last.Load() == blocks }) {
+					b.Fatalf("checkpoint at %d, want %d", ckpt.last.Load(), blocks)
+				}
+				elapsed += timepkg.Since(started)
+				cancel()
+				<-done
+			}
+			b.ReportMetric(float64(blocks*b.N)/elapsed.Seconds(), "blocks/s")
+		})
+	}
+}
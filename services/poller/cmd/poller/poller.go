@@ -0,0 +1,789 @@
+package main
+
+import (
+	contextpkg "context"
+	encodingjson "encoding/json"
+	fmtpkg "fmt"
+	mathpkg "math"
+	mathbig "math/big"
+	stringspkg "strings"
+	atomicpkg "sync/atomic"
+	timepkg "time"
+
+	"github.com/IBM/sarama"
+	"github.com/ethereum/go-ethereum/common"
+	typespkg "github.com/ethereum/go-ethereum/core/types"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// poller holds everything needed to turn a block into gas events.
+type poller struct {
+	client  chainClient
+	pub     *publisher
+	topic   string
+	tenant  string
+	chainID *mathbig.Int
+	// network is the networkName of events, empty when unknown
+	network string
+	// checksumAddresses (ADDRESS_CHECKSUM) sends event addresses in
+	// EIP-55 form instead of lowercase
+	checksumAddresses bool
+	targets           *WatchSet
+	senders           *WatchSet
+	recent            *blockRing
+	ckpt              Checkpointer
+	health            *health
+	heads             *headFeed
+	methods           *methodResolver
+	dedup             *dedupCache
+
+	// correctionsTopic receives invalidations for txs orphaned by reorgs
+	correctionsTopic string
+	// partitionKey is the message key strategy, one of the partitionBy
+	// constants
+	partitionKey string
+
+	// includeBlobCost adds blob fees to costEth; they are always reported
+	// separately as blobCostEth
+	includeBlobCost bool
+	// preciseFees leaves out the float gwei and ETH fee fields, keeping
+	// only the exact *Wei strings
+	preciseFees bool
+	// minCost (MIN_COST_ETH, in wei) suppresses events of txs costing less,
+	// for watches without a minCostEth of their own; nil for none
+	minCost *mathbig.Int
+	// alerts, when set, checks each delivered live event for a gas price
+	// spike
+	alerts *spikeAlerter
+	// matches counts delivered events per contract for the admin server;
+	// nil without it
+	matches *matchStats
+	// summaryTopic, when set, receives a blockSummary per block and
+	// contract with matched txs
+	summaryTopic string
+	// avro, when set, encodes gas events as Avro instead of JSON, and
+	// protobuf as gaseventpb.GasEvent (OUTPUT_FORMAT)
+	avro     *avroEncoder
+	protobuf *protobufEncoder
+	// markerTopic, set with KAFKA_TRANSACTIONS, receives a blockMarker as
+	// the last message of each block's transaction
+	markerTopic string
+	// prices, when set, adds costUsd and ethUsdPrice to events while its
+	// price is no older than priceMaxAge
+	prices      PriceProvider
+	priceMaxAge timepkg.Duration
+
+	tips *tipSource
+	// cadence paces polling for new blocks once caught up; while behind
+	// the loop does not wait at all
+	cadence blockCadence
+	// last is the highest block the loop has finished with
+	last uint64
+	// blocks below reorgUntil are replays of blocks a reorg replaced;
+	// replaced holds the orphaned hashes we had processed for them
+	reorgUntil uint64
+	replaced   map[uint64]common.Hash
+	// blocks up to catchupUntil, the tip being chased at startup or after
+	// falling behind, are processed at most one per catchupDelay; 0 once
+	// caught up
+	catchupUntil uint64
+	catchupDelay timepkg.Duration
+	// a tick processes at most maxBlocksPerTick blocks, or catchupBatch
+	// while catching up; 0 for no cap
+	maxBlocksPerTick uint64
+	catchupBatch     uint64
+	// resuming is set while catching up to the tip seen at startup, which
+	// is expected lag and so is not alerted on
+	resuming bool
+	// progress is logged every catchupLogEvery blocks, with throughput
+	// measured since catchupMark was passed at catchupMarkAt
+	catchupLogEvery uint64
+	catchupMark     uint64
+	catchupMarkAt   timepkg.Time
+
+	// lag above lagAlert is logged and flagged in metrics
+	lagAlert     uint64
+	behind       bool
+	behindLogged timepkg.Time
+
+	rpcRetry       backoff
+	receiptRetry   backoff
+	receiptsFailed atomicpkg.Uint64
+	batch          batchReceipts
+	tracer         blockTracer
+	// rpcBatch is how many blocks or receipts go in one JSON-RPC batch
+	// while catching up, and for a block's receipts when
+	// eth_getBlockReceipts is unavailable; 0 or 1 disables batching
+	rpcBatch int
+	// blockWorkers is how many blocks are fetched and prepared at once
+	// while catching up
+	blockWorkers int
+	// errBackoff paces loops that retry forever after an error
+	errBackoff backoff
+
+	// a block whose fetch fails is retried on later ticks until
+	// blockMaxAttempts is reached, then skipped
+	blockMaxAttempts int
+	failingBlock     uint64
+	failingAttempts  int
+	blocksSkipped    atomicpkg.Uint64
+}
+
+// blockLag is how many confirmed blocks are still waiting to be processed.
+func blockLag(tip, last uint64) uint64 {
+	if tip <= last {
+		return 0
+	}
+	return tip - last
+}
+
+// setLag publishes the lag of last behind tip and warns, at most once a
+// minute, while it exceeds lagAlert during live tailing.
+func (p *poller) setLag(tip, last uint64) {
+	lag := blockLag(tip, last)
+	blockLagGauge.Set(float64(lag))
+	p.health.lag.Store(lag)
+	p.health.tip.Store(tip)
+	p.health.last.Store(last)
+	if p.lagAlert == 0 || p.resuming {
+		return
+	}
+	if lag <= p.lagAlert {
+		if p.behind {
+			logFor("poller").Info("lag back under threshold", "lag", lag)
+			p.behind = false
+			fallingBehind.Set(0)
+		}
+		return
+	}
+	if !p.behind || timepkg.Since(p.behindLogged) >= timepkg.Minute {
+		logFor("poller").Warn("falling behind the confirmed tip", "lag", lag, "threshold", p.lagAlert)
+		p.behindLogged = timepkg.Now()
+	}
+	p.behind = true
+	fallingBehind.Set(1)
+}
+
+// run tails the chain until ctx is cancelled. Cancellation is only checked
+// between blocks, so the block being processed when a shutdown is requested
+// is always completed before run returns.
+func (p *poller) run(ctx contextpkg.Context) {
+	// in-flight block work must not be cut short by the shutdown signal
+	work := contextpkg.WithoutCancel(ctx)
+	p.health.running.Store(true)
+	// pending is a head delivered by the newHeads subscription, used
+	// instead of asking the node for the tip
+	var pending *typespkg.Header
+	errWait := backoffTimer{b: p.errBackoff}
+	for ctx.Err() == nil {
+		head := pending
+		pending = nil
+		tip, err := withRetry(ctx, p.rpcRetry, func() (uint64, error) {
+			return p.tips.tip(ctx, head)
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			logFor("poller").Warn("get tip", "err", err)
+			errWait.wait(ctx)
+			continue
+		}
+		errWait.reset()
+		p.health.rpcSucceeded()
+		// lag is measured against the confirmed tip, not the raw head, so
+		// the confirmation offset does not read as the poller falling behind
+		p.setLag(tip, p.last)
+		logFor("poller").Debug("confirmed tip", "tip", tip, "source", p.tips.String(), "block", p.last, "lag", blockLag(tip, p.last))
+		if tip < p.last && p.checkView(work, tip) {
+			continue
+		}
+		if tip <= p.last {
+			pending = p.waitForHead(ctx)
+			continue
+		}
+		end := tip
+		batch := p.maxBlocksPerTick
+		if p.catchupUntil != 0 && p.catchupBatch > 0 {
+			batch = p.catchupBatch
+		}
+		if batch > 0 && tip-p.last > batch {
+			// take a bounded bite and come straight back for the next one,
+			// pacing blocks until the tip is reached
+			end = p.last + batch
+			if p.catchupUntil < tip {
+				p.catchupUntil = tip
+			}
+		}
+		var prefetched map[uint64]*typespkg.Block
+		ahead := make(map[uint64]*pendingBlock)
+		for bn := p.last + 1; bn <= end; bn++ {
+			if ctx.Err() != nil {
+				end = bn - 1
+				break
+			}
+			for n := bn; n <= end && n < bn+p.prepareWindow(); n++ {
+				if ahead[n] != nil {
+					continue
+				}
+				blk := prefetched[n]
+				if blk == nil && p.catchupUntil != 0 && p.rpcBatch > 1 && n < end {
+					prefetched = p.prefetchBlocks(work, n, min(end, n+uint64(p.rpcBatch)-1))
+					blk = prefetched[n]
+				}
+				ahead[n] = p.prepareAhead(work, n, blk)
+			}
+			pb := ahead[bn]
+			delete(ahead, bn)
+			<-pb.done
+			blk, err := pb.blk, pb.err
+			if err != nil {
+				endSpan(pb.span, err)
+				if p.giveUpOnBlock(bn, err) {
+					continue
+				}
+				// retry from this block on the next tick
+				end = bn - 1
+				break
+			}
+			if parent, ok := p.recent.Get(bn - 1); ok && blk.ParentHash() != parent {
+				pb.span.SetAttributes(attribute.Bool("block.reorg", true))
+				pb.span.End()
+				fork, err := p.findForkPoint(work, bn-1)
+				if err != nil {
+					logFor("reorg").Error("find fork point", "block", bn, "err", err)
+					end = bn - 1
+					break
+				}
+				logFor("reorg").Warn("reorg detected, replaying", "block", bn, "replayFrom", fork+1)
+				if err := p.rewind(work, fork, bn); err != nil {
+					logFor("reorg").Error("rewind", "block", bn, "err", err)
+					end = bn - 1
+					break
+				}
+				// prefetched and prepared blocks may be from the abandoned
+				// branch
+				prefetched = nil
+				dropPending(ahead)
+				ahead = make(map[uint64]*pendingBlock)
+				bn = fork
+				continue
+			}
+			started := timepkg.Now()
+			emitted, err := p.emitBlock(pb.ctx, pb.prep, blockOpts{source: "live", reorged: bn < p.reorgUntil, replaces: p.replaced[bn]})
+			endSpan(pb.span, err)
+			if err != nil {
+				// leave the block for the next tick rather than skip its events
+				logFor("poller").Error("process block", "block", bn, "err", err)
+				end = bn - 1
+				break
+			}
+			p.announceRemovals(work, pb.prep)
+			blockDuration.Observe(timepkg.Since(started).Seconds())
+			blocksProcessed.Inc()
+			p.setLag(tip, bn)
+			p.recent.Put(bn, blk.Hash(), emitted)
+			p.cadence.observe(bn, blk.Time())
+			delete(p.replaced, bn)
+			if err := p.ckpt.Save(checkpointRecord{Block: bn, Hash: blk.Hash().Hex()}); err != nil {
+				logFor("checkpoint").Error("save checkpoint", "block", bn, "err", err)
+			}
+			p.paceCatchup(ctx, bn)
+		}
+		dropPending(ahead)
+		p.last = end
+	}
+}
+
+// rewind undoes blocks (fork, upto) after a reorg: it publishes corrections
+// for what they emitted and forgets them, so the loop can replay them from
+// fork+1 flagged as replacements.
+func (p *poller) rewind(ctx contextpkg.Context, fork, upto uint64) error {
+	orphaned, err := p.emitOrphaned(ctx, fork, upto)
+	if err != nil {
+		return err
+	}
+	if p.replaced == nil {
+		p.replaced = make(map[uint64]common.Hash)
+	}
+	for n, h := range orphaned {
+		p.replaced[n] = h
+	}
+	p.reorgUntil = max(p.reorgUntil, upto)
+	p.recent.Truncate(fork + 1)
+	return nil
+}
+
+// checkView compares a node whose tip is below our last block, such as a
+// lagging endpoint after a failover, with what we processed: if its block at
+// tip has a different hash it sees another chain, and everything from the
+// fork point on is rewound. It reports whether it rewound.
+func (p *poller) checkView(ctx contextpkg.Context, tip uint64) bool {
+	known, ok := p.recent.Get(tip)
+	if !ok {
+		return false
+	}
+	hdr, err := p.client.HeaderByNumber(ctx, new(mathbig.Int).SetUint64(tip))
+	if err != nil || hdr.Hash() == known {
+		return false
+	}
+	fork, err := p.findForkPoint(ctx, tip)
+	if err != nil {
+		logFor("reorg").Error("processed block changed, find fork point", "block", tip, "err", err)
+		return false
+	}
+	logFor("reorg").Warn("processed block changed, rewinding", "block", tip, "rewindTo", fork)
+	if err := p.rewind(ctx, fork, p.last+1); err != nil {
+		logFor("reorg").Error("rewind", "block", fork, "err", err)
+		return false
+	}
+	p.last = fork
+	return true
+}
+
+// paceCatchup throttles a catch-up, at startup or after falling more than a
+// tick behind, so replaying a long range does not hammer the RPC, and logs
+// once the loop reaches the tip it was chasing.
+func (p *poller) paceCatchup(ctx contextpkg.Context, bn uint64) {
+	if p.catchupUntil == 0 {
+		return
+	}
+	if bn >= p.catchupUntil {
+		logFor("poller").Info("catch-up complete, tailing live", "block", bn)
+		p.catchupUntil, p.resuming, p.catchupMarkAt = 0, false, timepkg.Time{}
+		catchupRemaining.Set(0)
+		catchingUp.Set(0)
+		return
+	}
+	remaining := p.catchupUntil - bn
+	catchupRemaining.Set(float64(remaining))
+	switch {
+	case p.catchupMarkAt.IsZero():
+		p.catchupMark, p.catchupMarkAt = bn, timepkg.Now()
+	case p.catchupLogEvery > 0 && bn-p.catchupMark >= p.catchupLogEvery:
+		rate := float64(bn-p.catchupMark) / timepkg.Since(p.catchupMarkAt).Seconds()
+		eta := timepkg.Duration(float64(remaining) / rate * float64(timepkg.Second))
+		logFor("poller").Info("catch-up progress", "block", bn, "target", p.catchupUntil, "remaining", remaining,
+			"blocksPerSec", mathpkg.Round(rate*10)/10, "eta", eta.Round(timepkg.Second).String())
+		p.catchupMark, p.catchupMarkAt = bn, timepkg.Now()
+	}
+	sleepCtx(ctx, p.catchupDelay)
+}
+
+// giveUpOnBlock records a failed fetch of block bn and reports whether the
+// block has now failed often enough to be skipped.
+func (p *poller) giveUpOnBlock(bn uint64, err error) bool {
+	if p.failingBlock != bn {
+		p.failingBlock, p.failingAttempts = bn, 0
+	}
+	p.failingAttempts++
+	if p.failingAttempts < p.blockMaxAttempts {
+		logFor("poller").Warn("process block failed", "block", bn, "attempt", p.failingAttempts, "maxAttempts", p.blockMaxAttempts, "err", err)
+		return false
+	}
+	skipped := p.blocksSkipped.Add(1)
+	blocksSkippedTotal.Inc()
+	logFor("poller").Error("giving up on block, its events are lost", "block", bn, "attempts", p.failingAttempts, "skipped", skipped, "err", err)
+	p.failingBlock, p.failingAttempts = 0, 0
+	return true
+}
+
+// blockOpts says how a block is being processed.
+type blockOpts struct {
+	// source is emitted as the payload's source field: live or backfill
+	source string
+	// reorged marks events re-emitted after a chain reorg replaced a block
+	// we had already processed, and replaces is that orphaned block's hash
+	// when it is still known
+	reorged  bool
+	replaces common.Hash
+	// contract, when set, restricts matching to that one lowercased address
+	// instead of the watch set
+	contract string
+	// targets and senders are the watch views to match the block against,
+	// or nil for the sets as they are when it is prepared
+	targets, senders *watchView
+}
+
+// processBlock emits a gas event for every transaction in blk that targets a
+// watched contract, and with tracing for every watched contract a
+// transaction reaches through internal calls. It returns the txs it
+// emitted; an error means at least one event could not be delivered and the
+// block must be processed again.
+func (p *poller) processBlock(ctx contextpkg.Context, blk *typespkg.Block, opts blockOpts) ([]emittedTx, error) {
+	ctx, span := tracer.Start(ctx, "process_block", trace.WithAttributes(
+		attribute.Int64("block.number", int64(blk.NumberU64())),
+		attribute.String("block.hash", blk.Hash().Hex()),
+		attribute.String("source", opts.source),
+	))
+	emitted, err := p.emitBlock(ctx, p.prepareBlock(ctx, blk, opts), opts)
+	endSpan(span, err)
+	return emitted, err
+}
+
+// preparedBlock is a block's matched txs with their receipts fetched: all
+// the RPC work of processing it, so blocks can be prepared concurrently
+// and then emitted in order.
+type preparedBlock struct {
+	blk     *typespkg.Block
+	matches []txMatch
+	// targets is the contract watch view the block was matched against
+	targets *watchView
+	// receipts holds a receipt, or nil if it could not be fetched, for every
+	// match that will be emitted or summarised
+	receipts map[common.Hash]*typespkg.Receipt
+}
+
+// prepareBlock matches blk's txs against the watches and fetches what
+// emitting them needs. It has no effect beyond the RPC calls, so it may run
+// ahead of the loop and be thrown away.
+func (p *poller) prepareBlock(ctx contextpkg.Context, blk *typespkg.Block, opts blockOpts) *preparedBlock {
+	targets, senders := opts.targets, opts.senders
+	if targets == nil {
+		targets, senders = p.targets.View(), p.senders.View()
+	}
+	prep := &preparedBlock{blk: blk, targets: targets}
+	addMatch := func(m txMatch) {
+		matchedTxs.Inc()
+		if p.dedup.Seen(m.dedupKey) {
+			duplicatesSuppressed.Inc()
+			logFor("poller").Debug("tx already emitted, skipping", "block", blk.NumberU64(), "txHash", m.tx.Hash().Hex())
+			m.duplicate = true
+		}
+		prep.matches = append(prep.matches, m)
+	}
+	watched := func(to string, data []byte) bool {
+		if opts.contract == "" {
+			return targets.Matches(to, data)
+		}
+		// a backfill applies the contract's method filter as live tailing
+		// does, and matches every method of a contract no longer watched;
+		// emitBlock applies its minimum cost on both paths
+		return to == opts.contract && (!targets.Contains(to) || targets.Matches(to, data))
+	}
+	traces := p.tracer.forBlock(ctx, p.client, blk)
+	for i, tx := range blk.Transactions() {
+		var to, reason string
+		if tx.To() != nil { // nil for contract creation
+			to = stringspkg.ToLower(tx.To().Hex())
+			switch {
+			case watched(to, tx.Data()):
+				reason = watchContract
+			// recovering the sender costs a signature check per tx, so
+			// only pay for it when something is watched by sender
+			case opts.contract == "" && senders.Len() > 0 && senders.Matches(p.sender(tx), tx.Data()):
+				reason = watchFrom
+			}
+		}
+		if reason != "" {
+			addMatch(txMatch{tx: tx, to: to, reason: reason, dedupKey: tx.Hash().Hex() + "|" + blk.Hash().Hex()})
+		}
+		if traces == nil {
+			continue
+		}
+		for _, c := range internalCalls(traces[i], watched) {
+			if reason == watchContract && c.contract == to {
+				continue // already matched at the top level
+			}
+			addMatch(txMatch{tx: tx, to: c.contract, reason: matchInternal, dedupKey: tx.Hash().Hex() + "|" + blk.Hash().Hex() + "|" + c.contract, call: c})
+		}
+	}
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.Int("block.matches", len(prep.matches)))
+	// duplicates need their receipt only for the block summary
+	var need []txMatch
+	for _, m := range prep.matches {
+		if !m.duplicate || (p.summaryTopic != "" && m.call == nil) {
+			need = append(need, m)
+		}
+	}
+	ctx, span = tracer.Start(ctx, "fetch_receipts", trace.WithAttributes(attribute.Int("receipts", len(need))))
+	defer span.End()
+	receipts, receiptErrs := p.blockReceipts(ctx, blk, need)
+	prep.receipts = make(map[common.Hash]*typespkg.Receipt, len(need))
+	for _, m := range need {
+		hash := m.tx.Hash()
+		if _, done := prep.receipts[hash]; done {
+			continue
+		}
+		rec, err := receipts[hash], receiptErrs[hash]
+		if rec == nil && err == nil {
+			rec, err = p.receipt(ctx, hash)
+		}
+		if err != nil {
+			// emit what the block tells us rather than lose the tx entirely
+			failed := p.receiptsFailed.Add(1)
+			receiptsFailedTotal.Inc()
+			logFor("poller").Error("receipt unavailable, emitting without it", "block", blk.NumberU64(), "txHash", hash.Hex(), "failed", failed, "err", err)
+			rec = nil
+		}
+		prep.receipts[hash] = rec
+	}
+	return prep
+}
+
+// emitBlock sends the events of a prepared block and waits for them to be
+// acknowledged, then sends its summaries. It returns the hashes of the txs
+// emitted, as processBlock.
+func (p *poller) emitBlock(ctx contextpkg.Context, prep *preparedBlock, opts blockOpts) ([]emittedTx, error) {
+	blk := prep.blk
+	// the span's context goes into every message's headers, linking
+	// consumers to it
+	ctx, span := tracer.Start(ctx, "kafka_send")
+	defer span.End()
+	var (
+		emitted []emittedTx
+		events  []blockEvent
+	)
+	var summaries blockSummaries
+	if p.summaryTopic != "" {
+		summaries = blockSummaries{}
+	}
+	noted := make(map[string]bool)
+	noteEmitted := func(hash, contract string) {
+		// a tx matched more than once needs only one reorg correction
+		if !noted[hash] {
+			noted[hash] = true
+			emitted = append(emitted, emittedTx{hash: hash, contract: contract})
+		}
+	}
+	for _, m := range prep.matches {
+		tx := m.tx
+		rec := prep.receipts[tx.Hash()]
+		if m.call == nil {
+			// internal calls are left out: the tx's gas belongs to its
+			// top-level contract
+			summaries.add(m.to, tx, rec)
+		}
+		if m.duplicate {
+			// not sent again, but still reported as emitted so a reorg
+			// correction covers it
+			noteEmitted(tx.Hash().Hex(), m.to)
+			continue
+		}
+		if rec != nil && rec.Status != typespkg.ReceiptStatusSuccessful && m.call == nil {
+			revertedTxs.WithLabelValues(m.to).Inc()
+		}
+		payload := p.txPayload(ctx, blk, tx, rec)
+		if m.call != nil {
+			p.internalCallPayload(ctx, payload, blk, tx, m.call)
+		}
+		if p.belowMinCost(m, payload) {
+			belowMinCost.Inc()
+			continue
+		}
+		payload.Source = opts.source
+		payload.MatchReason = m.reason
+		payload.Reorged = opts.reorged
+		if opts.replaces != (common.Hash{}) {
+			payload.Replaces = opts.replaces.Hex()
+		}
+		out := payload
+		if p.checksumAddresses {
+			out = payload.checksummed()
+		}
+		value, _ := encodingjson.Marshal(out)
+		var err error
+		switch {
+		case p.avro != nil:
+			value, err = p.avro.encode(value)
+		case p.protobuf != nil:
+			value, err = p.protobuf.encode(value)
+		}
+		if err != nil {
+			return nil, fmtpkg.Errorf("encode tx %s: %w", tx.Hash().Hex(), err)
+		}
+		e := blockEvent{
+			msg: sarama.ProducerMessage{
+				Topic:   p.topic,
+				Key:     sarama.StringEncoder(p.messageKey(m.to, tx.Hash().Hex())),
+				Value:   sarama.ByteEncoder(value),
+				Headers: p.headers(m.to, opts.source),
+			},
+			hash:     tx.Hash().Hex(),
+			to:       m.to,
+			dedupKey: m.dedupKey,
+		}
+		if m.reason == watchContract {
+			e.contract = m.to
+		}
+		// baselines follow the contract's own txs as they happen: not
+		// backfilled history, replays or other contracts' internal calls
+		if p.alerts != nil && m.reason == watchContract && opts.source == "live" && !opts.reorged {
+			e.spikeCheck = payload
+		}
+		events = append(events, e)
+	}
+	span.SetAttributes(attribute.Int("messages", len(events)))
+	var delivered []blockEvent
+	err := p.pub.inTxn(ctx, func(ctx contextpkg.Context) error {
+		delivered = delivered[:0]
+		inflight := make([]*delivery, 0, len(events))
+		for _, e := range events {
+			d := p.pub.enqueue(ctx, e.message())
+			if err := d.failed(); err != nil {
+				return fmtpkg.Errorf("deliver tx %s: %w", e.hash, err)
+			}
+			inflight = append(inflight, d)
+		}
+		// the block only counts as processed once every event is
+		// acknowledged
+		var firstErr error
+		for i, d := range inflight {
+			if err := d.wait(); err != nil {
+				if firstErr == nil {
+					firstErr = fmtpkg.Errorf("deliver tx %s: %w", events[i].hash, err)
+				}
+				continue
+			}
+			delivered = append(delivered, events[i])
+		}
+		if firstErr != nil {
+			return firstErr
+		}
+		for _, msg := range p.summaryMessages(blk, summaries, opts) {
+			if err := p.pub.send(ctx, msg); err != nil {
+				return fmtpkg.Errorf("deliver block summary: %w", err)
+			}
+		}
+		if p.markerTopic != "" {
+			if err := p.pub.send(ctx, p.blockMarker(blk, len(events), opts)); err != nil {
+				return fmtpkg.Errorf("deliver block marker: %w", err)
+			}
+		}
+		return nil
+	})
+	// without transactions an acknowledged event is out even when the block
+	// failed; with them, only once the transaction committed
+	if err == nil || p.pub.txn == nil {
+		for _, e := range delivered {
+			p.dedup.Add(e.dedupKey)
+			noteEmitted(e.hash, e.to)
+			if e.contract != "" {
+				p.matches.record(e.contract, blk.NumberU64())
+			}
+			if e.spikeCheck != nil {
+				p.alerts.observe(ctx, e.spikeCheck)
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return emitted, nil
+}
+
+// txMatch is a transaction processBlock is going to emit, or with
+// duplicate set one already emitted that is not sent again.
+type txMatch struct {
+	tx        *typespkg.Transaction
+	to        string
+	reason    string
+	dedupKey  string
+	duplicate bool
+	// call is set for a match on an internal call rather than the tx itself
+	call *internalCall
+}
+
+// blockReceipts fetches the receipts of the matched txs up front: with one
+// eth_getBlockReceipts call when the node has it, otherwise in JSON-RPC
+// batches when there is more than one. errs holds the receipts a batch gave
+// up on; anything in neither map is fetched on its own.
+func (p *poller) blockReceipts(ctx contextpkg.Context, blk *typespkg.Block, matches []txMatch) (map[common.Hash]*typespkg.Receipt, map[common.Hash]error) {
+	if len(matches) == 0 {
+		return nil, nil
+	}
+	if receipts := p.batch.forBlock(ctx, p.client, blk); receipts != nil || len(matches) < 2 || p.rpcBatch < 2 {
+		return receipts, nil
+	}
+	hashes := make([]common.Hash, 0, len(matches))
+	seen := make(map[common.Hash]bool, len(matches))
+	for _, m := range matches {
+		if !seen[m.tx.Hash()] {
+			seen[m.tx.Hash()] = true
+			hashes = append(hashes, m.tx.Hash())
+		}
+	}
+	return batchRetry(ctx, p.receiptRetry, p.rpcBatch, hashes, func(hs []common.Hash) ([]*typespkg.Receipt, []error) {
+		return p.client.TransactionReceipts(ctx, hs)
+	})
+}
+
+// prefetchBlocks fetches blocks from..to in one JSON-RPC batch while
+// catching up, retrying only the ones that failed. Blocks it could not get
+// are left out; the loop fetches those one at a time, which also decides
+// whether to give up on them.
+func (p *poller) prefetchBlocks(ctx contextpkg.Context, from, to uint64) map[uint64]*typespkg.Block {
+	numbers := make([]uint64, 0, to-from+1)
+	for n := from; n <= to; n++ {
+		numbers = append(numbers, n)
+	}
+	blocks, _ := batchRetry(ctx, p.rpcRetry, p.rpcBatch, numbers, func(ns []uint64) ([]*typespkg.Block, []error) {
+		return p.client.BlocksByNumber(ctx, ns)
+	})
+	return blocks
+}
+
+// blockEvent is a gas event of the block being emitted.
+type blockEvent struct {
+	msg  sarama.ProducerMessage
+	hash string
+	// to is the contract the event is keyed by
+	to       string
+	dedupKey string
+	// contract is the watched contract the event matched, if any
+	contract string
+	// spikeCheck is the event to check for a gas price spike once
+	// delivered, or nil
+	spikeCheck *GasEvent
+}
+
+// message returns a fresh copy of the event's message, since the producer
+// keeps per-send state in it and a transaction may be retried.
+func (e blockEvent) message() *sarama.ProducerMessage {
+	msg := e.msg
+	msg.Headers = append([]sarama.RecordHeader(nil), e.msg.Headers...)
+	return &msg
+}
+
+// Partition key strategies. Kafka only orders messages within a partition,
+// so the key decides what stays in order: all events for a contract, for a
+// tenant, or nothing beyond a single tx.
+const (
+	partitionByContract = "contract"
+	partitionByTx       = "tx"
+	partitionByTenant   = "tenant"
+)
+
+// parsePartitionKey validates a KAFKA_KEY_STRATEGY value; txHash is
+// accepted for tx.
+func parsePartitionKey(s string) (string, bool) {
+	switch s {
+	case partitionByContract, partitionByTx, partitionByTenant:
+		return s, true
+	case "txHash":
+		return partitionByTx, true
+	}
+	return "", false
+}
+
+// messageKey is the Kafka key for an event about contract and txHash. The
+// producers use the hash partitioner, so equal keys land on one partition.
+func (p *poller) messageKey(contract, txHash string) string {
+	switch p.partitionKey {
+	case partitionByTx:
+		return p.tenant + "|" + txHash
+	case partitionByTenant:
+		return p.tenant
+	}
+	return p.tenant + ":" + contract
+}
+
+// receipt fetches a transaction receipt, retrying since public RPCs often
+// do not have the receipt indexed right after the block is mined.
+func (p *poller) receipt(ctx contextpkg.Context, hash common.Hash) (*typespkg.Receipt, error) {
+	return withRetry(ctx, p.receiptRetry, func() (*typespkg.Receipt, error) {
+		return p.client.TransactionReceipt(ctx, hash)
+	})
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	contextpkg "context"
+	mathbig "math/big"
+	testingpkg "testing"
+	timepkg "time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+var testContract = common.HexToAddress("0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed")
+
+func TestConfirmedTip(t *testingpkg.T) {
+	for _, tc := range []struct {
+		head, confirmations, want uint64
+	}{
+		{head: 100, confirmations: 3, want: 97},
+		{head: 100, confirmations: 0, want: 100},
+		{head: 3, confirmations: 3, want: 0},
+		// a chain younger than the confirmations has nothing confirmed
+		{head: 2, confirmations: 3, want: 0},
+	} {
+		if got := confirmedTip(tc.head, tc.confirmations); got != tc.want {
+			t.Errorf("confirmedTip(%d, %d) = %d, want %d", tc.head, tc.confirmations, got, tc.want)
+		}
+	}
+}
+
+// TestRunNeverEmitsUnconfirmedBlocks feeds the loop a chain whose head
+// moves, and checks it only ever emits and checkpoints blocks at least
+// CONFIRMATIONS below the head it has seen.
+func TestRunNeverEmitsUnconfirmedBlocks(t *testingpkg.T) {
+	const confirmations = 3
+	chain := newFakeChain(20, testContract, 1)
+	chain.setHead(10)
+	p, sink, ckpt := newTestPoller(chain, testContract, confirmations)
+
+	ctx, cancel := contextpkg.WithCancel(contextpkg.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.run(ctx)
+	}()
+
+	for _, head := range []uint64{10, 14, 20} {
+		chain.setHead(head)
+		want := head - confirmations
+		if !waitFor(func() bool { return ckpt.last.Load() == want }) {
+			t.Fatalf("head %d: checkpoint at %d, want %d", head, ckpt.last.Load(), want)
+		}
+		for _, bn := range sink.blocks() {
+			if bn > want {
+				t.Fatalf("head %d: emitted block %d, above the confirmed tip %d", head, bn, want)
+			}
+		}
+	}
+	cancel()
+	<-done
+
+	blocks := sink.blocks()
+	if len(blocks) != 20-confirmations {
+		t.Fatalf("emitted %d events, want one for each of blocks 1-%d", len(blocks), 20-confirmations)
+	}
+	for i, bn := range blocks {
+		if bn != uint64(i+1) {
+			t.Fatalf("event %d is for block %d, want blocks in order from 1: %v", i, bn, blocks)
+		}
+	}
+	if p.last != 20-confirmations {
+		t.Errorf("last = %d, want %d", p.last, 20-confirmations)
+	}
+}
+
+// TestRunWithConcurrentWatchChanges adds and removes watches while the loop
+// matches blocks against them and checkpoints its progress, as the watch
+// consumer does. Run it with -race.
+func TestRunWithConcurrentWatchChanges(t *testingpkg.T) {
+	chain := newFakeChain(40, testContract, 2)
+	p, _, ckpt := newTestPoller(chain, testContract, 0)
+
+	ctx, cancel := contextpkg.WithCancel(contextpkg.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.run(ctx)
+	}()
+	churned := make(chan struct{})
+	go func() {
+		defer close(churned)
+		other := common.HexToAddress("0x00000000000000000000000000000000000000aa").Hex()
+		for i := 0; ckpt.last.Load() < 40 && i < 100000; i++ {
+			p.targets.Add(other)
+			p.targets.Remove(other)
+			p.senders.Add(other)
+			p.senders.Remove(other)
+		}
+	}()
+
+	if !waitFor(func() bool { return ckpt.last.Load() == 40 }) {
+		t.Fatalf("checkpoint at %d, want 40", ckpt.last.Load())
+	}
+	<-churned
+	cancel()
+	<-done
+}
+
+// TestRunStopsOnCancel checks that cancelling the context stops the loop
+// promptly, whether it is idle at the tip, pacing a catch-up, or backing off
+// from a failing RPC.
+func TestRunStopsOnCancel(t *testingpkg.T) {
+	for _, tc := range []struct {
+		name  string
+		setup func(p *poller, chain *fakeChain)
+	}{
+		{name: "idle at tip", setup: func(p *poller, chain *fakeChain) {
+			p.cadence = blockCadence{min: timepkg.Hour, max: timepkg.Hour}
+		}},
+		{name: "catching up", setup: func(p *poller, chain *fakeChain) {
+			p.catchupUntil, p.catchupDelay = 10, timepkg.Hour
+		}},
+		{name: "rpc failing", setup: func(p *poller, chain *fakeChain) {
+			p.tips, _ = newTipSource(&failingClient{chainClient: chain, failures: 1 << 30}, "latest", 0)
+			p.errBackoff = backoff{attempts: 1, base: timepkg.Hour, max: timepkg.Hour}
+		}},
+	} {
+		t.Run(tc.name, func(t *testingpkg.T) {
+			chain := newFakeChain(10, testContract, 1)
+			p, sink, ckpt := newTestPoller(chain, testContract, 0)
+			tc.setup(p, chain)
+
+			ctx, cancel := contextpkg.WithCancel(contextpkg.Background())
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				p.run(ctx)
+			}()
+			timepkg.Sleep(50 * timepkg.Millisecond)
+			cancel()
+			select {
+			case <-done:
+			case <-timepkg.After(timepkg.Second):
+				t.Fatal("run did not return within a second of cancellation")
+			}
+			// the block in flight at cancellation was finished, not cut
+			// short between its events and its checkpoint
+			if n := uint64(len(sink.blocks())); n != ckpt.last.Load() {
+				t.Errorf("emitted %d blocks' events but checkpointed block %d", n, ckpt.last.Load())
+			}
+		})
+	}
+}
+
+func TestMessageKey(t *testingpkg.T) {
+	const (
+		contract = "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed"
+		txHash   = "0x88df016429689c079f3b2f6ad39fa052532c56795b733da78a91ebe6a713944b"
+	)
+	for _, tc := range []struct {
+		strategy string
+		want     string
+	}{
+		{strategy: "contract", want: "tenant:" + contract},
+		{strategy: "tx", want: "tenant|" + txHash},
+		{strategy: "txHash", want: "tenant|" + txHash},
+		{strategy: "tenant", want: "tenant"},
+	} {
+		strategy, ok := parsePartitionKey(tc.strategy)
+		if !ok {
+			t.Errorf("parsePartitionKey(%q) rejected a valid strategy", tc.strategy)
+			continue
+		}
+		p := &poller{tenant: "tenant", partitionKey: strategy}
+		if got := p.messageKey(contract, txHash); got != tc.want {
+			t.Errorf("%s: messageKey = %q, want %q", tc.strategy, got, tc.want)
+		}
+	}
+	if _, ok := parsePartitionKey("random"); ok {
+		t.Error("parsePartitionKey accepted an unknown strategy")
+	}
+}
+
+// TestEventKeyedByContract processes a block and checks its events reach
+// the producer keyed tenantId:contract, the default strategy.
+func TestEventKeyedByContract(t *testingpkg.T) {
+	chain := newFakeChain(1, testContract, 3)
+	p, sink, _ := newTestPoller(chain, testContract, 0)
+	blk, _ := chain.BlockByNumber(contextpkg.Background(), mathbig.NewInt(1))
+	if _, err := p.processBlock(contextpkg.Background(), blk, blockOpts{source: "live"}); err != nil {
+		t.Fatal(err)
+	}
+	const want = "tenant:0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed"
+	if len(sink.keys) != 3 {
+		t.Fatalf("emitted %d events, want 3", len(sink.keys))
+	}
+	for i, key := range sink.keys {
+		if key != want {
+			t.Errorf("event %d keyed %q, want %q", i, key, want)
+		}
+	}
+}
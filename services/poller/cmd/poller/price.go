@@ -0,0 +1,149 @@
+package main
+
+import (
+	contextpkg "context"
+	encodingjson "encoding/json"
+	errorspkg "errors"
+	fmtpkg "fmt"
+	iopkg "io"
+	mathpkg "math"
+	nethttppkg "net/http"
+	strconvpkg "strconv"
+	syncpkg "sync"
+	atomicpkg "sync/atomic"
+	timepkg "time"
+)
+
+// PriceProvider supplies the ETH/USD price gas costs are valued at.
+type PriceProvider interface {
+	// ETHUSD returns the latest known price and when its source last
+	// updated it; ok is false while no price is known.
+	ETHUSD(ctx contextpkg.Context) (price float64, updated timepkg.Time, ok bool)
+}
+
+// priceUpdatedAt is the newest price update seen, in unix nanoseconds, for
+// the price age metric; 0 before the first one.
+var priceUpdatedAt atomicpkg.Int64
+
+func notePriceUpdate(at timepkg.Time) {
+	priceUpdatedAt.Store(at.UnixNano())
+}
+
+// ethUSD returns the price to value events at, or false when there is no
+// provider or its price is older than priceMaxAge: USD fields are left out
+// rather than computed from a stale price.
+func (p *poller) ethUSD(ctx contextpkg.Context) (float64, bool) {
+	if p.prices == nil {
+		return 0, false
+	}
+	price, updated, ok := p.prices.ETHUSD(ctx)
+	if !ok || (p.priceMaxAge > 0 && timepkg.Since(updated) > p.priceMaxAge) {
+		return 0, false
+	}
+	return price, true
+}
+
+// httpPriceFeed polls an HTTP endpoint for the ETH/USD price and serves the
+// last good answer.
+type httpPriceFeed struct {
+	url    string
+	client *nethttppkg.Client
+
+	mu      syncpkg.Mutex
+	price   float64
+	updated timepkg.Time
+}
+
+func newHTTPPriceFeed(url string) *httpPriceFeed {
+	return &httpPriceFeed{url: url, client: &nethttppkg.Client{Timeout: 10 * timepkg.Second}}
+}
+
+func (f *httpPriceFeed) ETHUSD(contextpkg.Context) (float64, timepkg.Time, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.price, f.updated, !f.updated.IsZero()
+}
+
+// run refreshes the price every interval until ctx is cancelled. A failed
+// poll keeps the previous price, which ages out through PRICE_MAX_AGE.
+func (f *httpPriceFeed) run(ctx contextpkg.Context, interval timepkg.Duration) {
+	for {
+		if err := f.refresh(ctx); err != nil && ctx.Err() == nil {
+			logFor("price").Warn("price feed poll failed", "err", err)
+		}
+		if !sleepCtx(ctx, interval) {
+			return
+		}
+	}
+}
+
+func (f *httpPriceFeed) refresh(ctx contextpkg.Context) error {
+	req, err := nethttppkg.NewRequestWithContext(ctx, nethttppkg.MethodGet, f.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != nethttppkg.StatusOK {
+		return fmtpkg.Errorf("GET price: status %d", resp.StatusCode)
+	}
+	body, err := iopkg.ReadAll(iopkg.LimitReader(resp.Body, 1<<16))
+	if err != nil {
+		return err
+	}
+	price, err := parsePrice(body)
+	if err != nil {
+		return err
+	}
+	now := timepkg.Now()
+	f.mu.Lock()
+	f.price, f.updated = price, now
+	f.mu.Unlock()
+	notePriceUpdate(now)
+	logFor("price").Debug("ETH/USD price updated", "price", price)
+	return nil
+}
+
+// priceKeys are the fields searched for the price, in order. They cover a
+// bare {"price":…} or {"usd":…}, CoinGecko's {"ethereum":{"usd":…}} and
+// Coinbase's {"data":{"amount":"…"}}.
+var priceKeys = []string{"price", "usd", "amount", "ethereum", "data"}
+
+// parsePrice reads a price feed answer: a bare number or a JSON object
+// holding one under priceKeys, as a number or numeric string.
+func parsePrice(body []byte) (float64, error) {
+	var v any
+	if err := encodingjson.Unmarshal(body, &v); err != nil {
+		return 0, fmtpkg.Errorf("parse price: %w", err)
+	}
+	price, ok := findPrice(v)
+	if !ok {
+		return 0, errorspkg.New("parse price: no price in response")
+	}
+	if price <= 0 || mathpkg.IsInf(price, 0) || mathpkg.IsNaN(price) {
+		return 0, fmtpkg.Errorf("parse price: implausible price %v", price)
+	}
+	return price, nil
+}
+
+func findPrice(v any) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case string:
+		f, err := strconvpkg.ParseFloat(v, 64)
+		return f, err == nil
+	case map[string]any:
+		for _, k := range priceKeys {
+			if inner, ok := v[k]; ok {
+				if f, ok := findPrice(inner); ok {
+					return f, true
+				}
+			}
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	contextpkg "context"
+	binarypkg "encoding/binary"
+
+	"github.com/example/gas-monitor-poller/internal/gaseventpb"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// protobufEncoder encodes gas events for OUTPUT_FORMAT=protobuf, as plain
+// gaseventpb.GasEvent bytes or, with SCHEMA_REGISTRY_URL, in the Confluent
+// wire format: a zero magic byte, the 4-byte schema ID, the message index
+// (0, GasEvent being the first message in the file), then the protobuf.
+type protobufEncoder struct {
+	schemaID uint32
+}
+
+// newProtobufEncoder registers gas_event.proto under subject when
+// registryURL is set.
+func newProtobufEncoder(ctx contextpkg.Context, registryURL, subject string) (*protobufEncoder, error) {
+	if registryURL == "" {
+		return &protobufEncoder{}, nil
+	}
+	id, err := registerSchema(ctx, registryURL, subject, "PROTOBUF", gaseventpb.Schema)
+	if err != nil {
+		return nil, err
+	}
+	return &protobufEncoder{schemaID: id}, nil
+}
+
+// encode converts an event's JSON. Fields carry over by name; the JSON's
+// gwei and ETH floats have no protobuf field and are dropped, leaving the
+// exact *Wei strings.
+func (e *protobufEncoder) encode(value []byte) ([]byte, error) {
+	var ev gaseventpb.GasEvent
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(value, &ev); err != nil {
+		return nil, err
+	}
+	var out []byte
+	if e.schemaID != 0 {
+		out = make([]byte, 6, 6+len(value))
+		binarypkg.BigEndian.PutUint32(out[1:], e.schemaID)
+	}
+	return proto.MarshalOptions{}.MarshalAppend(out, &ev)
+}
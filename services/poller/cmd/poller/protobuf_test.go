@@ -0,0 +1,90 @@
+package main
+
+import (
+	bytespkg "bytes"
+	encodingjson "encoding/json"
+	testingpkg "testing"
+
+	"github.com/example/gas-monitor-poller/internal/gaseventpb"
+	"google.golang.org/protobuf/proto"
+)
+
+// TestProtobufRoundTrip encodes gas events for OUTPUT_FORMAT=protobuf and
+// decodes them with the generated message: every field but the gwei and
+// ETH floats comes back, wei amounts digit for digit.
+func TestProtobufRoundTrip(t *testingpkg.T) {
+	const (
+		contract = "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed"
+		from     = "0xfb6916095ca1df60bb79ce92ce3ea74c37c5d359"
+		txHash   = "0x88df016429689c079f3b2f6ad39fa052532c56795b733da78a91ebe6a713944b"
+	)
+	gwei, eth := 1.5, 0.25
+	full := GasEvent{
+		TenantID: "tenant", ChainID: 1, NetworkName: "mainnet",
+		Contract: contract, TxHash: txHash, EventID: txHash + ":" + contract,
+		BlockNumber: 19_000_000, Timestamp: 1_700_000_000, From: from, To: contract,
+		MethodSignature: "0xa9059cbb", MethodName: "transfer", Source: "live", MatchReason: watchContract,
+		Status: "success", GasUsed: ptr(uint64(51_234)), LogsCount: ptr(3),
+		// beyond float64's 53 bits of precision
+		EffectiveGasPriceWei: "10000000000001", EffectiveGasPriceGwei: &gwei,
+		BaseFeeWei: "9999999999999", PriorityFeeWei: "2", FeeModel: "eip1559",
+		CostWei: "512340000000051234", CostEth: &eth,
+		EthUsdPrice: ptr(3000.5), CostUsd: ptr(1537.28),
+		Transfers: []Transfer{{From: from, To: contract, Value: "123456789012345678901234567890"}},
+		Reorged:   true, Replaces: "0x1d0f3a5f0f51b1d0e5ce4d7dc9ae7c7a8f0c5e3f0a7e0e2f6f1d3c4b5a697887",
+	}
+	want := &gaseventpb.GasEvent{
+		TenantId: "tenant", ChainId: 1, NetworkName: proto.String("mainnet"),
+		Contract: contract, TxHash: txHash, EventId: txHash + ":" + contract,
+		BlockNumber: 19_000_000, Timestamp: 1_700_000_000, From: from, To: contract,
+		MethodSignature: "0xa9059cbb", MethodName: "transfer", Source: "live", MatchReason: watchContract,
+		Status: proto.String("success"), GasUsed: proto.Uint64(51_234), LogsCount: proto.Uint32(3),
+		EffectiveGasPriceWei: proto.String("10000000000001"),
+		BaseFeeWei:           proto.String("9999999999999"), PriorityFeeWei: proto.String("2"), FeeModel: proto.String("eip1559"),
+		CostWei:     proto.String("512340000000051234"),
+		EthUsdPrice: proto.Float64(3000.5), CostUsd: proto.Float64(1537.28),
+		Transfers: []*gaseventpb.Transfer{{From: from, To: contract, Value: "123456789012345678901234567890"}},
+		Reorged:   true, Replaces: proto.String("0x1d0f3a5f0f51b1d0e5ce4d7dc9ae7c7a8f0c5e3f0a7e0e2f6f1d3c4b5a697887"),
+	}
+	noLogs := GasEvent{TenantID: "tenant", ChainID: 1, TxHash: txHash, Status: "success", GasUsed: ptr(uint64(21_000)), LogsCount: ptr(0)}
+	missing := GasEvent{TenantID: "tenant", ChainID: 1, TxHash: txHash, ReceiptMissing: true, Status: "unknown"}
+
+	for _, tc := range []struct {
+		name     string
+		ev       GasEvent
+		schemaID uint32
+		want     *gaseventpb.GasEvent
+	}{
+		{name: "full", ev: full, want: want},
+		{name: "registry wire format", ev: full, schemaID: 7, want: want},
+		// optional fields keep a zero apart from unset
+		{name: "no logs", ev: noLogs, want: &gaseventpb.GasEvent{TenantId: "tenant", ChainId: 1, TxHash: txHash, Status: proto.String("success"), GasUsed: proto.Uint64(21_000), LogsCount: proto.Uint32(0)}},
+		{name: "receipt missing", ev: missing, want: &gaseventpb.GasEvent{TenantId: "tenant", ChainId: 1, TxHash: txHash, ReceiptMissing: true, Status: proto.String("unknown")}},
+	} {
+		t.Run(tc.name, func(t *testingpkg.T) {
+			value, err := encodingjson.Marshal(tc.ev)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, err := (&protobufEncoder{schemaID: tc.schemaID}).encode(value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.schemaID != 0 {
+				// magic byte, schema ID, message index
+				header := []byte{0, 0, 0, 0, 7, 0}
+				if !bytespkg.HasPrefix(out, header) {
+					t.Fatalf("message starts % x, want % x", out[:min(len(out), 6)], header)
+				}
+				out = out[len(header):]
+			}
+			var got gaseventpb.GasEvent
+			if err := proto.Unmarshal(out, &got); err != nil {
+				t.Fatal(err)
+			}
+			if !proto.Equal(&got, tc.want) {
+				t.Errorf("decoded %v\nwant %v", &got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	bytespkg "bytes"
+	contextpkg "context"
+	encodingjson "encoding/json"
+	errorspkg "errors"
+	fmtpkg "fmt"
+	iofspkg "io/fs"
+	nethttppkg "net/http"
+	ospkg "os"
+	slicespkg "slices"
+	strconvpkg "strconv"
+	syncpkg "sync"
+	atomicpkg "sync/atomic"
+	timepkg "time"
+
+	"github.com/IBM/sarama"
+)
+
+// publisher sends gas events to Kafka, retrying transient failures. Events
+// that still cannot be delivered go to the dead-letter topic when one is
+// configured; if that fails too they are appended to the dead-letter file,
+// and replayed from it once Kafka is reachable again.
+type publisher struct {
+	producer sarama.SyncProducer
+	retry    backoff
+	// async, when set, carries gas events instead of producer
+	async *asyncSender
+	// txn, when set, carries every message instead, in Kafka transactions
+	// (KAFKA_TRANSACTIONS); see inTxn
+	txn   sarama.SyncProducer
+	txnMu syncpkg.Mutex
+	// sink receives every message: a KafkaSink on producer, or with SINK
+	// set another sink, with producer nil and none of the Kafka
+	// machinery (retries, dead letters, async, transactions) in the way
+	sink EventSink
+
+	// dlqTopic receives messages given up on (KAFKA_TOPIC_DLQ); they are
+	// not replayed from it
+	dlqTopic       string
+	deadLetterPath string
+	deadLetterMu   syncpkg.Mutex
+
+	failed atomicpkg.Uint64
+}
+
+// send delivers msg, returning an error only if the event was neither sent
+// nor dead-lettered.
+func (pub *publisher) send(ctx contextpkg.Context, msg *sarama.ProducerMessage) error {
+	return pub.enqueue(ctx, msg).wait()
+}
+
+// enqueue starts delivering msg. In sync mode the outcome is known when it
+// returns; in async mode it only blocks while the in-flight buffer is full.
+func (pub *publisher) enqueue(ctx contextpkg.Context, msg *sarama.ProducerMessage) *delivery {
+	if pub.producer == nil {
+		return settled(emitToSink(ctx, pub.sink, msg))
+	}
+	injectTrace(ctx, msg)
+	if pub.txn != nil {
+		// a message sent on its own gets a transaction of its own
+		return settled(pub.inTxn(ctx, func(contextpkg.Context) error { return pub.sendTxn(msg) }))
+	}
+	if pub.async != nil {
+		return pub.async.enqueue(msg)
+	}
+	return settled(pub.sendSync(ctx, msg))
+}
+
+// emitToSink hands msg to sink, with its topic and headers in the context
+// for a KafkaSink.
+func emitToSink(ctx contextpkg.Context, sink EventSink, msg *sarama.ProducerMessage) error {
+	var key string
+	if msg.Key != nil {
+		k, err := msg.Key.Encode()
+		if err != nil {
+			return err
+		}
+		key = string(k)
+	}
+	value, err := msg.Value.Encode()
+	if err != nil {
+		return err
+	}
+	return sink.Emit(withSinkMessage(ctx, msg.Topic, msg.Headers), key, value)
+}
+
+// close flushes any messages still in flight and closes the sink.
+func (pub *publisher) close() {
+	if pub.async != nil {
+		pub.async.close()
+	}
+	if pub.txn != nil {
+		if err := pub.txn.Close(); err != nil {
+			logFor("kafka").Warn("close transactional producer", "err", err)
+		}
+	}
+	// last, as failed async sends are dead-lettered through producer
+	if err := pub.sink.Close(); err != nil {
+		logFor("kafka").Warn("close sink", "err", err)
+	}
+}
+
+func (pub *publisher) sendSync(ctx contextpkg.Context, msg *sarama.ProducerMessage) error {
+	err := retry(ctx, pub.retry, func() error {
+		if err := emitToSink(ctx, pub.sink, msg); err != nil {
+			kafkaSendErrors.Inc()
+			logFor("kafka").Warn("kafka send failed", "topic", msg.Topic, "err", err)
+			return err
+		}
+		kafkaSent.Inc()
+		return nil
+	})
+	if err == nil {
+		return nil
+	}
+	return pub.giveUp(msg, err)
+}
+
+// giveUp handles a message whose attempts are used up, dead-lettering it
+// when configured. It returns nil if the message was parked.
+func (pub *publisher) giveUp(msg *sarama.ProducerMessage, err error) error {
+	total := pub.failed.Add(1)
+	logFor("kafka").Error("kafka send gave up", "topic", msg.Topic, "attempts", pub.retry.attempts, "totalFailures", total, "err", err)
+	if pub.dlqTopic != "" {
+		dlqErr := pub.sendDLQ(msg, err)
+		if dlqErr == nil {
+			return nil
+		}
+		logFor("kafka").Error("dead-letter topic send failed", "topic", pub.dlqTopic, "err", dlqErr)
+	}
+	if pub.deadLetterPath == "" {
+		return err
+	}
+	if dlErr := pub.deadLetter(msg); dlErr != nil {
+		return fmtpkg.Errorf("%w (dead-letter: %v)", err, dlErr)
+	}
+	return nil
+}
+
+// sendDLQ publishes msg to the dead-letter topic as it was, plus headers
+// naming the topic it was meant for and why it failed. It is tried once:
+// when it fails, Kafka is most likely down and the dead-letter file is the
+// better bet.
+func (pub *publisher) sendDLQ(msg *sarama.ProducerMessage, cause error) error {
+	dlq := &sarama.ProducerMessage{
+		Topic: pub.dlqTopic,
+		Key:   msg.Key,
+		Value: msg.Value,
+		Headers: append(slicespkg.Clone(msg.Headers),
+			sarama.RecordHeader{Key: []byte(headerDLQTopic), Value: []byte(msg.Topic)},
+			sarama.RecordHeader{Key: []byte(headerDLQError), Value: []byte(cause.Error())},
+			sarama.RecordHeader{Key: []byte(headerDLQAttempts), Value: []byte(strconvpkg.Itoa(pub.retry.attempts))},
+		),
+	}
+	if _, _, err := pub.producer.SendMessage(dlq); err != nil {
+		kafkaSendErrors.Inc()
+		return err
+	}
+	kafkaSent.Inc()
+	dlqPublished.Inc()
+	logFor("kafka").Warn("message sent to dead-letter topic", "topic", pub.dlqTopic, "originalTopic", msg.Topic)
+	return nil
+}
+
+// delivery is the outcome of one enqueued message.
+type delivery struct {
+	done chan error
+}
+
+func settled(err error) *delivery {
+	d := &delivery{done: make(chan error, 1)}
+	d.done <- err
+	return d
+}
+
+// wait blocks until the message was delivered or given up on. It may only
+// be called once.
+func (d *delivery) wait() error { return <-d.done }
+
+// failed reports an error if the delivery has already finished and failed,
+// without waiting. A failed delivery must not be waited on again.
+func (d *delivery) failed() error {
+	select {
+	case err := <-d.done:
+		if err == nil {
+			d.done <- nil
+		}
+		return err
+	default:
+		return nil
+	}
+}
+
+// deadLetterRecord is one line of the dead-letter file, holding everything
+// needed to send the message again.
+type deadLetterRecord struct {
+	Topic   string                  `json:"topic"`
+	Key     string                  `json:"key,omitempty"`
+	Value   encodingjson.RawMessage `json:"value"`
+	Headers map[string]string       `json:"headers,omitempty"`
+}
+
+func (pub *publisher) deadLetter(msg *sarama.ProducerMessage) error {
+	value, err := msg.Value.Encode()
+	if err != nil {
+		return err
+	}
+	rec := deadLetterRecord{Topic: msg.Topic, Value: value}
+	for _, h := range msg.Headers {
+		if rec.Headers == nil {
+			rec.Headers = make(map[string]string, len(msg.Headers))
+		}
+		rec.Headers[string(h.Key)] = string(h.Value)
+	}
+	if msg.Key != nil {
+		key, err := msg.Key.Encode()
+		if err != nil {
+			return err
+		}
+		rec.Key = string(key)
+	}
+	line, err := encodingjson.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	pub.deadLetterMu.Lock()
+	defer pub.deadLetterMu.Unlock()
+	f, err := ospkg.OpenFile(pub.deadLetterPath, ospkg.O_APPEND|ospkg.O_CREATE|ospkg.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	deadLettered.Inc()
+	return f.Close()
+}
+
+// replayLoop drains the dead-letter file back into Kafka at startup and
+// then every interval, so events parked during an outage are delivered once
+// Kafka recovers.
+func (pub *publisher) replayLoop(ctx contextpkg.Context, interval timepkg.Duration) {
+	if pub.deadLetterPath == "" {
+		return
+	}
+	for {
+		if _, _, err := pub.replayDeadLetters(); err != nil {
+			logFor("kafka").Warn("replay dead letters", "err", err)
+		}
+		if !sleepCtx(ctx, interval) {
+			return
+		}
+	}
+}
+
+// replayDeadLetters sends the parked messages in order, stopping at the
+// first failure, and rewrites the file with whatever is left. It returns
+// how many were sent and how many are still parked.
+func (pub *publisher) replayDeadLetters() (sent, left int, err error) {
+	pub.deadLetterMu.Lock()
+	defer pub.deadLetterMu.Unlock()
+	data, err := ospkg.ReadFile(pub.deadLetterPath)
+	if errorspkg.Is(err, iofspkg.ErrNotExist) || len(data) == 0 {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	lines := bytespkg.Split(bytespkg.TrimRight(data, "\n"), []byte("\n"))
+	for _, line := range lines {
+		var rec deadLetterRecord
+		if err := encodingjson.Unmarshal(line, &rec); err != nil || rec.Topic == "" {
+			logFor("kafka").Error("dropping unreadable dead letter", "line", fmtpkg.Sprintf("%.200s", line))
+			sent++
+			continue
+		}
+		msg := &sarama.ProducerMessage{Topic: rec.Topic, Value: sarama.ByteEncoder(rec.Value)}
+		if rec.Key != "" {
+			msg.Key = sarama.StringEncoder(rec.Key)
+		}
+		for k, v := range rec.Headers {
+			msg.Headers = append(msg.Headers, sarama.RecordHeader{Key: []byte(k), Value: []byte(v)})
+		}
+		if _, _, err := pub.producer.SendMessage(msg); err != nil {
+			kafkaSendErrors.Inc()
+			break
+		}
+		kafkaSent.Inc()
+		deadLettersReplayed.Inc()
+		sent++
+	}
+	left = len(lines) - sent
+	if sent == 0 {
+		return 0, left, nil
+	}
+	logFor("kafka").Info("replayed dead letters", "sent", sent, "parked", len(lines))
+	if left == 0 {
+		return sent, 0, ospkg.Remove(pub.deadLetterPath)
+	}
+	rest := append(bytespkg.Join(lines[sent:], []byte("\n")), '\n')
+	return sent, left, writeFileAtomic(pub.deadLetterPath, rest)
+}
+
+// registerReplay adds POST /dead-letters/replay, which drains the
+// dead-letter file now instead of at the next DEAD_LETTER_REPLAY_INTERVAL.
+func (pub *publisher) registerReplay(mux *nethttppkg.ServeMux) {
+	mux.HandleFunc("POST /dead-letters/replay", func(w nethttppkg.ResponseWriter, r *nethttppkg.Request) {
+		if pub.deadLetterPath == "" {
+			nethttppkg.Error(w, "DEAD_LETTER_FILE is not set", nethttppkg.StatusNotFound)
+			return
+		}
+		if pub.producer == nil {
+			nethttppkg.Error(w, "dead letters are replayed to Kafka, which SINK replaces", nethttppkg.StatusConflict)
+			return
+		}
+		sent, left, err := pub.replayDeadLetters()
+		w.Header().Set("Content-Type", "application/json")
+		resp := map[string]any{"sent": sent, "parked": left}
+		if err != nil {
+			w.WriteHeader(nethttppkg.StatusInternalServerError)
+			resp["error"] = err.Error()
+		}
+		_ = encodingjson.NewEncoder(w).Encode(resp)
+	})
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	contextpkg "context"
+	errorspkg "errors"
+	mathpkg "math"
+	nethttppkg "net/http"
+	strconvpkg "strconv"
+	stringspkg "strings"
+	syncpkg "sync"
+	timepkg "time"
+
+	"github.com/ethereum/go-ethereum/rpc"
+	"golang.org/x/time/rate"
+)
+
+// limitExceeded is the JSON-RPC error code Infura answers with when a
+// project goes over its request rate; Alchemy and others reuse HTTP's 429.
+const limitExceeded = -32005
+
+// isRateLimited reports whether err is a provider telling us to slow down:
+// an HTTP 429, a JSON-RPC 429 or -32005, or an error whose message says so.
+func isRateLimited(err error) bool {
+	if err == nil {
+		return false
+	}
+	var httpErr rpc.HTTPError
+	if errorspkg.As(err, &httpErr) && httpErr.StatusCode == nethttppkg.StatusTooManyRequests {
+		return true
+	}
+	var rpcErr rpc.Error
+	if errorspkg.As(err, &rpcErr) {
+		if code := rpcErr.ErrorCode(); code == nethttppkg.StatusTooManyRequests || code == limitExceeded {
+			return true
+		}
+	}
+	msg := stringspkg.ToLower(err.Error())
+	return stringspkg.Contains(msg, "rate limit") || stringspkg.Contains(msg, "too many requests")
+}
+
+// backoffHint returns the wait a provider put in a JSON-RPC error's data,
+// as Infura does with backoff_seconds.
+func backoffHint(err error) (timepkg.Duration, bool) {
+	var dataErr rpc.DataError
+	if !errorspkg.As(err, &dataErr) {
+		return 0, false
+	}
+	data, ok := dataErr.ErrorData().(map[string]interface{})
+	if !ok {
+		return 0, false
+	}
+	secs, ok := data["backoff_seconds"].(float64)
+	if !ok || secs <= 0 {
+		return 0, false
+	}
+	return timepkg.Duration(secs * float64(timepkg.Second)), true
+}
+
+// retryAfterTransport remembers the Retry-After of the last 429 an HTTP
+// endpoint sent, which the JSON-RPC client otherwise throws away.
+type retryAfterTransport struct {
+	base nethttppkg.RoundTripper
+
+	mu   syncpkg.Mutex
+	hint timepkg.Duration
+}
+
+func (t *retryAfterTransport) RoundTrip(req *nethttppkg.Request) (*nethttppkg.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err == nil && resp.StatusCode == nethttppkg.StatusTooManyRequests {
+		if d, ok := parseRetryAfter(resp.Header.Get("Retry-After"), timepkg.Now()); ok {
+			t.mu.Lock()
+			t.hint = d
+			t.mu.Unlock()
+		}
+	}
+	return resp, err
+}
+
+// take returns and clears the remembered Retry-After.
+func (t *retryAfterTransport) take() (timepkg.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	d := t.hint
+	t.hint = 0
+	return d, d > 0
+}
+
+// parseRetryAfter accepts both forms of the header: delay seconds or an
+// HTTP date.
+func parseRetryAfter(v string, now timepkg.Time) (timepkg.Duration, bool) {
+	v = stringspkg.TrimSpace(v)
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconvpkg.Atoi(v); err == nil {
+		return timepkg.Duration(secs) * timepkg.Second, secs > 0
+	}
+	if at, err := nethttppkg.ParseTime(v); err == nil && at.After(now) {
+		return at.Sub(now), true
+	}
+	return 0, false
+}
+
+// throttle pauses every call through f after ep was rate limited, for as
+// long as the provider asked or rateLimitPause when it did not say.
+func (f *failoverClient) throttle(ep *rpcEndpoint, err error) {
+	pause, ok := backoffHint(err)
+	if !ok && ep.limits != nil {
+		pause, ok = ep.limits.take()
+	}
+	if !ok {
+		pause = f.rateLimitPause
+	}
+	rateLimited.Inc()
+	until := timepkg.Now().Add(pause)
+	f.mu.Lock()
+	if until.After(f.pausedUntil) {
+		f.pausedUntil = until
+	}
+	f.mu.Unlock()
+	logFor("rpc").Warn("rate limited, pausing RPC calls", "endpoint", ep.name, "pause", pause.String(), "err", err)
+}
+
+// waitRateLimit blocks until any rate-limit pause is over.
+func (f *failoverClient) waitRateLimit(ctx contextpkg.Context) error {
+	f.mu.Lock()
+	wait := timepkg.Until(f.pausedUntil)
+	f.mu.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+	if !sleepCtx(ctx, wait) {
+		return ctx.Err()
+	}
+	return nil
+}
+
+// newRPCLimiter returns a token bucket allowing rps calls per second with
+// bursts of up to burst, or nil when rps is 0. A burst of 0 defaults to one
+// second's worth of calls.
+func newRPCLimiter(rps float64, burst int) *rate.Limiter {
+	if rps <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = int(mathpkg.Ceil(rps))
+	}
+	return rate.NewLimiter(rate.Limit(rps), burst)
+}
+
+// acquire waits out any rate-limit pause and then for cost tokens from the
+// client-side limiter, cost being the number of calls about to be made.
+// Every RPC call goes through it, so live tailing, backfills and receipt
+// fetches share one budget, and a batch pays for each call it carries.
+func (f *failoverClient) acquire(ctx contextpkg.Context, cost int) error {
+	if err := f.waitRateLimit(ctx); err != nil {
+		return err
+	}
+	if f.limiter == nil {
+		return nil
+	}
+	start := timepkg.Now()
+	defer func() { rpcLimiterWait.Observe(timepkg.Since(start).Seconds()) }()
+	for cost > 0 {
+		// WaitN refuses more than the burst at once
+		n := min(cost, f.limiter.Burst())
+		if err := f.limiter.WaitN(ctx, n); err != nil {
+			return err
+		}
+		cost -= n
+	}
+	return nil
+}
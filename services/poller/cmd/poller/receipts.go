@@ -0,0 +1,94 @@
+package main
+
+import (
+	contextpkg "context"
+	errorspkg "errors"
+	atomicpkg "sync/atomic"
+
+	"github.com/ethereum/go-ethereum/common"
+	typespkg "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// methodNotFound is the JSON-RPC error code a node returns for a method it
+// does not implement.
+const methodNotFound = -32601
+
+func isMethodNotFound(err error) bool {
+	var rpcErr rpc.Error
+	return errorspkg.As(err, &rpcErr) && rpcErr.ErrorCode() == methodNotFound
+}
+
+// batchReceipts fetches a block's receipts with a single eth_getBlockReceipts
+// call. A method-not-found answer disables it until the client fails over to
+// another endpoint, and meanwhile receipts are fetched one transaction at a
+// time.
+type batchReceipts struct {
+	unsupported atomicpkg.Bool
+	// endpoint is the endpoint generation the unsupported answer applies to
+	endpoint atomicpkg.Uint64
+}
+
+// endpointGeneration changes whenever client switches endpoints; clients
+// without failover always report 0.
+func endpointGeneration(client chainClient) uint64 {
+	if g, ok := client.(interface{ generation() uint64 }); ok {
+		return g.generation()
+	}
+	return 0
+}
+
+// probe asks the active endpoint for the head block's receipts so support
+// for eth_getBlockReceipts is known before the first matched block. If the
+// head cannot be fetched the first block decides instead.
+func (b *batchReceipts) probe(ctx contextpkg.Context, client chainClient) {
+	b.usable(client)
+	head, err := client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return
+	}
+	_, err = client.BlockReceipts(ctx, head.Hash())
+	switch {
+	case isMethodNotFound(err):
+		b.disable()
+	case err == nil:
+		logFor("rpc").Info("using eth_getBlockReceipts for receipts")
+	}
+}
+
+// usable reports whether the batch call is worth trying, giving a new
+// endpoint a fresh chance after a failover.
+func (b *batchReceipts) usable(client chainClient) bool {
+	if gen := endpointGeneration(client); b.endpoint.Swap(gen) != gen && b.unsupported.Swap(false) {
+		logFor("rpc").Info("rpc endpoint changed, trying eth_getBlockReceipts again")
+	}
+	return !b.unsupported.Load()
+}
+
+func (b *batchReceipts) disable() {
+	if !b.unsupported.Swap(true) {
+		logFor("rpc").Info("eth_getBlockReceipts not supported, fetching receipts per transaction")
+	}
+}
+
+// forBlock returns the block's receipts indexed by tx hash, or nil when the
+// batch call is unavailable or failed; callers fall back to per-tx fetches.
+func (b *batchReceipts) forBlock(ctx contextpkg.Context, client chainClient, blk *typespkg.Block) map[common.Hash]*typespkg.Receipt {
+	if !b.usable(client) {
+		return nil
+	}
+	receipts, err := client.BlockReceipts(ctx, blk.Hash())
+	if isMethodNotFound(err) {
+		b.disable()
+		return nil
+	}
+	if err != nil {
+		logFor("rpc").Warn("block receipts failed, fetching per transaction", "block", blk.NumberU64(), "err", err)
+		return nil
+	}
+	byHash := make(map[common.Hash]*typespkg.Receipt, len(receipts))
+	for _, r := range receipts {
+		byHash[r.TxHash] = r
+	}
+	return byHash
+}
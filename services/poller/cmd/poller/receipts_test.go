@@ -0,0 +1,184 @@
+package main
+
+import (
+	contextpkg "context"
+	encodingjson "encoding/json"
+	iopkg "io"
+	mathbig "math/big"
+	nethttppkg "net/http"
+	httptestpkg "net/http/httptest"
+	syncpkg "sync"
+	testingpkg "testing"
+	timepkg "time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// BenchmarkBlockReceipts prepares a block of 200 matched txs, fetching
+// their receipts one call per tx, in JSON-RPC batches, or with a single
+// eth_getBlockReceipts, over an RPC with a fixed round trip.
+func BenchmarkBlockReceipts(b *testingpkg.B) {
+	for _, bc := range []struct {
+		name          string
+		blockReceipts bool
+		rpcBatch      int
+	}{
+		{name: "per-tx"},
+		{name: "json-rpc-batch", rpcBatch: 50},
+		{name: "eth_getBlockReceipts", blockReceipts: true},
+	} {
+		b.Run(bc.name, func(b *testingpkg.B) {
+			chain := newFakeChain(1, testContract, 200)
+			chain.blockReceipts = bc.blockReceipts
+			chain.latency = 50 * timepkg.Microsecond
+			p, _, _ := newTestPoller(chain, testContract, 0)
+			p.rpcBatch = bc.rpcBatch
+			// as at startup, so the timed runs already know whether the
+			// node has eth_getBlockReceipts
+			p.batch.probe(contextpkg.Background(), chain)
+			blk, _ := chain.BlockByNumber(contextpkg.Background(), mathbig.NewInt(1))
+			calls := chain.receiptCalls.Load() + chain.blockReceiptCalls.Load()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				prep := p.prepareBlock(contextpkg.Background(), blk, blockOpts{source: "live"})
+				if len(prep.receipts) != 200 {
+					b.Fatalf("prepared %d receipts, want 200", len(prep.receipts))
+				}
+			}
+			b.StopTimer()
+			calls = chain.receiptCalls.Load() + chain.blockReceiptCalls.Load() - calls
+			b.ReportMetric(float64(calls)/float64(b.N), "calls/op")
+		})
+	}
+}
+
+// rpcServer is a JSON-RPC endpoint over chain that counts the calls it
+// gets by method. With getBlockReceipts false it answers that method with
+// method-not-found, like nodes that lack it.
+type rpcServer struct {
+	chain            *fakeChain
+	getBlockReceipts bool
+
+	mu    syncpkg.Mutex
+	calls map[string]int
+}
+
+type rpcRequest struct {
+	ID     encodingjson.RawMessage   `json:"id"`
+	Method string                    `json:"method"`
+	Params []encodingjson.RawMessage `json:"params"`
+}
+
+func (s *rpcServer) ServeHTTP(w nethttppkg.ResponseWriter, r *nethttppkg.Request) {
+	body, _ := iopkg.ReadAll(r.Body)
+	var reqs []rpcRequest
+	batch := len(body) > 0 && body[0] == '['
+	if batch {
+		_ = encodingjson.Unmarshal(body, &reqs)
+	} else {
+		var req rpcRequest
+		_ = encodingjson.Unmarshal(body, &req)
+		reqs = []rpcRequest{req}
+	}
+	var resps []map[string]any
+	for _, req := range reqs {
+		resp := map[string]any{"jsonrpc": "2.0", "id": req.ID}
+		result, code := s.answer(req)
+		if code != 0 {
+			resp["error"] = map[string]any{"code": code, "message": "the method does not exist/is not available"}
+		} else {
+			resp["result"] = result
+		}
+		resps = append(resps, resp)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if batch {
+		_ = encodingjson.NewEncoder(w).Encode(resps)
+	} else {
+		_ = encodingjson.NewEncoder(w).Encode(resps[0])
+	}
+}
+
+func (s *rpcServer) answer(req rpcRequest) (any, int) {
+	s.mu.Lock()
+	s.calls[req.Method]++
+	s.mu.Unlock()
+	switch req.Method {
+	case "eth_chainId":
+		return "0x1", 0
+	case "eth_getBlockByNumber":
+		hdr, _ := s.chain.HeaderByNumber(contextpkg.Background(), nil)
+		return hdr, 0
+	case "eth_getTransactionReceipt":
+		var hash common.Hash
+		_ = encodingjson.Unmarshal(req.Params[0], &hash)
+		rec, _ := s.chain.receipt(hash)
+		return rec, 0
+	case "eth_getBlockReceipts":
+		if !s.getBlockReceipts {
+			return nil, methodNotFound
+		}
+		var hash common.Hash
+		_ = encodingjson.Unmarshal(req.Params[0], &hash)
+		s.chain.blockReceipts = true
+		receipts, _ := s.chain.BlockReceipts(contextpkg.Background(), hash)
+		return receipts, 0
+	}
+	return nil, methodNotFound
+}
+
+func (s *rpcServer) count(method string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[method]
+}
+
+// TestBlockReceiptsSingleCall prepares a block with 10 matched txs through
+// a real RPC client against a mock node, and checks the receipts come
+// from one eth_getBlockReceipts call when the node has it.
+func TestBlockReceiptsSingleCall(t *testingpkg.T) {
+	for _, tc := range []struct {
+		name             string
+		getBlockReceipts bool
+		wantBatchCalls   int
+		wantPerTxCalls   int
+	}{
+		// one call for the startup probe and one for the block
+		{name: "supported", getBlockReceipts: true, wantBatchCalls: 2},
+		// only the probe, which disables it
+		{name: "unsupported", wantBatchCalls: 1, wantPerTxCalls: 10},
+	} {
+		t.Run(tc.name, func(t *testingpkg.T) {
+			chain := newFakeChain(1, testContract, 10)
+			srv := &rpcServer{chain: chain, getBlockReceipts: tc.getBlockReceipts, calls: make(map[string]int)}
+			ts := httptestpkg.NewServer(srv)
+			defer ts.Close()
+
+			ctx := contextpkg.Background()
+			client, err := dialFailover(ctx, []string{ts.URL}, 3)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			p, _, _ := newTestPoller(client, testContract, 0)
+			p.batch.probe(ctx, client)
+
+			blk, _ := chain.BlockByNumber(ctx, mathbig.NewInt(1))
+			prep := p.prepareBlock(ctx, blk, blockOpts{source: "live"})
+			if len(prep.matches) != 10 || len(prep.receipts) != 10 {
+				t.Fatalf("prepared %d matches with %d receipts, want 10 of each", len(prep.matches), len(prep.receipts))
+			}
+			for hash, rec := range prep.receipts {
+				if rec == nil || rec.TxHash != hash {
+					t.Fatalf("receipt for %s = %+v", hash.Hex(), rec)
+				}
+			}
+			if got := srv.count("eth_getBlockReceipts"); got != tc.wantBatchCalls {
+				t.Errorf("eth_getBlockReceipts called %d times, want %d", got, tc.wantBatchCalls)
+			}
+			if got := srv.count("eth_getTransactionReceipt"); got != tc.wantPerTxCalls {
+				t.Errorf("eth_getTransactionReceipt called %d times, want %d", got, tc.wantPerTxCalls)
+			}
+		})
+	}
+}
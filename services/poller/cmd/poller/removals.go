@@ -0,0 +1,52 @@
+package main
+
+import (
+	contextpkg "context"
+	encodingjson "encoding/json"
+
+	"github.com/IBM/sarama"
+)
+
+// announceRemovals sends a watchRemoved marker for each contract watch
+// removed before prep was matched, once prep is emitted: prep is the first
+// block without the contract, so the marker follows its last event. A
+// contract added back in the meantime is still watched and gets none. A
+// marker that fails to send stays pending and is retried with the next
+// block emitted.
+//
+// Markers go to the corrections topic, keyed like the contract's events, so
+// the gas topic holds only GasEvents.
+func (p *poller) announceRemovals(ctx contextpkg.Context, prep *preparedBlock) {
+	if prep.targets == nil {
+		return
+	}
+	blk := prep.blk
+	for _, r := range p.targets.pendingRemovals(prep.targets.version) {
+		if prep.targets.Contains(r.addr) {
+			p.targets.forgetRemoval(r)
+			continue
+		}
+		value, _ := encodingjson.Marshal(map[string]any{
+			"type":     "watchRemoved",
+			"tenantId": p.tenant,
+			"chainId":  p.chainID.Uint64(),
+			"contract": r.addr,
+			"eventId":  eventID(p.tenant, "watchRemoved:"+r.addr, blk.Hash().Hex()),
+			// the first block not matched for the contract
+			"effectiveBlock": blk.NumberU64(),
+			"timestamp":      blk.Time(),
+		})
+		err := p.pub.send(ctx, &sarama.ProducerMessage{
+			Topic:   p.correctionsTopic,
+			Key:     sarama.StringEncoder(p.messageKey(r.addr, "")),
+			Value:   sarama.ByteEncoder(value),
+			Headers: p.headers(r.addr, "live"),
+		})
+		if err != nil {
+			logFor("watches").Error("send watchRemoved marker, retrying with the next block", "contract", r.addr, "err", err)
+			continue
+		}
+		p.targets.forgetRemoval(r)
+		logFor("watches").Info("watch removed", "contract", r.addr, "effectiveBlock", blk.NumberU64())
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	contextpkg "context"
+	mathbig "math/big"
+	testingpkg "testing"
+)
+
+// TestRemovalAtBlockBoundary removes a watch after a block was picked up
+// but before it is matched: that block is still captured whole, the next
+// one is skipped whole, and a watchRemoved marker naming the next one
+// follows on the corrections topic, never the gas topic.
+func TestRemovalAtBlockBoundary(t *testingpkg.T) {
+	const txs = 5
+	ctx := contextpkg.Background()
+	chain := newFakeChain(2, testContract, txs)
+	p, sink, _ := newTestPoller(chain, testContract, 0)
+	p.correctionsTopic = "onchain-gas-corrections"
+	p.targets.trackRemovals()
+
+	emit := func(bn int64, opts blockOpts) {
+		t.Helper()
+		blk, _ := chain.BlockByNumber(ctx, mathbig.NewInt(bn))
+		prep := p.prepareBlock(ctx, blk, opts)
+		if _, err := p.emitBlock(ctx, prep, opts); err != nil {
+			t.Fatal(err)
+		}
+		p.announceRemovals(ctx, prep)
+	}
+	picked := blockOpts{source: "live", targets: p.targets.View(), senders: p.senders.View()}
+	p.targets.Remove(testContract.Hex())
+	emit(1, picked)
+	if got := sink.blocks(); len(got) != txs {
+		t.Fatalf("block 1 emitted %d of its %d events", len(got), txs)
+	}
+	emit(2, blockOpts{source: "live"})
+
+	if len(sink.events) != txs+1 {
+		t.Fatalf("emitted %d messages, want block 1's %d events and one marker", len(sink.events), txs)
+	}
+	for i := 0; i < txs; i++ {
+		if sink.topics[i] != "onchain-gas" || sink.events[i].BlockNumber != 1 {
+			t.Errorf("message %d: block %d on %q, want block 1 on the gas topic", i, sink.events[i].BlockNumber, sink.topics[i])
+		}
+	}
+	marker := sink.events[txs]
+	if sink.topics[txs] != "onchain-gas-corrections" {
+		t.Errorf("marker sent to %q, want the corrections topic", sink.topics[txs])
+	}
+	if want := "tenant:0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed"; sink.keys[txs] != want || marker.Contract != want[len("tenant:"):] {
+		t.Errorf("marker for %q keyed %q, want the contract's key %q", marker.Contract, sink.keys[txs], want)
+	}
+	// effectiveBlock is not a GasEvent field, but the timestamp is block 2's
+	if blk2, _ := chain.BlockByNumber(ctx, mathbig.NewInt(2)); marker.Timestamp != blk2.Time() {
+		t.Errorf("marker timestamp %d, want block 2's %d", marker.Timestamp, blk2.Time())
+	}
+	if len(p.targets.pendingRemovals(^uint64(0))) != 0 {
+		t.Error("removal still pending after its marker was sent")
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	contextpkg "context"
+	encodingjson "encoding/json"
+	fmtpkg "fmt"
+	mathbig "math/big"
+
+	"github.com/IBM/sarama"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// blockRing remembers the hashes of the most recently processed blocks so a
+// new block's parent hash can be checked against what we emitted for its
+// parent, along with the tx hashes emitted for each block so they can be
+// invalidated if the block is orphaned.
+type blockRing struct {
+	size   uint64
+	hashes map[uint64]ringEntry
+	top    uint64
+}
+
+type ringEntry struct {
+	hash common.Hash
+	txs  []emittedTx
+}
+
+// emittedTx is a tx a block emitted events for, with the contract its
+// first event was keyed by, so a correction for it is keyed alike.
+type emittedTx struct {
+	hash     string
+	contract string
+}
+
+func newBlockRing(size uint64) *blockRing {
+	if size == 0 {
+		size = 1
+	}
+	return &blockRing{size: size, hashes: make(map[uint64]ringEntry)}
+}
+
+func (r *blockRing) Put(number uint64, hash common.Hash, txs []emittedTx) {
+	r.hashes[number] = ringEntry{hash: hash, txs: txs}
+	if number > r.top {
+		r.top = number
+	}
+	if r.top >= r.size {
+		for n := range r.hashes {
+			if n <= r.top-r.size {
+				delete(r.hashes, n)
+			}
+		}
+	}
+}
+
+func (r *blockRing) Get(number uint64) (common.Hash, bool) {
+	e, ok := r.hashes[number]
+	return e.hash, ok
+}
+
+func (r *blockRing) Entry(number uint64) (ringEntry, bool) {
+	e, ok := r.hashes[number]
+	return e, ok
+}
+
+// Truncate forgets every block at or above number.
+func (r *blockRing) Truncate(number uint64) {
+	for n := range r.hashes {
+		if n >= number {
+			delete(r.hashes, n)
+		}
+	}
+	if number == 0 {
+		r.top = 0
+	} else if r.top >= number {
+		r.top = number - 1
+	}
+}
+
+// oldest returns the lowest block number still tracked.
+func (r *blockRing) oldest() (uint64, bool) {
+	var min uint64
+	found := false
+	for n := range r.hashes {
+		if !found || n < min {
+			min, found = n, true
+		}
+	}
+	return min, found
+}
+
+// findForkPoint walks back from number until the canonical header hash matches
+// what we recorded, returning the highest block that is still canonical. If
+// the reorg is deeper than the ring, the oldest tracked block minus one is
+// returned so processing resumes from the start of what we still know about.
+func (p *poller) findForkPoint(ctx contextpkg.Context, number uint64) (uint64, error) {
+	oldest, ok := p.recent.oldest()
+	if !ok {
+		return number, nil
+	}
+	for n := number; n >= oldest; n-- {
+		known, ok := p.recent.Get(n)
+		if !ok {
+			break
+		}
+		hdr, err := p.client.HeaderByNumber(ctx, new(mathbig.Int).SetUint64(n))
+		if err != nil {
+			return 0, err
+		}
+		if hdr.Hash() == known {
+			return n, nil
+		}
+		if n == 0 {
+			break
+		}
+	}
+	logFor("reorg").Warn("reorg deeper than tracked depth, resyncing", "depth", p.recent.size, "block", oldest)
+	if oldest == 0 {
+		return 0, nil
+	}
+	return oldest - 1, nil
+}
+
+// emitOrphaned publishes a correction for every tx we emitted from a tracked
+// block in (fork, upto), so downstream consumers can reverse what they
+// ingested for it. It returns the orphaned block hashes by number so the
+// canonical replacements can reference them.
+func (p *poller) emitOrphaned(ctx contextpkg.Context, fork, upto uint64) (map[uint64]common.Hash, error) {
+	orphaned := make(map[uint64]common.Hash)
+	for n := fork + 1; n < upto; n++ {
+		e, ok := p.recent.Entry(n)
+		if !ok {
+			continue
+		}
+		orphaned[n] = e.hash
+		for _, tx := range e.txs {
+			value, _ := encodingjson.Marshal(map[string]any{
+				"tenantId":    p.tenant,
+				"chainId":     p.chainID.Uint64(),
+				"contract":    tx.contract,
+				"txHash":      tx.hash,
+				"blockNumber": n,
+				"blockHash":   e.hash.Hex(),
+				"reason":      "reorg",
+			})
+			msg := &sarama.ProducerMessage{
+				Topic:   p.correctionsTopic,
+				Key:     sarama.StringEncoder(p.messageKey(tx.contract, tx.hash)),
+				Value:   sarama.ByteEncoder(value),
+				Headers: p.headers(tx.contract, ""),
+			}
+			if err := p.pub.send(ctx, msg); err != nil {
+				return nil, fmtpkg.Errorf("reorg correction for tx %s in block %d: %w", tx.hash, n, err)
+			}
+		}
+	}
+	return orphaned, nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	contextpkg "context"
+	mathbig "math/big"
+	testingpkg "testing"
+)
+
+// TestOrphanedCorrectionKeys orphans a processed block and checks each
+// correction is keyed like the tx's events under every PARTITION_KEY
+// strategy, so two tenants on one chain never share a key.
+func TestOrphanedCorrectionKeys(t *testingpkg.T) {
+	const contract = "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed"
+	ctx := contextpkg.Background()
+	chain := newFakeChain(1, testContract, 2)
+	blk, _ := chain.BlockByNumber(ctx, mathbig.NewInt(1))
+	txs := blk.Transactions()
+	for _, tc := range []struct {
+		strategy string
+		want     func(txHash string) string
+	}{
+		{strategy: partitionByContract, want: func(string) string { return "tenant:" + contract }},
+		{strategy: partitionByTx, want: func(txHash string) string { return "tenant|" + txHash }},
+		{strategy: partitionByTenant, want: func(string) string { return "tenant" }},
+	} {
+		t.Run(tc.strategy, func(t *testingpkg.T) {
+			p, sink, _ := newTestPoller(chain, testContract, 0)
+			p.partitionKey = tc.strategy
+			p.correctionsTopic = "onchain-gas-corrections"
+			emitted, err := p.processBlock(ctx, blk, blockOpts{source: "live"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			p.recent.Put(1, blk.Hash(), emitted)
+			if _, err := p.emitOrphaned(ctx, 0, 2); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(sink.keys) != 2*len(txs) {
+				t.Fatalf("sent %d messages, want %d events and as many corrections", len(sink.keys), len(txs))
+			}
+			for i, tx := range txs {
+				event, correction := i, len(txs)+i
+				if sink.topics[correction] != "onchain-gas-corrections" || sink.events[correction].TxHash != tx.Hash().Hex() {
+					t.Fatalf("message %d is %+v on %q, want the correction for %s", correction, sink.events[correction], sink.topics[correction], tx.Hash().Hex())
+				}
+				want := tc.want(tx.Hash().Hex())
+				if sink.keys[event] != want || sink.keys[correction] != want {
+					t.Errorf("tx %d: event keyed %q, correction %q; want both %q", i, sink.keys[event], sink.keys[correction], want)
+				}
+				if sink.events[correction].Contract != contract {
+					t.Errorf("tx %d: correction names contract %q, want %q", i, sink.events[correction].Contract, contract)
+				}
+			}
+		})
+	}
+}
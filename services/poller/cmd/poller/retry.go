@@ -0,0 +1,89 @@
+package main
+
+import (
+	contextpkg "context"
+	randpkg "math/rand/v2"
+	timepkg "time"
+)
+
+// backoff describes a bounded exponential retry schedule.
+type backoff struct {
+	attempts int
+	base     timepkg.Duration
+	max      timepkg.Duration
+	// factor multiplies the delay after each attempt; 0 means 2
+	factor float64
+}
+
+// delay returns how long to wait after the given failed attempt (0-based):
+// its ceiling with the upper half jittered, so concurrent retries do not
+// fire in lockstep.
+func (b backoff) delay(attempt int) timepkg.Duration {
+	d := b.ceiling(attempt)
+	if half := d / 2; half > 0 {
+		d = half + randpkg.N(half)
+	}
+	return d
+}
+
+// ceiling is the delay after attempt before jitter: base multiplied by
+// factor per attempt, capped at max.
+func (b backoff) ceiling(attempt int) timepkg.Duration {
+	factor := b.factor
+	if factor <= 1 {
+		factor = 2
+	}
+	d := b.base
+	for i := 0; i < attempt && d < b.max; i++ {
+		d = timepkg.Duration(float64(d) * factor)
+	}
+	return min(d, b.max)
+}
+
+// retry calls fn until it succeeds, the attempts are used up or ctx is
+// cancelled, returning the last error.
+func retry(ctx contextpkg.Context, b backoff, fn func() error) error {
+	var err error
+	for attempt := 0; attempt < b.attempts; attempt++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		if attempt == b.attempts-1 {
+			break
+		}
+		if !sleepCtx(ctx, b.delay(attempt)) {
+			return ctx.Err()
+		}
+	}
+	return err
+}
+
+// withRetry is retry for calls that also return a value.
+func withRetry[T any](ctx contextpkg.Context, b backoff, fn func() (T, error)) (T, error) {
+	var out T
+	err := retry(ctx, b, func() error {
+		v, err := fn()
+		if err != nil {
+			return err
+		}
+		out = v
+		return nil
+	})
+	return out, err
+}
+
+// backoffTimer paces a loop that retries forever: each consecutive failure
+// waits longer, and a success starts the schedule over.
+type backoffTimer struct {
+	b        backoff
+	failures int
+}
+
+// wait sleeps for the next delay, reporting false if ctx was cancelled.
+func (t *backoffTimer) wait(ctx contextpkg.Context) bool {
+	d := t.b.delay(t.failures)
+	t.failures++
+	return sleepCtx(ctx, d)
+}
+
+func (t *backoffTimer) reset() { t.failures = 0 }
@@ -0,0 +1,144 @@
+package main
+
+import (
+	contextpkg "context"
+	errorspkg "errors"
+	mathbig "math/big"
+	testingpkg "testing"
+	timepkg "time"
+
+	typespkg "github.com/ethereum/go-ethereum/core/types"
+)
+
+// TestWithRetry runs a block fetch against a client that fails a number of
+// times before succeeding.
+func TestWithRetry(t *testingpkg.T) {
+	chain := newFakeChain(5, testContract, 1)
+	b := backoff{attempts: 4, base: timepkg.Millisecond, max: 2 * timepkg.Millisecond}
+	for _, tc := range []struct {
+		failures  int64
+		wantErr   bool
+		wantCalls int64
+	}{
+		{failures: 0, wantCalls: 1},
+		{failures: 2, wantCalls: 3},
+		{failures: 3, wantCalls: 4},
+		{failures: 4, wantErr: true, wantCalls: 4},
+	} {
+		client := &failingClient{chainClient: chain, failures: tc.failures}
+		blk, err := withRetry(contextpkg.Background(), b, func() (*typespkg.Block, error) {
+			return client.BlockByNumber(contextpkg.Background(), mathbig.NewInt(3))
+		})
+		if tc.wantErr {
+			if !errorspkg.Is(err, errTransient) {
+				t.Errorf("%d failures: err = %v, want the last failure", tc.failures, err)
+			}
+		} else if err != nil || blk.NumberU64() != 3 {
+			t.Errorf("%d failures: got block %v, err %v; want block 3", tc.failures, blk, err)
+		}
+		if got := client.calls.Load(); got != tc.wantCalls {
+			t.Errorf("%d failures: %d calls, want %d", tc.failures, got, tc.wantCalls)
+		}
+	}
+}
+
+func TestWithRetryStopsOnCancel(t *testingpkg.T) {
+	client := &failingClient{chainClient: newFakeChain(1, testContract, 1), failures: 100}
+	ctx, cancel := contextpkg.WithCancel(contextpkg.Background())
+	b := backoff{attempts: 100, base: timepkg.Hour, max: timepkg.Hour}
+	go func() {
+		timepkg.Sleep(20 * timepkg.Millisecond)
+		cancel()
+	}()
+	started := timepkg.Now()
+	_, err := withRetry(ctx, b, func() (*typespkg.Header, error) {
+		return client.HeaderByNumber(ctx, nil)
+	})
+	if !errorspkg.Is(err, contextpkg.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+	if elapsed := timepkg.Since(started); elapsed > timepkg.Second {
+		t.Errorf("retry kept waiting %s after cancellation", elapsed)
+	}
+	if got := client.calls.Load(); got != 1 {
+		t.Errorf("%d calls, want 1 before the backoff was cancelled", got)
+	}
+}
+
+// TestReceiptRetriedNotDropped checks a receipt that fails a few times is
+// still fetched and emitted with its fees.
+func TestReceiptRetriedNotDropped(t *testingpkg.T) {
+	chain := newFakeChain(1, testContract, 1)
+	client := &failingClient{chainClient: chain, failures: 2}
+	p, sink, _ := newTestPoller(client, testContract, 0)
+	blk, _ := chain.BlockByNumber(contextpkg.Background(), mathbig.NewInt(1))
+	if _, err := p.processBlock(contextpkg.Background(), blk, blockOpts{source: "live"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(sink.events) != 1 {
+		t.Fatalf("emitted %d events, want 1", len(sink.events))
+	}
+	if ev := sink.events[0]; ev.ReceiptMissing || ev.GasUsed == nil || *ev.GasUsed != 21000 {
+		t.Errorf("event = %+v, want one with the receipt's gasUsed", ev)
+	}
+}
+
+func TestBackoffDelays(t *testingpkg.T) {
+	ms := timepkg.Millisecond
+	for _, tc := range []struct {
+		name string
+		b    backoff
+		want []timepkg.Duration
+	}{
+		{
+			name: "doubling by default",
+			b:    backoff{base: 100 * ms, max: timepkg.Second},
+			want: []timepkg.Duration{100 * ms, 200 * ms, 400 * ms, 800 * ms, timepkg.Second, timepkg.Second},
+		},
+		{
+			name: "factor 3",
+			b:    backoff{base: 10 * ms, max: 500 * ms, factor: 3},
+			want: []timepkg.Duration{10 * ms, 30 * ms, 90 * ms, 270 * ms, 500 * ms},
+		},
+		{
+			name: "factor of 1 or less means 2",
+			b:    backoff{base: ms, max: 8 * ms, factor: 0.5},
+			want: []timepkg.Duration{ms, 2 * ms, 4 * ms, 8 * ms, 8 * ms},
+		},
+		{
+			name: "base above max",
+			b:    backoff{base: timepkg.Minute, max: timepkg.Second},
+			want: []timepkg.Duration{timepkg.Second, timepkg.Second},
+		},
+	} {
+		t.Run(tc.name, func(t *testingpkg.T) {
+			for attempt, want := range tc.want {
+				if got := tc.b.ceiling(attempt); got != want {
+					t.Errorf("ceiling(%d) = %s, want %s", attempt, got, want)
+				}
+				// the jitter keeps the lower half and randomises the upper
+				for i := 0; i < 100; i++ {
+					if got := tc.b.delay(attempt); got < want/2 || got >= want {
+						t.Fatalf("delay(%d) = %s, want within [%s, %s)", attempt, got, want/2, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+// TestBackoffTimerReset checks a success starts the schedule over.
+func TestBackoffTimerReset(t *testingpkg.T) {
+	bt := backoffTimer{b: backoff{base: timepkg.Microsecond, max: timepkg.Millisecond}}
+	ctx := contextpkg.Background()
+	for i := 0; i < 3; i++ {
+		bt.wait(ctx)
+	}
+	if bt.failures != 3 || bt.b.ceiling(bt.failures) != 8*timepkg.Microsecond {
+		t.Fatalf("after 3 failures the next ceiling is %s, want 8µs", bt.b.ceiling(bt.failures))
+	}
+	bt.reset()
+	if bt.b.ceiling(bt.failures) != timepkg.Microsecond {
+		t.Errorf("after reset the next ceiling is %s, want the 1µs base", bt.b.ceiling(bt.failures))
+	}
+}
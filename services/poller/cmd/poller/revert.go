@@ -0,0 +1,94 @@
+package main
+
+import (
+	contextpkg "context"
+	errorspkg "errors"
+	fmtpkg "fmt"
+	mathbig "math/big"
+	stringspkg "strings"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	typespkg "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// abiFor returns contract's ABI from ABI_DIR, or nil.
+func (r *methodResolver) abiFor(contract string) *abi.ABI {
+	if r == nil {
+		return nil
+	}
+	return r.abis[contract]
+}
+
+// revertReason replays a reverted tx as an eth_call on top of its parent
+// block and decodes the revert data. Receipts do not carry return data, so
+// this is the only way to get at it. Replaying on the parent ignores the
+// txs before it in the same block, so the reason is best effort and "" when
+// the replay does not revert or the data cannot be decoded. Only contracts
+// with an ABI are replayed, to keep the extra calls to what can be decoded
+// in full.
+func (p *poller) revertReason(ctx contextpkg.Context, blk *typespkg.Block, tx *typespkg.Transaction, from string) string {
+	contractABI := p.methods.abiFor(stringspkg.ToLower(tx.To().Hex()))
+	if contractABI == nil || blk.NumberU64() == 0 {
+		return ""
+	}
+	msg := ethereum.CallMsg{
+		From:  common.HexToAddress(from),
+		To:    tx.To(),
+		Gas:   tx.Gas(),
+		Value: tx.Value(),
+		Data:  tx.Data(),
+	}
+	parent := new(mathbig.Int).SetUint64(blk.NumberU64() - 1)
+	_, err := p.client.CallContract(ctx, msg, parent)
+	if err == nil {
+		return ""
+	}
+	data := revertData(err)
+	if data == nil {
+		logFor("poller").Debug("replay reverted tx", "tx", tx.Hash().Hex(), "err", err)
+		return ""
+	}
+	return decodeRevert(contractABI, data)
+}
+
+// revertData pulls the revert return data out of an eth_call error, which
+// nodes send hex encoded in the JSON-RPC error's data field.
+func revertData(err error) []byte {
+	var dataErr rpc.DataError
+	if !errorspkg.As(err, &dataErr) {
+		return nil
+	}
+	s, ok := dataErr.ErrorData().(string)
+	if !ok {
+		return nil
+	}
+	data, err := hexutil.Decode(s)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// decodeRevert decodes Error(string) and Panic(uint256) reverts, then the
+// contract's own custom errors, rendered as Name[args].
+func decodeRevert(contractABI *abi.ABI, data []byte) string {
+	if reason, err := abi.UnpackRevert(data); err == nil {
+		return reason
+	}
+	if contractABI == nil || len(data) < 4 {
+		return ""
+	}
+	e, err := contractABI.ErrorByID([4]byte(data[:4]))
+	if err != nil {
+		return ""
+	}
+	args, err := e.Unpack(data)
+	if err != nil {
+		return e.Sig
+	}
+	return fmtpkg.Sprintf("%s%v", e.Name, args)
+}
@@ -0,0 +1,510 @@
+package main
+
+import (
+	contextpkg "context"
+	errorspkg "errors"
+	fmtpkg "fmt"
+	mathbig "math/big"
+	nethttppkg "net/http"
+	urlpkg "net/url"
+	stringspkg "strings"
+	syncpkg "sync"
+	timepkg "time"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	typespkg "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/example/gas-monitor-poller/internal/rpcstats"
+	"golang.org/x/time/rate"
+)
+
+// chainClient is the subset of ethclient the poller uses.
+type chainClient interface {
+	BlockByNumber(ctx contextpkg.Context, number *mathbig.Int) (*typespkg.Block, error)
+	HeaderByNumber(ctx contextpkg.Context, number *mathbig.Int) (*typespkg.Header, error)
+	TransactionReceipt(ctx contextpkg.Context, hash common.Hash) (*typespkg.Receipt, error)
+	BlockReceipts(ctx contextpkg.Context, hash common.Hash) ([]*typespkg.Receipt, error)
+	// BlocksByNumber and TransactionReceipts fetch in one JSON-RPC batch,
+	// with an error per element
+	BlocksByNumber(ctx contextpkg.Context, numbers []uint64) ([]*typespkg.Block, []error)
+	TransactionReceipts(ctx contextpkg.Context, hashes []common.Hash) ([]*typespkg.Receipt, []error)
+	// ChainID is eth_chainId, the ID every chain check compares
+	ChainID(ctx contextpkg.Context) (*mathbig.Int, error)
+	CallContract(ctx contextpkg.Context, msg ethereum.CallMsg, block *mathbig.Int) ([]byte, error)
+	// TraceBlock runs debug_traceBlockByNumber with the callTracer
+	TraceBlock(ctx contextpkg.Context, number uint64) ([]callFrame, error)
+	Close()
+}
+
+// parseRPCURLs splits a comma-separated endpoint list, dropping blanks.
+func parseRPCURLs(raw string) []string {
+	var out []string
+	for _, u := range stringspkg.Split(raw, ",") {
+		if u = stringspkg.TrimSpace(u); u != "" {
+			out = append(out, u)
+		}
+	}
+	return out
+}
+
+type rpcEndpoint struct {
+	// name is the URL host, safe to log without leaking API keys in paths
+	name   string
+	client *ethclient.Client
+	// limits holds the last Retry-After of an HTTP endpoint; nil for ws
+	limits *retryAfterTransport
+
+	failures int
+	// regressions counts latest heads in a row below the highest seen
+	regressions int
+	healthy     bool
+	latency     timepkg.Duration
+	head        uint64
+
+	// verified is set once the endpoint's chain ID has been checked since
+	// it last became active; wrongChain records a mismatch
+	verified   bool
+	wrongChain bool
+}
+
+// failoverClient sends every call to one active endpoint and rotates to the
+// next after maxFailures consecutive errors. A background health check can
+// also move it to the healthiest endpoint.
+type failoverClient struct {
+	mu          syncpkg.Mutex
+	endpoints   []*rpcEndpoint
+	active      int
+	maxFailures int
+	// chainID, once pinned, is the only chain an endpoint may serve
+	chainID *mathbig.Int
+	// switches counts changes of the active endpoint
+	switches uint64
+	// highestHead is the highest latest block any endpoint returned; an
+	// endpoint returning lower ones maxRegressions times in a row is
+	// failed over (0 never)
+	highestHead    uint64
+	maxRegressions int
+
+	// rateLimitPause is how long calls stop after a rate-limit error that
+	// carries no hint; pausedUntil is when the current pause ends
+	rateLimitPause timepkg.Duration
+	pausedUntil    timepkg.Time
+
+	// limiter caps outbound calls per second; nil means unlimited
+	limiter *rate.Limiter
+	// breaker stops calls while the RPC keeps failing; nil never trips
+	breaker  *circuitBreaker
+	timeouts rpcTimeouts
+	stats    rpcstats.Recorder
+}
+
+// errWrongChain is returned instead of calling an endpoint that serves a
+// different chain than the pinned one, so its data never reaches Kafka.
+var errWrongChain = errorspkg.New("rpc endpoint serves the wrong chain")
+
+func dialFailover(ctx contextpkg.Context, urls []string, maxFailures int) (*failoverClient, error) {
+	if len(urls) == 0 {
+		return nil, errorspkg.New("no RPC endpoints configured")
+	}
+	f := &failoverClient{maxFailures: maxFailures, stats: rpcstats.Nop{}}
+	for _, raw := range urls {
+		ep, err := dialEndpoint(ctx, raw)
+		if err != nil {
+			f.Close()
+			return nil, fmtpkg.Errorf("dial %s: %w", endpointName(raw), err)
+		}
+		f.endpoints = append(f.endpoints, ep)
+	}
+	return f, nil
+}
+
+// dialEndpoint connects to raw. HTTP endpoints get a transport that keeps
+// the Retry-After of rate-limited responses.
+func dialEndpoint(ctx contextpkg.Context, raw string) (*rpcEndpoint, error) {
+	ep := &rpcEndpoint{name: endpointName(raw), healthy: true}
+	var opts []rpc.ClientOption
+	if stringspkg.HasPrefix(raw, "http://") || stringspkg.HasPrefix(raw, "https://") {
+		ep.limits = &retryAfterTransport{base: nethttppkg.DefaultTransport}
+		opts = append(opts, rpc.WithHTTPClient(&nethttppkg.Client{Transport: ep.limits}))
+	}
+	c, err := rpc.DialOptions(ctx, raw, opts...)
+	if err != nil {
+		return nil, err
+	}
+	ep.client = ethclient.NewClient(c)
+	return ep, nil
+}
+
+func endpointName(raw string) string {
+	if u, err := urlpkg.Parse(raw); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return "endpoint"
+}
+
+func (f *failoverClient) current() *rpcEndpoint {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.endpoints[f.active]
+}
+
+// report records the outcome of a call made against ep. NotFound and an
+// unsupported method are answers, not endpoint failures, so they never count
+// towards rotation; neither does a rate limit, which pauses calls instead.
+func (f *failoverClient) report(ep *rpcEndpoint, err error) {
+	f.breaker.record(err)
+	if isRateLimited(err) {
+		rpcErrors.Inc()
+		f.throttle(ep, err)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err == nil || errorspkg.Is(err, ethereum.NotFound) || isMethodNotFound(err) {
+		ep.failures = 0
+		return
+	}
+	rpcErrors.Inc()
+	ep.failures++
+	if errorspkg.Is(err, errWrongChain) {
+		// no point retrying an endpoint on another chain
+		ep.failures = f.maxFailures
+	}
+	if ep.failures < f.maxFailures || len(f.endpoints) == 1 || f.endpoints[f.active] != ep {
+		return
+	}
+	next := f.nextUsable()
+	if next == f.active {
+		return
+	}
+	logFor("rpc").Warn("endpoint failing, failing over", "endpoint", ep.name, "failures", ep.failures, "next", f.endpoints[next].name, "err", err)
+	ep.failures = 0
+	ep.healthy = false
+	f.activate(next)
+}
+
+// nextUsable returns the first endpoint after the active one not known to
+// be on the wrong chain, or the active one if there is none. f.mu must be
+// held.
+func (f *failoverClient) nextUsable() int {
+	for i := 1; i < len(f.endpoints); i++ {
+		n := (f.active + i) % len(f.endpoints)
+		if !f.endpoints[n].wrongChain {
+			return n
+		}
+	}
+	return f.active
+}
+
+// activate switches to endpoint i, which must have its chain checked again
+// before it is used. f.mu must be held.
+func (f *failoverClient) activate(i int) {
+	if i != f.active {
+		f.switches++
+	}
+	f.active = i
+	f.endpoints[i].verified = false
+}
+
+// generation changes every time the active endpoint does.
+func (f *failoverClient) generation() uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.switches
+}
+
+// pinChain records the chain every endpoint must serve and checks each one,
+// moving off the active endpoint if it is on another chain. It fails only
+// if no endpoint serves want.
+func (f *failoverClient) pinChain(ctx contextpkg.Context, want *mathbig.Int) error {
+	f.mu.Lock()
+	f.chainID = want
+	f.mu.Unlock()
+	usable := 0
+	for _, ep := range f.endpoints {
+		if err := f.checkChain(ctx, ep); err == nil {
+			usable++
+		} else if !errorspkg.Is(err, errWrongChain) {
+			// unreachable now; it is checked again before it is used
+			logFor("rpc").Warn("chain id", "endpoint", ep.name, "err", err)
+			usable++
+		}
+	}
+	if usable == 0 {
+		return fmtpkg.Errorf("no RPC endpoint serves chain %s", want)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.endpoints[f.active].wrongChain {
+		f.activate(f.nextUsable())
+	}
+	return nil
+}
+
+// checkChain asks ep for its chain ID, waiting for the rate limit, and
+// records whether it matches the pinned one.
+func (f *failoverClient) checkChain(ctx contextpkg.Context, ep *rpcEndpoint) error {
+	if err := f.acquire(ctx, 1); err != nil {
+		return err
+	}
+	return f.verifyChain(ctx, ep)
+}
+
+// verifyChain is checkChain for a caller that has already charged the rate
+// limit for the call.
+func (f *failoverClient) verifyChain(ctx contextpkg.Context, ep *rpcEndpoint) error {
+	ctx, cancel := contextpkg.WithTimeout(ctx, 5*timepkg.Second)
+	defer cancel()
+	id, err := ep.client.ChainID(ctx)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ep.verified = true
+	ep.wrongChain = id.Cmp(f.chainID) != 0
+	if ep.wrongChain {
+		logFor("rpc").Error("endpoint serves the wrong chain, not using it", "endpoint", ep.name, "chainId", id.String(), "expected", f.chainID.String())
+		return errWrongChain
+	}
+	return nil
+}
+
+// ready waits for cost calls' worth of rate limit and returns the active
+// endpoint once its chain has been verified. The caller must report the
+// outcome of its call.
+func (f *failoverClient) ready(ctx contextpkg.Context, cost int) (*rpcEndpoint, error) {
+	// the chain ID call an unverified endpoint needs first is charged
+	// with the caller's
+	if f.needsCheck(f.current()) {
+		cost++
+	}
+	if err := f.acquire(ctx, cost); err != nil {
+		return nil, err
+	}
+	if err := f.breaker.allow(); err != nil {
+		return nil, err
+	}
+	ep := f.current()
+	if f.needsCheck(ep) {
+		if err := f.verifyChain(ctx, ep); err != nil {
+			f.report(ep, err)
+			return nil, err
+		}
+	}
+	return ep, nil
+}
+
+// needsCheck reports whether ep's chain must be verified before it is used.
+func (f *failoverClient) needsCheck(ep *rpcEndpoint) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.chainID != nil && (!ep.verified || ep.wrongChain)
+}
+
+// Call kinds, each with its own timeout.
+const (
+	callHead    = "head"
+	callBlock   = "block"
+	callReceipt = "receipt"
+)
+
+// rpcTimeouts bounds how long one call of each kind may take, so a hung
+// connection fails the call instead of stalling the poller. 0 means no
+// limit.
+type rpcTimeouts struct {
+	head    timepkg.Duration
+	block   timepkg.Duration
+	receipt timepkg.Duration
+}
+
+func (t rpcTimeouts) of(kind string) timepkg.Duration {
+	switch kind {
+	case callBlock:
+		return t.block
+	case callReceipt:
+		return t.receipt
+	}
+	return t.head
+}
+
+// withTimeout runs call, a request for method, under kind's timeout and
+// records it with f.stats. A call that times out is logged and, like any
+// other failure, counts towards failover and the circuit breaker.
+func (f *failoverClient) withTimeout(ctx contextpkg.Context, ep *rpcEndpoint, method, kind string, call func(ctx contextpkg.Context) error) error {
+	if d := f.timeouts.of(kind); d > 0 {
+		var cancel contextpkg.CancelFunc
+		ctx, cancel = contextpkg.WithTimeout(ctx, d)
+		defer cancel()
+	}
+	start := timepkg.Now()
+	err := call(ctx)
+	f.stats.Observe(method, timepkg.Since(start), err)
+	if errorspkg.Is(err, contextpkg.DeadlineExceeded) && ctx.Err() != nil {
+		rpcTimeoutsTotal.WithLabelValues(kind).Inc()
+		logFor("rpc").Warn("rpc call timed out", "call", kind, "method", method, "endpoint", ep.name, "duration", timepkg.Since(start).String())
+	}
+	return err
+}
+
+func callActive[T any](ctx contextpkg.Context, f *failoverClient, method, kind string, fn func(ctx contextpkg.Context, c *ethclient.Client) (T, error)) (T, error) {
+	var v T
+	ep, err := f.ready(ctx, 1)
+	if err != nil {
+		return v, err
+	}
+	err = f.withTimeout(ctx, ep, method, kind, func(ctx contextpkg.Context) error {
+		var err error
+		v, err = fn(ctx, ep.client)
+		return err
+	})
+	f.report(ep, err)
+	return v, err
+}
+
+func (f *failoverClient) BlockByNumber(ctx contextpkg.Context, number *mathbig.Int) (*typespkg.Block, error) {
+	return callActive(ctx, f, "eth_getBlockByNumber", callBlock, func(ctx contextpkg.Context, c *ethclient.Client) (*typespkg.Block, error) {
+		return c.BlockByNumber(ctx, number)
+	})
+}
+
+func (f *failoverClient) HeaderByNumber(ctx contextpkg.Context, number *mathbig.Int) (*typespkg.Header, error) {
+	return callActive(ctx, f, "eth_getBlockByNumber:header", callHead, func(ctx contextpkg.Context, c *ethclient.Client) (*typespkg.Header, error) {
+		hdr, err := c.HeaderByNumber(ctx, number)
+		if err == nil && number == nil {
+			f.observeHead(c, hdr.Number.Uint64())
+		}
+		return hdr, err
+	})
+}
+
+// observeHead checks that the latest block never goes backwards. An
+// endpoint returning a lower one than seen before is lagging, typically a
+// load balancer handing the call to a node that is behind, and after
+// maxRegressions in a row it is failed over like one returning errors.
+func (f *failoverClient) observeHead(c *ethclient.Client, head uint64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	i := 0
+	for i < len(f.endpoints) && f.endpoints[i].client != c {
+		i++
+	}
+	if i == len(f.endpoints) {
+		return
+	}
+	ep := f.endpoints[i]
+	if head >= f.highestHead {
+		f.highestHead = head
+		ep.regressions = 0
+		return
+	}
+	ep.regressions++
+	rpcHeadRegressions.WithLabelValues(ep.name).Inc()
+	logFor("rpc").Warn("endpoint head went backwards", "endpoint", ep.name, "head", head, "highest", f.highestHead, "regressions", ep.regressions)
+	if f.maxRegressions == 0 || ep.regressions < f.maxRegressions || len(f.endpoints) == 1 || i != f.active {
+		return
+	}
+	next := f.nextUsable()
+	if next == f.active {
+		return
+	}
+	logFor("rpc").Warn("endpoint keeps returning stale heads, failing over", "endpoint", ep.name, "regressions", ep.regressions, "next", f.endpoints[next].name)
+	ep.regressions = 0
+	f.activate(next)
+}
+
+func (f *failoverClient) TransactionReceipt(ctx contextpkg.Context, hash common.Hash) (*typespkg.Receipt, error) {
+	return callActive(ctx, f, "eth_getTransactionReceipt", callReceipt, func(ctx contextpkg.Context, c *ethclient.Client) (*typespkg.Receipt, error) {
+		return c.TransactionReceipt(ctx, hash)
+	})
+}
+
+func (f *failoverClient) BlockReceipts(ctx contextpkg.Context, hash common.Hash) ([]*typespkg.Receipt, error) {
+	return callActive(ctx, f, "eth_getBlockReceipts", callReceipt, func(ctx contextpkg.Context, c *ethclient.Client) ([]*typespkg.Receipt, error) {
+		return c.BlockReceipts(ctx, rpc.BlockNumberOrHashWithHash(hash, false))
+	})
+}
+
+func (f *failoverClient) ChainID(ctx contextpkg.Context) (*mathbig.Int, error) {
+	return callActive(ctx, f, "eth_chainId", callHead, func(ctx contextpkg.Context, c *ethclient.Client) (*mathbig.Int, error) {
+		return c.ChainID(ctx)
+	})
+}
+
+func (f *failoverClient) CallContract(ctx contextpkg.Context, msg ethereum.CallMsg, block *mathbig.Int) ([]byte, error) {
+	return callActive(ctx, f, "eth_call", callHead, func(ctx contextpkg.Context, c *ethclient.Client) ([]byte, error) {
+		return c.CallContract(ctx, msg, block)
+	})
+}
+
+func (f *failoverClient) Close() {
+	for _, ep := range f.endpoints {
+		ep.client.Close()
+	}
+}
+
+// healthLoop pings every endpoint each interval, re-checks its chain ID in
+// case a load balancer swapped the node behind it, and moves the active
+// endpoint to the healthiest one: reachable, on the right chain, closest to
+// the highest head seen, then lowest latency.
+func (f *failoverClient) healthLoop(ctx contextpkg.Context, interval timepkg.Duration) {
+	for sleepCtx(ctx, interval) {
+		for _, ep := range f.endpoints {
+			if err := f.acquire(ctx, 1); err != nil {
+				return
+			}
+			pingCtx, cancel := contextpkg.WithTimeout(ctx, 5*timepkg.Second)
+			start := timepkg.Now()
+			head, err := ep.client.BlockNumber(pingCtx)
+			cancel()
+			f.mu.Lock()
+			pinned := f.chainID != nil
+			f.mu.Unlock()
+			if err == nil && pinned {
+				err = f.checkChain(ctx, ep)
+			}
+			f.mu.Lock()
+			ep.healthy = err == nil
+			if err == nil {
+				ep.latency = timepkg.Since(start)
+				ep.head = head
+			}
+			f.mu.Unlock()
+		}
+		f.preferHealthiest()
+	}
+}
+
+// headSlack is how many blocks an endpoint may trail the best one before it
+// is considered worse regardless of latency.
+const headSlack = 2
+
+func (f *failoverClient) preferHealthiest() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	best := -1
+	for i, ep := range f.endpoints {
+		if !ep.healthy {
+			continue
+		}
+		if best < 0 {
+			best = i
+			continue
+		}
+		b := f.endpoints[best]
+		if ep.head > b.head+headSlack || (ep.head+headSlack >= b.head && ep.latency < b.latency) {
+			best = i
+		}
+	}
+	if best < 0 || best == f.active {
+		return
+	}
+	cur, next := f.endpoints[f.active], f.endpoints[best]
+	if cur.healthy && cur.head+headSlack >= next.head {
+		return
+	}
+	logFor("rpc").Info("health check switching endpoint", "endpoint", next.name, "head", next.head, "latency", next.latency.String(), "previous", cur.name, "previousHealthy", cur.healthy, "previousHead", cur.head)
+	f.activate(best)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	contextpkg "context"
+	errorspkg "errors"
+	mathbig "math/big"
+	nethttppkg "net/http"
+	httptestpkg "net/http/httptest"
+	testingpkg "testing"
+	timepkg "time"
+
+	"golang.org/x/time/rate"
+)
+
+// TestReadyChainCheck runs a call on an endpoint whose chain is not yet
+// verified: the chain ID check is charged to the rate limit with the call,
+// and gives up with the caller's context rather than after its own timeout.
+func TestReadyChainCheck(t *testingpkg.T) {
+	t.Run("charged with the call", func(t *testingpkg.T) {
+		chain := newFakeChain(1, testContract, 1)
+		srv := &rpcServer{chain: chain, calls: make(map[string]int)}
+		ts := httptestpkg.NewServer(srv)
+		defer ts.Close()
+		f, err := dialFailover(contextpkg.Background(), []string{ts.URL}, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		f.chainID = mathbig.NewInt(1)
+		f.limiter = rate.NewLimiter(rate.Every(timepkg.Hour), 3)
+
+		for i, wantTokens := range []float64{1, 0} {
+			if _, err := f.HeaderByNumber(contextpkg.Background(), nil); err != nil {
+				t.Fatal(err)
+			}
+			if got := f.limiter.Tokens(); got > wantTokens+0.01 || got < wantTokens-0.01 {
+				t.Errorf("call %d: %.2f tokens left, want %v", i+1, got, wantTokens)
+			}
+		}
+		if got := srv.count("eth_chainId"); got != 1 {
+			t.Errorf("eth_chainId called %d times, want once", got)
+		}
+	})
+
+	t.Run("cancelled with the caller", func(t *testingpkg.T) {
+		release := make(chan struct{})
+		hung := httptestpkg.NewServer(nethttppkg.HandlerFunc(func(w nethttppkg.ResponseWriter, r *nethttppkg.Request) {
+			select {
+			case <-r.Context().Done():
+			case <-release:
+			}
+		}))
+		defer hung.Close()
+		defer close(release)
+		f, err := dialFailover(contextpkg.Background(), []string{hung.URL}, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		f.chainID = mathbig.NewInt(1)
+
+		ctx, cancel := contextpkg.WithTimeout(contextpkg.Background(), 50*timepkg.Millisecond)
+		defer cancel()
+		started := timepkg.Now()
+		_, err = f.HeaderByNumber(ctx, nil)
+		if !errorspkg.Is(err, contextpkg.DeadlineExceeded) {
+			t.Errorf("err = %v, want the caller's deadline", err)
+		}
+		if elapsed := timepkg.Since(started); elapsed > timepkg.Second {
+			t.Errorf("call returned %s after a 50ms deadline", elapsed)
+		}
+	})
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	contextpkg "context"
+	errorspkg "errors"
+	fmtpkg "fmt"
+	iopkg "io"
+	ospkg "os"
+	syncpkg "sync"
+	timepkg "time"
+
+	"github.com/IBM/sarama"
+)
+
+// EventSink is where published messages go: KafkaSink, or in place of
+// Kafka (SINK) a WebhookSink, or a writerSink so the poller can run without
+// a broker while developing.
+type EventSink interface {
+	Emit(ctx contextpkg.Context, key string, value []byte) error
+	Close() error
+}
+
+// sinkMessageKey carries the Kafka specifics of the message being emitted,
+// which sinks other than KafkaSink ignore.
+type sinkMessageKey struct{}
+
+type sinkMessage struct {
+	topic   string
+	headers []sarama.RecordHeader
+}
+
+func withSinkMessage(ctx contextpkg.Context, topic string, headers []sarama.RecordHeader) contextpkg.Context {
+	return contextpkg.WithValue(ctx, sinkMessageKey{}, sinkMessage{topic: topic, headers: headers})
+}
+
+// KafkaSink sends messages with a sync producer, to topic unless the
+// context names another.
+type KafkaSink struct {
+	producer sarama.SyncProducer
+	topic    string
+}
+
+func (s *KafkaSink) Emit(ctx contextpkg.Context, key string, value []byte) error {
+	msg := &sarama.ProducerMessage{Topic: s.topic, Value: sarama.ByteEncoder(value)}
+	if m, ok := ctx.Value(sinkMessageKey{}).(sinkMessage); ok {
+		msg.Topic, msg.Headers = m.topic, m.headers
+	}
+	if key != "" {
+		msg.Key = sarama.StringEncoder(key)
+	}
+	partition, offset, err := s.producer.SendMessage(msg)
+	if err != nil {
+		return err
+	}
+	logFor("kafka").Debug("kafka sent", "topic", msg.Topic, "partition", partition, "offset", offset)
+	return nil
+}
+
+func (s *KafkaSink) Close() error {
+	return s.producer.Close()
+}
+
+// sinkFromEnv returns the sink for SINK, or nil for kafka, the default.
+func sinkFromEnv(kind string) (EventSink, error) {
+	switch kind {
+	case "kafka":
+		return nil, nil
+	case "stdout":
+		// logs go to stderr, so stdout carries nothing but events
+		return &writerSink{w: ospkg.Stdout}, nil
+	case "file":
+		path := getenv("SINK_FILE", "")
+		if path == "" {
+			return nil, errorspkg.New("SINK=file requires SINK_FILE")
+		}
+		f, err := ospkg.OpenFile(path, ospkg.O_APPEND|ospkg.O_CREATE|ospkg.O_WRONLY, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		return &writerSink{w: f, closer: f}, nil
+	case "webhook":
+		url := getenv("WEBHOOK_URL", "")
+		if url == "" {
+			return nil, errorspkg.New("SINK=webhook requires WEBHOOK_URL")
+		}
+		retry := backoff{
+			attempts: int(getenvUint("WEBHOOK_ATTEMPTS", 5)),
+			base:     500 * timepkg.Millisecond,
+			max:      30 * timepkg.Second,
+		}
+		return newWebhookSink(url, getenv("WEBHOOK_SECRET", ""), getenvDuration("WEBHOOK_TIMEOUT", 10*timepkg.Second), retry,
+			int(getenvUint("WEBHOOK_BATCH_SIZE", 1)), getenvDuration("WEBHOOK_BATCH_INTERVAL", timepkg.Second)), nil
+	default:
+		return nil, fmtpkg.Errorf("SINK: unknown sink %q (kafka, stdout, file or webhook)", kind)
+	}
+}
+
+// writerSink writes each message's value on a line of its own.
+type writerSink struct {
+	mu     syncpkg.Mutex
+	w      iopkg.Writer
+	closer iopkg.Closer
+}
+
+func (s *writerSink) Emit(_ contextpkg.Context, _ string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.w.Write(append(value[:len(value):len(value)], '\n'))
+	return err
+}
+
+func (s *writerSink) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer.Close()
+}
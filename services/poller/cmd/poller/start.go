@@ -0,0 +1,118 @@
+package main
+
+import (
+	contextpkg "context"
+	errorspkg "errors"
+	fmtpkg "fmt"
+	mathbig "math/big"
+	ospkg "os"
+	strconvpkg "strconv"
+	stringspkg "strings"
+	timepkg "time"
+)
+
+// startPoint says where a poller begins when it is not resuming from a
+// checkpoint.
+type startPoint struct {
+	// atHead ignores any checkpoint and starts at the confirmed tip
+	atHead bool
+	// block, when set, is the first block a poller without a checkpoint
+	// processes
+	block *uint64
+	// from names the setting block came from, for logs
+	from string
+}
+
+// parseStartPoint reads START_AT_HEAD, START_BLOCK, START_OFFSET, START_AT
+// and BACKFILL_FROM, at most one of which may be set. tip is the confirmed
+// tip that START_OFFSET counts back from and START_AT searches below.
+// BACKFILL_FROM takes either a block number or an RFC3339 time.
+func parseStartPoint(ctx contextpkg.Context, client chainClient, tip uint64) (startPoint, error) {
+	var set []string
+	for _, key := range []string{"START_AT_HEAD", "START_BLOCK", "START_OFFSET", "START_AT", "BACKFILL_FROM"} {
+		if ospkg.Getenv(key) != "" {
+			set = append(set, key)
+		}
+	}
+	if len(set) > 1 {
+		return startPoint{}, fmtpkg.Errorf("%s are mutually exclusive", stringspkg.Join(set, ", "))
+	}
+	if len(set) == 0 {
+		return startPoint{}, nil
+	}
+	sp := startPoint{from: set[0]}
+	switch set[0] {
+	case "START_AT_HEAD":
+		sp.atHead = getenvBool("START_AT_HEAD", false)
+		return sp, nil
+	case "START_BLOCK":
+		n := getenvUint("START_BLOCK", 0)
+		sp.block = &n
+	case "START_OFFSET":
+		off := getenvUint("START_OFFSET", 0)
+		if off > tip {
+			return startPoint{}, fmtpkg.Errorf("START_OFFSET %d is beyond the chain tip %d", off, tip)
+		}
+		n := tip - off
+		sp.block = &n
+	case "START_AT":
+		n, err := startAtTime(ctx, client, "START_AT", tip)
+		if err != nil {
+			return startPoint{}, err
+		}
+		sp.block = &n
+	case "BACKFILL_FROM":
+		if _, err := strconvpkg.ParseUint(ospkg.Getenv("BACKFILL_FROM"), 10, 64); err == nil {
+			n := getenvUint("BACKFILL_FROM", 0)
+			sp.block = &n
+			break
+		}
+		n, err := startAtTime(ctx, client, "BACKFILL_FROM", tip)
+		if err != nil {
+			return startPoint{}, err
+		}
+		sp.block = &n
+	}
+	return sp, nil
+}
+
+// startAtTime resolves the RFC3339 time in env var key to a block.
+func startAtTime(ctx contextpkg.Context, client chainClient, key string, tip uint64) (uint64, error) {
+	at, err := timepkg.Parse(timepkg.RFC3339, ospkg.Getenv(key))
+	if err != nil {
+		return 0, fmtpkg.Errorf("%s: %w", key, err)
+	}
+	n, err := blockAtTime(ctx, client, at, tip)
+	if err != nil {
+		return 0, fmtpkg.Errorf("%s: %w", key, err)
+	}
+	logFor("start").Info("start time resolved", "key", key, "at", at.Format(timepkg.RFC3339), "block", n)
+	return n, nil
+}
+
+// blockAtTime binary searches [0, tip] for the first block whose timestamp
+// is at or after t.
+func blockAtTime(ctx contextpkg.Context, client chainClient, t timepkg.Time, tip uint64) (uint64, error) {
+	target := uint64(t.Unix())
+	lo, hi := uint64(0), tip
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		hdr, err := client.HeaderByNumber(ctx, new(mathbig.Int).SetUint64(mid))
+		if err != nil {
+			return 0, err
+		}
+		if hdr.Time < target {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	hdr, err := client.HeaderByNumber(ctx, new(mathbig.Int).SetUint64(lo))
+	if err != nil {
+		return 0, err
+	}
+	if hdr.Time < target {
+		return 0, errorspkg.New("timestamp is after the confirmed tip")
+	}
+	return lo, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	encodingjson "encoding/json"
+	mathbig "math/big"
+	sortpkg "sort"
+
+	"github.com/IBM/sarama"
+	typespkg "github.com/ethereum/go-ethereum/core/types"
+)
+
+// contractSummary accumulates one contract's matched txs in a block.
+type contractSummary struct {
+	txs int
+	// receiptsMissing counts txs left out of the gas and cost totals
+	// because their receipt could not be fetched
+	receiptsMissing int
+	gasUsed         uint64
+	// gasCostWei is the execution cost, gasUsed times effective gas price,
+	// and blobCostWei the EIP-4844 blob fees
+	gasCostWei  *mathbig.Int
+	blobCostWei *mathbig.Int
+}
+
+// blockSummaries rolls matched txs up per contract while a block is
+// processed. A nil blockSummaries ignores everything, for when summaries
+// are off.
+type blockSummaries map[string]*contractSummary
+
+func (s blockSummaries) add(contract string, tx *typespkg.Transaction, rec *typespkg.Receipt) {
+	if s == nil {
+		return
+	}
+	c := s[contract]
+	if c == nil {
+		c = &contractSummary{gasCostWei: new(mathbig.Int), blobCostWei: new(mathbig.Int)}
+		s[contract] = c
+	}
+	c.txs++
+	if rec == nil {
+		c.receiptsMissing++
+		return
+	}
+	price := rec.EffectiveGasPrice
+	if price == nil {
+		price = tx.GasPrice()
+	}
+	c.gasUsed += rec.GasUsed
+	c.gasCostWei.Add(c.gasCostWei, new(mathbig.Int).Mul(price, new(mathbig.Int).SetUint64(rec.GasUsed)))
+	if rec.BlobGasUsed > 0 && rec.BlobGasPrice != nil {
+		c.blobCostWei.Add(c.blobCostWei, new(mathbig.Int).Mul(rec.BlobGasPrice, new(mathbig.Int).SetUint64(rec.BlobGasUsed)))
+	}
+}
+
+// summaryMessages builds one blockSummary message per contract, in contract
+// order. costWei and costEth follow COST_INCLUDES_BLOBS like the per-tx
+// events, and
+// avgEffectiveGasPriceGwei is weighted by gas used.
+func (p *poller) summaryMessages(blk *typespkg.Block, s blockSummaries, opts blockOpts) []*sarama.ProducerMessage {
+	contracts := make([]string, 0, len(s))
+	for c := range s {
+		contracts = append(contracts, c)
+	}
+	sortpkg.Strings(contracts)
+	msgs := make([]*sarama.ProducerMessage, 0, len(contracts))
+	for _, contract := range contracts {
+		c := s[contract]
+		costWei := new(mathbig.Int).Set(c.gasCostWei)
+		if p.includeBlobCost {
+			costWei.Add(costWei, c.blobCostWei)
+		}
+		costEth, _ := new(mathbig.Float).Quo(new(mathbig.Float).SetInt(costWei), mathbig.NewFloat(1e18)).Float64()
+		var avgGwei float64
+		if c.gasUsed > 0 {
+			avgWei := new(mathbig.Float).Quo(new(mathbig.Float).SetInt(c.gasCostWei), new(mathbig.Float).SetUint64(c.gasUsed))
+			avgGwei, _ = avgWei.Quo(avgWei, mathbig.NewFloat(1e9)).Float64()
+		}
+		payload := map[string]any{
+			"type":                     "blockSummary",
+			"tenantId":                 p.tenant,
+			"chainId":                  p.chainID.Uint64(),
+			"contract":                 contract,
+			"eventId":                  eventID(p.tenant, "summary:"+contract, blk.Hash().Hex()),
+			"blockNumber":              blk.NumberU64(),
+			"blockHash":                blk.Hash().Hex(),
+			"timestamp":                blk.Time(),
+			"source":                   opts.source,
+			"txCount":                  c.txs,
+			"gasUsed":                  c.gasUsed,
+			"costWei":                  costWei.String(),
+			"avgEffectiveGasPriceGwei": avgGwei,
+		}
+		if !p.preciseFees {
+			payload["costEth"] = costEth
+		}
+		if c.receiptsMissing > 0 {
+			payload["receiptsMissing"] = c.receiptsMissing
+		}
+		if opts.reorged {
+			payload["reorged"] = true
+		}
+		value, _ := encodingjson.Marshal(payload)
+		msgs = append(msgs, &sarama.ProducerMessage{
+			Topic:   p.summaryTopic,
+			Key:     sarama.StringEncoder(p.messageKey(contract, blk.Hash().Hex())),
+			Value:   sarama.ByteEncoder(value),
+			Headers: p.headers(contract, opts.source),
+		})
+	}
+	return msgs
+}
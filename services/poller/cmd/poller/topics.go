@@ -0,0 +1,70 @@
+package main
+
+import (
+	errorspkg "errors"
+	fmtpkg "fmt"
+	stringspkg "strings"
+
+	"github.com/IBM/sarama"
+)
+
+// kafkaTopic is a topic the poller needs. Compacted topics keep only the
+// latest record per key, as the checkpoint topic should.
+type kafkaTopic struct {
+	name      string
+	compacted bool
+}
+
+// ensureTopics checks that every topic exists, creating the missing ones
+// with partitions and replication when create is set (KAFKA_CREATE_TOPICS)
+// and failing otherwise, so a typo in a topic name shows up at startup
+// rather than as sends that never arrive.
+func ensureTopics(brokers []string, security kafkaSecurity, topics []kafkaTopic, create bool, partitions int32, replication int16) error {
+	admin, err := sarama.NewClusterAdmin(brokers, security.config())
+	if err != nil {
+		return fmtpkg.Errorf("kafka admin: %w", err)
+	}
+	defer admin.Close()
+	existing, err := admin.ListTopics()
+	if err != nil {
+		return fmtpkg.Errorf("list kafka topics: %w", err)
+	}
+	var missing []kafkaTopic
+	seen := make(map[string]bool)
+	for _, t := range topics {
+		if t.name == "" || seen[t.name] {
+			continue
+		}
+		seen[t.name] = true
+		if _, ok := existing[t.name]; !ok {
+			missing = append(missing, t)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	if !create {
+		names := make([]string, len(missing))
+		for i, t := range missing {
+			names[i] = t.name
+		}
+		return fmtpkg.Errorf("kafka topics do not exist: %s (create them, or set KAFKA_CREATE_TOPICS=true)", stringspkg.Join(names, ", "))
+	}
+	for _, t := range missing {
+		detail := &sarama.TopicDetail{NumPartitions: partitions, ReplicationFactor: replication}
+		if t.compacted {
+			policy := "compact"
+			detail.ConfigEntries = map[string]*string{"cleanup.policy": &policy}
+		}
+		err := admin.CreateTopic(t.name, detail, false)
+		// another poller starting at the same time may have won the race
+		if errorspkg.Is(err, sarama.ErrTopicAlreadyExists) {
+			continue
+		}
+		if err != nil {
+			return fmtpkg.Errorf("create kafka topic %s: %w", t.name, err)
+		}
+		logFor("kafka").Info("created kafka topic", "topic", t.name, "partitions", partitions, "replication", replication)
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	contextpkg "context"
+	hexpkg "encoding/hex"
+	stringspkg "strings"
+	atomicpkg "sync/atomic"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	typespkg "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// matchInternal is the matchReason of events for a watched contract reached
+// through an internal call.
+const matchInternal = "internal"
+
+// callFrame is one call in a callTracer trace, with the calls it made.
+type callFrame struct {
+	Type    string         `json:"type"`
+	To      string         `json:"to"`
+	GasUsed hexutil.Uint64 `json:"gasUsed"`
+	Input   hexutil.Bytes  `json:"input"`
+	Error   string         `json:"error"`
+	Calls   []callFrame    `json:"calls"`
+}
+
+// TraceBlock returns the call tree of every tx in block number, in block
+// order. A tx the node could not trace gets an empty frame.
+func (f *failoverClient) TraceBlock(ctx contextpkg.Context, number uint64) ([]callFrame, error) {
+	return callActive(ctx, f, "debug_traceBlockByNumber", callBlock, func(ctx contextpkg.Context, c *ethclient.Client) ([]callFrame, error) {
+		var out []struct {
+			Result callFrame `json:"result"`
+		}
+		err := c.Client().CallContext(ctx, &out, "debug_traceBlockByNumber", hexutil.EncodeUint64(number), map[string]any{"tracer": "callTracer"})
+		if err != nil {
+			return nil, err
+		}
+		frames := make([]callFrame, len(out))
+		for i, o := range out {
+			frames[i] = o.Result
+		}
+		return frames, nil
+	})
+}
+
+// blockTracer fetches call traces when TRACE_ENABLED is set. Like
+// batchReceipts, a method-not-found answer turns it off until the client
+// fails over to another endpoint, and meanwhile only top-level calls are
+// matched.
+type blockTracer struct {
+	enabled     bool
+	unsupported atomicpkg.Bool
+	// endpoint is the endpoint generation the unsupported answer applies to
+	endpoint atomicpkg.Uint64
+}
+
+func (t *blockTracer) usable(client chainClient) bool {
+	if !t.enabled {
+		return false
+	}
+	if gen := endpointGeneration(client); t.endpoint.Swap(gen) != gen && t.unsupported.Swap(false) {
+		logFor("rpc").Info("rpc endpoint changed, trying debug_traceBlockByNumber again")
+	}
+	return !t.unsupported.Load()
+}
+
+// forBlock returns the block's call traces indexed like its txs, or nil
+// when tracing is off, unsupported or failed for this block. A failed
+// trace does not fail the block: its internal calls are missed, and logged.
+func (t *blockTracer) forBlock(ctx contextpkg.Context, client chainClient, blk *typespkg.Block) []callFrame {
+	if !t.usable(client) || len(blk.Transactions()) == 0 {
+		return nil
+	}
+	frames, err := client.TraceBlock(ctx, blk.NumberU64())
+	switch {
+	case isMethodNotFound(err):
+		if !t.unsupported.Swap(true) {
+			logFor("rpc").Warn("debug_traceBlockByNumber not supported, matching top-level calls only")
+		}
+		return nil
+	case err != nil:
+		tracesFailed.Inc()
+		logFor("rpc").Warn("block trace failed, internal calls not matched", "block", blk.NumberU64(), "err", err)
+		return nil
+	case len(frames) != len(blk.Transactions()):
+		tracesFailed.Inc()
+		logFor("rpc").Warn("block trace does not match block", "block", blk.NumberU64(), "traces", len(frames), "txs", len(blk.Transactions()))
+		return nil
+	}
+	return frames
+}
+
+// internalCall is a tx's first call into a watched contract below the top
+// level. gasUsed and calls cover every call the tx made into it.
+type internalCall struct {
+	contract string
+	callType string
+	depth    int
+	input    []byte
+	gasUsed  uint64
+	calls    int
+	failed   bool
+}
+
+// internalCalls walks root's call tree depth first and returns the calls
+// into contracts watched reports true for, one per contract in the order
+// they were first reached.
+func internalCalls(root callFrame, watched func(to string, input []byte) bool) []*internalCall {
+	var (
+		out    []*internalCall
+		byAddr = make(map[string]*internalCall)
+		walk   func(frames []callFrame, depth int)
+	)
+	walk = func(frames []callFrame, depth int) {
+		for _, f := range frames {
+			to := stringspkg.ToLower(f.To)
+			if to != "" && watched(to, f.Input) {
+				c := byAddr[to]
+				if c == nil {
+					c = &internalCall{contract: to, callType: stringspkg.ToLower(f.Type), depth: depth, input: f.Input}
+					byAddr[to] = c
+					out = append(out, c)
+				}
+				c.gasUsed += uint64(f.GasUsed)
+				c.calls++
+				c.failed = c.failed || f.Error != ""
+			}
+			walk(f.Calls, depth+1)
+		}
+	}
+	walk(root.Calls, 1)
+	return out
+}
+
+// internalCallPayload points a tx's gas event at the internal call: contract
+// and the method fields describe the call, while gasUsed and the fees stay
+// those of the whole tx.
+func (p *poller) internalCallPayload(ctx contextpkg.Context, ev *GasEvent, blk *typespkg.Block, tx *typespkg.Transaction, c *internalCall) {
+	methodSig, methodName := "", ""
+	if len(c.input) >= 4 {
+		methodSig = "0x" + hexpkg.EncodeToString(c.input[:4])
+		methodName = p.methods.resolve(ctx, c.contract, c.input[:4])
+	}
+	ev.Contract = c.contract
+	ev.EventID = eventID(p.tenant, tx.Hash().Hex()+"|"+c.contract, blk.Hash().Hex())
+	ev.MethodSignature = methodSig
+	ev.MethodName = methodName
+	ev.CallType = c.callType
+	ev.Depth = c.depth
+	ev.CallGasUsed = ptr(c.gasUsed)
+	ev.CallCount = c.calls
+	ev.CallReverted = c.failed
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	contextpkg "context"
+
+	"github.com/IBM/sarama"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the poller's spans. Until setupTracing installs a provider
+// it is the global no-op one.
+var tracer = otel.Tracer("github.com/example/gas-monitor-poller")
+
+// setupTracing exports spans over OTLP/HTTP when OTEL_EXPORTER_OTLP_ENDPOINT
+// or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT is set; the exporter reads the rest
+// of the standard OTEL_* variables itself. Otherwise tracing stays a no-op.
+// The returned function flushes pending spans.
+func setupTracing(ctx contextpkg.Context) (func(contextpkg.Context) error, error) {
+	if getenv("OTEL_EXPORTER_OTLP_ENDPOINT", "") == "" && getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "") == "" {
+		return func(contextpkg.Context) error { return nil }, nil
+	}
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the name
+	res, err := resource.Merge(
+		resource.NewSchemaless(semconv.ServiceName("gas-monitor-poller")),
+		resource.Default(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// endSpan records err, if any, on span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// headerCarrier lets the propagator write trace context into, and read it
+// from, Kafka record headers.
+type headerCarrier struct {
+	headers *[]sarama.RecordHeader
+}
+
+func (c headerCarrier) Get(key string) string {
+	for _, h := range *c.headers {
+		if string(h.Key) == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func (c headerCarrier) Set(key, value string) {
+	for i, h := range *c.headers {
+		if string(h.Key) == key {
+			(*c.headers)[i].Value = []byte(value)
+			return
+		}
+	}
+	*c.headers = append(*c.headers, sarama.RecordHeader{Key: []byte(key), Value: []byte(value)})
+}
+
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, len(*c.headers))
+	for i, h := range *c.headers {
+		keys[i] = string(h.Key)
+	}
+	return keys
+}
+
+// injectTrace adds ctx's trace context to msg's headers (traceparent, and
+// baggage when set), so consumers can continue the trace.
+func injectTrace(ctx contextpkg.Context, msg *sarama.ProducerMessage) {
+	otel.GetTextMapPropagator().Inject(ctx, headerCarrier{&msg.Headers})
+}
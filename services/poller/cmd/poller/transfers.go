@@ -0,0 +1,32 @@
+package main
+
+import (
+	mathbig "math/big"
+	stringspkg "strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	typespkg "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// transferTopic is keccak256("Transfer(address,address,uint256)").
+var transferTopic = crypto.Keccak256Hash([]byte("Transfer(address,address,uint256)"))
+
+// erc20Transfers decodes the ERC-20 Transfer events contract emitted in rec.
+// Logs that do not carry exactly the two indexed addresses plus a 32-byte
+// value are skipped; that also excludes ERC-721 transfers, which index the
+// token id as a third topic.
+func erc20Transfers(rec *typespkg.Receipt, contract common.Address) []Transfer {
+	var out []Transfer
+	for _, lg := range rec.Logs {
+		if lg.Address != contract || len(lg.Topics) != 3 || lg.Topics[0] != transferTopic || len(lg.Data) < 32 {
+			continue
+		}
+		out = append(out, Transfer{
+			From:  stringspkg.ToLower(common.BytesToAddress(lg.Topics[1].Bytes()).Hex()),
+			To:    stringspkg.ToLower(common.BytesToAddress(lg.Topics[2].Bytes()).Hex()),
+			Value: new(mathbig.Int).SetBytes(lg.Data[:32]).String(),
+		})
+	}
+	return out
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	contextpkg "context"
+	encodingjson "encoding/json"
+	fmtpkg "fmt"
+
+	"github.com/IBM/sarama"
+	typespkg "github.com/ethereum/go-ethereum/core/types"
+)
+
+// txnKey marks a context whose sends belong to the open transaction.
+type txnKey struct{}
+
+// newTxnProducer returns a producer for KAFKA_TRANSACTIONS. Its
+// transactional.id is stable per tenant and chain, so a restarted poller
+// fences off its predecessor and aborts whatever transaction it left open.
+func newTxnProducer(brokers []string, security kafkaSecurity, settings producerSettings, tenant string, chainID uint64) (sarama.SyncProducer, string, error) {
+	id := fmtpkg.Sprintf("gas-poller-%s-%d", tenant, chainID)
+	cfg := security.config()
+	cfg.Producer.Return.Successes = true
+	cfg.Producer.Partitioner = sarama.NewHashPartitioner
+	cfg.Producer.Transaction.ID = id
+	// transactions require idempotence, which requires acks=all
+	settings.idempotent, settings.acks = true, "all"
+	if err := settings.apply(cfg); err != nil {
+		return nil, "", err
+	}
+	producer, err := sarama.NewSyncProducer(brokers, cfg)
+	return producer, id, err
+}
+
+// inTxn calls send with a context whose sends all go into one Kafka
+// transaction, committed if send succeeds and aborted otherwise. An
+// aborted transaction is retried with pub.retry's backoff, so send must be
+// safe to repeat. The producer has one transaction open at a time, so
+// callers are serialised. Without KAFKA_TRANSACTIONS, or inside a
+// transaction already, it just calls send.
+func (pub *publisher) inTxn(ctx contextpkg.Context, send func(contextpkg.Context) error) error {
+	if pub.txn == nil || ctx.Value(txnKey{}) != nil {
+		return send(ctx)
+	}
+	pub.txnMu.Lock()
+	defer pub.txnMu.Unlock()
+	txnCtx := contextpkg.WithValue(ctx, txnKey{}, true)
+	return retry(ctx, pub.retry, func() error {
+		if err := pub.txn.BeginTxn(); err != nil {
+			return fmtpkg.Errorf("begin transaction: %w", err)
+		}
+		err := send(txnCtx)
+		if err == nil {
+			if err = pub.txn.CommitTxn(); err == nil {
+				kafkaTxns.WithLabelValues("committed").Inc()
+				return nil
+			}
+			err = fmtpkg.Errorf("commit transaction: %w", err)
+		}
+		kafkaTxns.WithLabelValues("aborted").Inc()
+		if abortErr := pub.txn.AbortTxn(); abortErr != nil {
+			logFor("kafka").Error("abort transaction", "err", abortErr, "status", pub.txn.TxnStatus())
+		}
+		logFor("kafka").Warn("kafka transaction aborted", "err", err)
+		return err
+	})
+}
+
+// sendTxn sends msg on the transactional producer. Failures are not
+// dead-lettered: the transaction is aborted and retried as a whole.
+func (pub *publisher) sendTxn(msg *sarama.ProducerMessage) error {
+	partition, offset, err := pub.txn.SendMessage(msg)
+	if err != nil {
+		kafkaSendErrors.Inc()
+		return err
+	}
+	kafkaSent.Inc()
+	logFor("kafka").Debug("kafka sent", "topic", msg.Topic, "partition", partition, "offset", offset)
+	return nil
+}
+
+// blockMarker closes a block's transaction: a consumer reading committed
+// messages that sees it has seen every event of the block.
+func (p *poller) blockMarker(blk *typespkg.Block, events int, opts blockOpts) *sarama.ProducerMessage {
+	value, _ := encodingjson.Marshal(map[string]any{
+		"type":        "blockMarker",
+		"tenantId":    p.tenant,
+		"chainId":     p.chainID.Uint64(),
+		"eventId":     eventID(p.tenant, "marker", blk.Hash().Hex()),
+		"blockNumber": blk.NumberU64(),
+		"blockHash":   blk.Hash().Hex(),
+		"events":      events,
+		"source":      opts.source,
+	})
+	return &sarama.ProducerMessage{
+		Topic:   p.markerTopic,
+		Key:     sarama.StringEncoder(p.tenant),
+		Value:   sarama.ByteEncoder(value),
+		Headers: p.headers("", opts.source),
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	contextpkg "context"
+	encodingjson "encoding/json"
+	errorspkg "errors"
+	iofspkg "io/fs"
+	ospkg "os"
+	syncpkg "sync"
+	timepkg "time"
+)
+
+// watchCache keeps a copy of the watch sets in a local file, so a poller
+// restarted while the API is down starts with the watches it had instead of
+// none. The file names the tenant and chain it was written for, and one
+// left on a volume reused by another poller is ignored.
+type watchCache struct {
+	path    string
+	tenant  string
+	chainID uint64
+
+	targets, senders *WatchSet
+
+	mu syncpkg.Mutex
+	// saved is the version of each set last written
+	saved [2]uint64
+}
+
+// watchCacheFile is the cache file's contents.
+type watchCacheFile struct {
+	TenantID string        `json:"tenantId"`
+	ChainID  uint64        `json:"chainId"`
+	SavedAt  int64         `json:"savedAt"`
+	Watches  []cachedWatch `json:"watches"`
+}
+
+type cachedWatch struct {
+	Address    string   `json:"address"`
+	Type       string   `json:"type"`
+	Methods    []string `json:"methods,omitempty"`
+	MinCostEth string   `json:"minCostEth,omitempty"`
+}
+
+// load adds the cached watches to the sets and returns how many there were.
+// A missing, unreadable or foreign file loads nothing.
+func (c *watchCache) load() int {
+	data, err := ospkg.ReadFile(c.path)
+	if errorspkg.Is(err, iofspkg.ErrNotExist) {
+		return 0
+	}
+	if err != nil {
+		logFor("watches").Warn("read watch cache, ignoring it", "path", c.path, "err", err)
+		return 0
+	}
+	var f watchCacheFile
+	if err := encodingjson.Unmarshal(data, &f); err != nil {
+		logFor("watches").Warn("parse watch cache, ignoring it", "path", c.path, "err", err)
+		return 0
+	}
+	if f.TenantID != c.tenant || f.ChainID != c.chainID {
+		logFor("watches").Warn("watch cache is for another tenant or chain, ignoring it", "path", c.path,
+			"tenantId", f.TenantID, "chainId", f.ChainID, "wantTenantId", c.tenant, "wantChainId", c.chainID)
+		return 0
+	}
+	watches := make([]watch, 0, len(f.Watches))
+	for _, w := range f.Watches {
+		watches = append(watches, watch{Address: w.Address, Type: w.Type, Methods: w.Methods, MinCostEth: w.MinCostEth})
+	}
+	addWatches(watches, c.targets, c.senders)
+	logFor("watches").Info("loaded cached watches", "path", c.path, "watches", len(watches), "savedAt", timepkg.Unix(f.SavedAt, 0).UTC())
+	return len(watches)
+}
+
+// save writes the sets to the file if they changed since the last save.
+// Errors are also logged.
+func (c *watchCache) save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	versions := [2]uint64{c.targets.Version(), c.senders.Version()}
+	if versions == c.saved {
+		return nil
+	}
+	f := watchCacheFile{TenantID: c.tenant, ChainID: c.chainID, SavedAt: timepkg.Now().Unix(), Watches: []cachedWatch{}}
+	for _, s := range []struct {
+		kind string
+		set  *WatchSet
+	}{{watchContract, c.targets}, {watchFrom, c.senders}} {
+		for _, w := range s.set.Watches() {
+			f.Watches = append(f.Watches, cachedWatch{Address: w.Address, Type: s.kind, Methods: w.Methods, MinCostEth: w.MinCostEth})
+		}
+	}
+	data, _ := encodingjson.Marshal(f)
+	if err := writeFileAtomic(c.path, data); err != nil {
+		logFor("watches").Error("save watch cache", "path", c.path, "err", err)
+		return err
+	}
+	c.saved = versions
+	return nil
+}
+
+// run saves the sets every interval they changed in until ctx is done, so a
+// burst of watch requests is written once.
+func (c *watchCache) run(ctx contextpkg.Context, every timepkg.Duration) {
+	for sleepCtx(ctx, every) {
+		c.save()
+	}
+}
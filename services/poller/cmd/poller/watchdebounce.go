@@ -0,0 +1,86 @@
+package main
+
+import (
+	syncpkg "sync"
+	timepkg "time"
+)
+
+// watchDebouncer coalesces the add and remove requests that arrive within
+// window of the first pending one, and applies only the net result per
+// address, all at once, so a flurry of edits to a watch does not flap its
+// matches mid-block.
+type watchDebouncer struct {
+	window timepkg.Duration
+	// applied is called for each net change once it is in its set
+	applied func(watchChange)
+
+	// flushing is held through a whole flush, so afterFlush can wait for
+	// one already under way
+	flushing syncpkg.Mutex
+
+	mu      syncpkg.Mutex
+	pending map[*WatchSet]map[string]watchChange
+	// after holds the afterFlush calls waiting for the pending changes
+	after []func()
+	timer *timepkg.Timer
+}
+
+func newWatchDebouncer(window timepkg.Duration, applied func(watchChange)) *watchDebouncer {
+	return &watchDebouncer{window: window, applied: applied, pending: make(map[*WatchSet]map[string]watchChange)}
+}
+
+// add queues c for set, replacing any change still pending for its address.
+func (d *watchDebouncer) add(set *WatchSet, c watchChange) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.pending[set] == nil {
+		d.pending[set] = make(map[string]watchChange)
+	}
+	d.pending[set][c.addr] = c
+	if d.timer == nil {
+		d.timer = timepkg.AfterFunc(d.window, d.flush)
+	}
+}
+
+// afterFlush calls fn once every change added so far is applied: now if
+// none is pending, otherwise after the flush that applies them. Calls run
+// in order.
+func (d *watchDebouncer) afterFlush(fn func()) {
+	d.flushing.Lock()
+	defer d.flushing.Unlock()
+	d.mu.Lock()
+	if len(d.pending) > 0 {
+		d.after = append(d.after, fn)
+		d.mu.Unlock()
+		return
+	}
+	d.mu.Unlock()
+	fn()
+}
+
+// flush applies the pending changes now.
+func (d *watchDebouncer) flush() {
+	d.flushing.Lock()
+	defer d.flushing.Unlock()
+	d.mu.Lock()
+	pending, after := d.pending, d.after
+	d.pending, d.after = make(map[*WatchSet]map[string]watchChange), nil
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	d.mu.Unlock()
+	for set, byAddr := range pending {
+		changes := make([]watchChange, 0, len(byAddr))
+		for _, c := range byAddr {
+			changes = append(changes, c)
+		}
+		set.Apply(changes)
+		for _, c := range changes {
+			d.applied(c)
+		}
+	}
+	for _, fn := range after {
+		fn()
+	}
+}
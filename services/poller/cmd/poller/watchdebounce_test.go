@@ -0,0 +1,85 @@
+package main
+
+import (
+	syncpkg "sync"
+	testingpkg "testing"
+	timepkg "time"
+)
+
+// TestWatchDebounceNetResult fires a flurry of adds and removes for one
+// contract within the window and checks only the net result is applied,
+// once, when the window closes.
+func TestWatchDebounceNetResult(t *testingpkg.T) {
+	const addr = "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed"
+	add := watchChange{addr: addr}
+	remove := watchChange{addr: addr, remove: true}
+	approve, _ := methodFilter([]string{"0x095ea7b3"})
+	transfer, _ := methodFilter([]string{"0xa9059cbb"})
+	for _, tc := range []struct {
+		name        string
+		watched     bool
+		changes     []watchChange
+		wantWatched bool
+		wantRemoved bool
+		// wantTransferOnly is set when the net add filters to transfer
+		wantTransferOnly bool
+	}{
+		{name: "add remove add", changes: []watchChange{add, remove, add}, wantWatched: true},
+		{name: "add remove", changes: []watchChange{add, remove}, wantRemoved: true},
+		{name: "remove add of a watched contract", watched: true, changes: []watchChange{remove, add}, wantWatched: true},
+		{
+			name:             "the last add's filter wins",
+			changes:          []watchChange{{addr: addr, filter: approve}, remove, {addr: addr, filter: transfer}},
+			wantWatched:      true,
+			wantTransferOnly: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testingpkg.T) {
+			set := NewWatchSet()
+			if tc.watched {
+				set.Add(addr)
+			}
+			var (
+				mu      syncpkg.Mutex
+				applied []watchChange
+			)
+			d := newWatchDebouncer(50*timepkg.Millisecond, func(c watchChange) {
+				mu.Lock()
+				applied = append(applied, c)
+				mu.Unlock()
+			})
+			for _, c := range tc.changes {
+				d.add(set, c)
+			}
+			if set.Contains(addr) != tc.watched {
+				t.Fatal("a change was applied before the window closed")
+			}
+			flushed := make(chan struct{})
+			d.afterFlush(func() { close(flushed) })
+			select {
+			case <-flushed:
+			case <-timepkg.After(timepkg.Second):
+				t.Fatal("changes not applied a second after the window")
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if len(applied) != 1 || applied[0].remove != tc.wantRemoved {
+				t.Fatalf("applied %+v, want one change with remove %v", applied, tc.wantRemoved)
+			}
+			if set.Contains(addr) != tc.wantWatched {
+				t.Fatalf("watched = %v, want %v", set.Contains(addr), tc.wantWatched)
+			}
+			want := []string{}
+			if tc.wantWatched {
+				want = []string{addr}
+			}
+			if snap := set.Snapshot(); len(snap) != len(want) || len(snap) == 1 && snap[0] != addr {
+				t.Errorf("set holds %v, want %v", snap, want)
+			}
+			if tc.wantTransferOnly && (!set.Matches(addr, []byte{0xa9, 0x05, 0x9c, 0xbb}) || set.Matches(addr, []byte{0x09, 0x5e, 0xa7, 0xb3})) {
+				t.Error("the filter is not the last add's")
+			}
+		})
+	}
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	contextpkg "context"
+	encodingjson "encoding/json"
+	fmtpkg "fmt"
+	strconvpkg "strconv"
+	stringspkg "strings"
+	timepkg "time"
+
+	"github.com/IBM/sarama"
+)
+
+// consumerGroupHandler applies watch requests from the watch requests topic
+// (KAFKA_TOPIC_WATCH_REQUESTS) to the watch sets.
+type consumerGroupHandler struct {
+	ctx      contextpkg.Context
+	targets  *WatchSet
+	senders  *WatchSet
+	tenant   string
+	chainID  uint64
+	backfill *backfiller
+	// pub sends requests that cannot be applied to dlqTopic
+	// (KAFKA_TOPIC_WATCH_REJECTS), unless it is empty
+	pub      *publisher
+	dlqTopic string
+	// ackTopic (KAFKA_TOPIC_WATCH_ACKS) receives a watchAck for each add
+	// or remove, unless it is empty; instance identifies this poller in them
+	ackTopic string
+	instance string
+	// maxWatches caps the watches add requests can grow the sets to; 0 is
+	// unlimited
+	maxWatches int
+	// debounce, when set, holds adds and removes for WATCH_DEBOUNCE_MS and
+	// applies their net result
+	debounce *watchDebouncer
+	// cache, when set, is written before a request's offset is marked,
+	// and a request is left unmarked if that fails
+	cache *watchCache
+	// tip returns the confirmed tip, used as the end of a backfill that does
+	// not name one
+	tip func(contextpkg.Context) (uint64, error)
+}
+
+func (h consumerGroupHandler) Setup(s sarama.ConsumerGroupSession) error { return nil }
+
+// Cleanup applies the changes still debounced, so their requests are marked
+// before the session commits its offsets.
+func (h consumerGroupHandler) Cleanup(s sarama.ConsumerGroupSession) error {
+	if h.debounce != nil {
+		h.debounce.flush()
+	}
+	return nil
+}
+
+// ConsumeClaim handles the claim's requests until the session ends, on a
+// rebalance or shutdown, marking each only once what it changed is in the
+// watch sets and the watch cache. A crash in between replays the request
+// instead of losing it.
+func (h consumerGroupHandler) ConsumeClaim(s sarama.ConsumerGroupSession, c sarama.ConsumerGroupClaim) error {
+	for {
+		select {
+		case <-s.Context().Done():
+			return nil
+		case msg, ok := <-c.Messages():
+			if !ok {
+				return nil
+			}
+			h.handle(msg)
+			h.commit(s, msg)
+		}
+	}
+}
+
+// commit marks msg consumed once the changes made so far, debounced ones
+// included, are applied and cached. Marks stay in order, as marking an
+// offset commits every one before it in the partition.
+func (h consumerGroupHandler) commit(s sarama.ConsumerGroupSession, msg *sarama.ConsumerMessage) {
+	mark := func() {
+		// left unmarked, msg is covered by the next mark that saves
+		if h.cache != nil && h.cache.save() != nil {
+			return
+		}
+		s.MarkMessage(msg, "")
+	}
+	if h.debounce == nil {
+		mark()
+		return
+	}
+	h.debounce.afterFlush(mark)
+}
+
+// handle applies or rejects one watch request; requests for other tenants
+// are skipped.
+func (h consumerGroupHandler) handle(msg *sarama.ConsumerMessage) {
+	// producers that set the tenantId header let other tenants'
+	// requests be skipped without decoding them
+	if tenant, ok := headerValue(msg.Headers, headerTenant); ok && tenant != h.tenant {
+		return
+	}
+	var payload struct {
+		TenantId  string   `json:"tenantId"`
+		Contract  string   `json:"contract"`
+		Address   string   `json:"address"`
+		Type      string   `json:"type"`
+		Methods   []string `json:"methods"`
+		Action    string   `json:"action"`
+		FromBlock uint64   `json:"fromBlock"`
+		ToBlock   uint64   `json:"toBlock"`
+		// Contracts is the complete watch list of a replace
+		Contracts []string `json:"contracts"`
+		// MinCostEth is a number or a numeric string; Number keeps its
+		// digits exact
+		MinCostEth encodingjson.Number `json:"minCostEth"`
+	}
+	if err := encodingjson.Unmarshal(msg.Value, &payload); err != nil {
+		h.deadLetter(msg, "malformed JSON: "+err.Error())
+		return
+	}
+	// a request without a tenant cannot be meant for this poller or
+	// any other, so it is rejected rather than skipped
+	if stringspkg.TrimSpace(payload.TenantId) == "" {
+		h.deadLetter(msg, "missing tenantId")
+		return
+	}
+	if payload.TenantId != h.tenant {
+		return
+	}
+	if payload.Action == "replace" || payload.Action == "clear" {
+		if reject := h.replace(payload.Action, payload.Type, payload.Contracts); reject != "" {
+			h.deadLetter(msg, reject)
+			h.ack(payload.Action, "", reject)
+		}
+		return
+	}
+	raw := payload.Address
+	if raw == "" {
+		raw = payload.Contract
+	}
+	addr, valid := normalizeAddress(raw)
+	// sets and backfills key on lowercase addresses
+	addr = stringspkg.ToLower(addr)
+	set := setFor(payload.Type, h.targets, h.senders)
+	minCost, minCostErr := parseEthAmount(payload.MinCostEth.String())
+	var reject string
+	switch {
+	case !watchActions[payload.Action]:
+		reject = "unknown action " + strconvpkg.Quote(payload.Action)
+	case !valid:
+		invalidWatches.Inc()
+		reject = "invalid address " + strconvpkg.Quote(raw)
+		addr = raw
+	case set == nil:
+		reject = "unknown watch type " + strconvpkg.Quote(payload.Type)
+	case payload.Action == "add" && minCostErr != nil:
+		reject = "minCostEth: " + minCostErr.Error()
+	case payload.Action == "add" && h.atLimit(set, addr):
+		reject = fmtpkg.Sprintf("watch limit of %d reached", h.maxWatches)
+	case payload.Action == "add":
+		filter, bad := methodFilter(payload.Methods)
+		if len(bad) > 0 {
+			logFor("watches").Warn("ignoring invalid method selectors", "address", addr, "methods", bad)
+		}
+		h.apply(set, watchChange{addr: addr, filter: filter, minCost: minCost})
+		// an add with fromBlock also catches up on the contract's
+		// history, while live tailing matches it from now on
+		switch {
+		case payload.FromBlock == 0:
+		case set != h.targets:
+			logFor("watches").Warn("only contract watches can be backfilled, ignoring fromBlock", "address", addr)
+		default:
+			h.startBackfill(addr, payload.FromBlock, payload.ToBlock)
+		}
+	case payload.Action == "remove":
+		h.apply(set, watchChange{addr: addr, remove: true})
+	case payload.Action == "backfill" && set != h.targets:
+		logFor("watches").Warn("only contract watches can be backfilled", "address", addr)
+	case payload.Action == "backfill":
+		h.startBackfill(addr, payload.FromBlock, payload.ToBlock)
+	}
+	if reject != "" {
+		h.deadLetter(msg, reject)
+		if payload.Action != "backfill" {
+			h.ack(payload.Action, addr, reject)
+		}
+	}
+}
+
+// watchActions are the verbs a watch request can carry.
+var watchActions = map[string]bool{"add": true, "remove": true, "backfill": true, "replace": true, "clear": true}
+
+// replace swaps the watch set of typ for contracts (replace), or empties
+// every set (clear), each in one step so the poll loop never matches a
+// block against half of it. It returns why the request was rejected, or
+// "" once applied; a list with any invalid address is rejected whole.
+func (h consumerGroupHandler) replace(action, typ string, contracts []string) string {
+	sets := []*WatchSet{h.targets, h.senders}
+	var adds []watchChange
+	if action == "replace" {
+		set := setFor(typ, h.targets, h.senders)
+		if set == nil {
+			return "unknown watch type " + strconvpkg.Quote(typ)
+		}
+		sets = []*WatchSet{set}
+		seen := make(map[string]bool, len(contracts))
+		for _, raw := range contracts {
+			addr, ok := normalizeAddress(raw)
+			if !ok {
+				invalidWatches.Inc()
+				return "invalid address " + strconvpkg.Quote(raw)
+			}
+			if addr = stringspkg.ToLower(addr); !seen[addr] {
+				seen[addr] = true
+				adds = append(adds, watchChange{addr: addr})
+			}
+		}
+		others := h.targets.Len() + h.senders.Len() - set.Len()
+		if h.maxWatches > 0 && others+len(adds) > h.maxWatches {
+			return fmtpkg.Sprintf("watch limit of %d reached", h.maxWatches)
+		}
+	}
+	// changes still debounced were requested before this one
+	if h.debounce != nil {
+		h.debounce.flush()
+	}
+	for _, set := range sets {
+		set.Replace(adds)
+	}
+	logFor("watches").Info("watch set replaced", "action", action, "type", typ, "watches", len(adds))
+	h.ack(action, "", "")
+	return ""
+}
+
+func (h consumerGroupHandler) startBackfill(contract string, from, to uint64) {
+	if to == 0 {
+		tip, err := h.tip(h.ctx)
+		if err != nil {
+			logFor("watches").Error("backfill: resolve end block", "contract", contract, "err", err)
+			return
+		}
+		to = tip
+	}
+	if err := h.backfill.Start(h.ctx, contract, from, to); err != nil {
+		logFor("watches").Error("backfill", "contract", contract, "err", err)
+	}
+}
+
+// apply makes c in set, now or through debounce, and acks it once made.
+func (h consumerGroupHandler) apply(set *WatchSet, c watchChange) {
+	if h.debounce != nil {
+		h.debounce.add(set, c)
+		return
+	}
+	set.Apply([]watchChange{c})
+	h.applied(c)
+}
+
+func (h consumerGroupHandler) applied(c watchChange) {
+	action := "add"
+	if c.remove {
+		action = "remove"
+	}
+	h.ack(action, c.addr, "")
+}
+
+// atLimit reports whether adding addr to set would exceed maxWatches.
+// Re-adding a watched address only replaces its methods, so it is allowed.
+func (h consumerGroupHandler) atLimit(set *WatchSet, addr string) bool {
+	return h.maxWatches > 0 && !set.Contains(addr) && h.targets.Len()+h.senders.Len() >= h.maxWatches
+}
+
+// watchAck confirms an add or remove request was applied (ok) or says why
+// it was not, so whoever sent it can tell the poller picked it up.
+type watchAck struct {
+	TenantID   string `json:"tenantId"`
+	ChainID    uint64 `json:"chainId"`
+	Contract   string `json:"contract"`
+	Action     string `json:"action"`
+	OK         bool   `json:"ok"`
+	Reason     string `json:"reason,omitempty"`
+	AppliedAt  string `json:"appliedAt"`
+	WatchCount int    `json:"watchCount"`
+	InstanceID string `json:"instanceId"`
+}
+
+// ack publishes a watchAck, negative when reason is set. Like the events,
+// it is keyed by <tenantId>:<contract>.
+func (h consumerGroupHandler) ack(action, contract, reason string) {
+	if h.ackTopic == "" {
+		return
+	}
+	value, _ := encodingjson.Marshal(watchAck{
+		TenantID:   h.tenant,
+		ChainID:    h.chainID,
+		Contract:   contract,
+		Action:     action,
+		OK:         reason == "",
+		Reason:     reason,
+		AppliedAt:  timepkg.Now().UTC().Format(timepkg.RFC3339),
+		WatchCount: h.targets.Len() + h.senders.Len(),
+		InstanceID: h.instance,
+	})
+	err := h.pub.send(h.ctx, &sarama.ProducerMessage{
+		Topic:   h.ackTopic,
+		Key:     sarama.StringEncoder(h.tenant + ":" + contract),
+		Value:   sarama.ByteEncoder(value),
+		Headers: messageHeaders{tenant: h.tenant, chainID: h.chainID, contract: contract}.records(),
+	})
+	if err != nil {
+		logFor("watches").Error("ack watch request", "topic", h.ackTopic, "err", err)
+	}
+}
+
+// watchDeadLetter is what lands on the watch rejects topic: the original
+// message verbatim plus why it was rejected.
+type watchDeadLetter struct {
+	Topic      string `json:"topic"`
+	Partition  int32  `json:"partition"`
+	Offset     int64  `json:"offset"`
+	Key        string `json:"key,omitempty"`
+	Value      string `json:"value"`
+	Reason     string `json:"reason"`
+	RejectedBy string `json:"rejectedBy"`
+	ChainID    uint64 `json:"chainId"`
+	RejectedAt string `json:"rejectedAt"`
+}
+
+// deadLetter publishes a watch request that cannot be applied, so a broken
+// producer upstream shows up on the rejects topic instead of being dropped.
+// The reason is also sent as the rejectReason header.
+func (h consumerGroupHandler) deadLetter(msg *sarama.ConsumerMessage, reason string) {
+	watchRequestsDeadLettered.Inc()
+	logFor("watches").Warn("watch request rejected", "topic", msg.Topic, "partition", msg.Partition, "offset", msg.Offset, "reason", reason)
+	if h.dlqTopic == "" {
+		return
+	}
+	value, _ := encodingjson.Marshal(watchDeadLetter{
+		Topic:      msg.Topic,
+		Partition:  msg.Partition,
+		Offset:     msg.Offset,
+		Key:        string(msg.Key),
+		Value:      string(msg.Value),
+		Reason:     reason,
+		RejectedBy: h.tenant,
+		ChainID:    h.chainID,
+		RejectedAt: timepkg.Now().UTC().Format(timepkg.RFC3339),
+	})
+	headers := append(messageHeaders{tenant: h.tenant, chainID: h.chainID}.records(),
+		sarama.RecordHeader{Key: []byte(headerRejectReason), Value: []byte(reason)})
+	err := h.pub.send(h.ctx, &sarama.ProducerMessage{
+		Topic:   h.dlqTopic,
+		Key:     sarama.ByteEncoder(msg.Key),
+		Value:   sarama.ByteEncoder(value),
+		Headers: headers,
+	})
+	if err != nil {
+		logFor("watches").Error("dead-letter watch request", "topic", h.dlqTopic, "err", err)
+	}
+}
@@ -0,0 +1,407 @@
+package main
+
+import (
+	hexpkg "encoding/hex"
+	fmtpkg "fmt"
+	mathbig "math/big"
+	sortpkg "sort"
+	stringspkg "strings"
+	syncpkg "sync"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// WatchSet is a set of watched addresses. It is shared between the
+// watch-request consumer goroutine and the poll loop, so all access goes
+// through its methods. Addresses are stored lowercased.
+type WatchSet struct {
+	mu syncpkg.RWMutex
+	// addrs maps each address to its method filter: the selectors it is
+	// watched for, or nil to match every method
+	addrs map[string]map[string]bool
+	// minCost holds the per-watch minimum cost in wei of the addresses
+	// that have one
+	minCost map[string]*mathbig.Int
+	// version counts changes; changed holds the version of each address's
+	// last Add or Remove, so Sync can leave alone what changed since it
+	// started
+	version uint64
+	changed map[string]uint64
+	// view caches View until the next change
+	view *watchView
+	// removals, when non-nil (trackRemovals), collects the addresses
+	// removed for the poll loop to announce with watchRemoved markers
+	removals []watchRemoval
+}
+
+// watchRemoval is an address removed from a WatchSet at version.
+type watchRemoval struct {
+	addr    string
+	version uint64
+}
+
+func NewWatchSet() *WatchSet {
+	return &WatchSet{
+		addrs:   make(map[string]map[string]bool),
+		minCost: make(map[string]*mathbig.Int),
+		changed: make(map[string]uint64),
+	}
+}
+
+// Add watches addr, replacing any previous method filter. With no methods
+// every call matches; otherwise only calls whose selector is listed, given
+// as case-insensitive hex with or without 0x. Invalid selectors are returned
+// and left out of the filter.
+func (w *WatchSet) Add(addr string, methods ...string) (invalid []string) {
+	return w.AddWithMinCost(addr, nil, methods...)
+}
+
+// AddWithMinCost is Add that also replaces addr's minimum cost in wei: its
+// txs costing less are not emitted. A nil minCost falls back to the global
+// MIN_COST_ETH.
+func (w *WatchSet) AddWithMinCost(addr string, minCost *mathbig.Int, methods ...string) (invalid []string) {
+	filter, invalid := methodFilter(methods)
+	w.mu.Lock()
+	w.add(stringspkg.ToLower(addr), filter, minCost)
+	w.mu.Unlock()
+	return invalid
+}
+
+// add sets a lowercased addr's watch. The caller holds mu.
+func (w *WatchSet) add(addr string, filter map[string]bool, minCost *mathbig.Int) {
+	w.addrs[addr] = filter
+	if minCost != nil {
+		w.minCost[addr] = minCost
+	} else {
+		delete(w.minCost, addr)
+	}
+	w.version++
+	w.changed[addr] = w.version
+}
+
+func (w *WatchSet) Remove(addr string) {
+	w.mu.Lock()
+	w.remove(stringspkg.ToLower(addr))
+	w.mu.Unlock()
+}
+
+// remove is Remove for a lowercased addr. The caller holds mu.
+func (w *WatchSet) remove(addr string) {
+	_, watched := w.addrs[addr]
+	delete(w.addrs, addr)
+	delete(w.minCost, addr)
+	w.version++
+	w.changed[addr] = w.version
+	if watched && w.removals != nil {
+		w.removals = append(w.removals, watchRemoval{addr: addr, version: w.version})
+	}
+}
+
+// trackRemovals makes the set collect its removals for pendingRemovals.
+func (w *WatchSet) trackRemovals() {
+	w.mu.Lock()
+	w.removals = []watchRemoval{}
+	w.mu.Unlock()
+}
+
+// pendingRemovals returns the removals made up to version, leaving them
+// pending until forgetRemoval.
+func (w *WatchSet) pendingRemovals(version uint64) []watchRemoval {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var out []watchRemoval
+	for _, r := range w.removals {
+		if r.version <= version {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// forgetRemoval drops r once it has been announced.
+func (w *WatchSet) forgetRemoval(r watchRemoval) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for i, pending := range w.removals {
+		if pending == r {
+			w.removals = append(w.removals[:i], w.removals[i+1:]...)
+			return
+		}
+	}
+}
+
+// watchView is a read-only copy of a WatchSet as of version. A block is
+// matched against one view, so a watch changed while it is being processed
+// applies to the whole block or to none of it.
+type watchView struct {
+	version uint64
+	addrs   map[string]map[string]bool
+}
+
+// View returns the set as it is now.
+func (w *WatchSet) View() *watchView {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.view == nil || w.view.version != w.version {
+		// filters are replaced rather than changed, so they can be shared
+		addrs := make(map[string]map[string]bool, len(w.addrs))
+		for a, f := range w.addrs {
+			addrs[a] = f
+		}
+		w.view = &watchView{version: w.version, addrs: addrs}
+	}
+	return w.view
+}
+
+func (v *watchView) Len() int { return len(v.addrs) }
+
+func (v *watchView) Contains(addr string) bool {
+	_, ok := v.addrs[stringspkg.ToLower(addr)]
+	return ok
+}
+
+// Matches is WatchSet.Matches on the view.
+func (v *watchView) Matches(addr string, calldata []byte) bool {
+	filter, ok := v.addrs[stringspkg.ToLower(addr)]
+	return ok && filterMatches(filter, calldata)
+}
+
+// watchChange is an add of a lowercased address, replacing its filter and
+// minimum cost, or with remove set its removal.
+type watchChange struct {
+	addr    string
+	remove  bool
+	filter  map[string]bool
+	minCost *mathbig.Int
+}
+
+// Apply makes all of changes under one lock, so a block is matched against
+// either none or all of them.
+func (w *WatchSet) Apply(changes []watchChange) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, c := range changes {
+		if c.remove {
+			w.remove(c.addr)
+		} else {
+			w.add(c.addr, c.filter, c.minCost)
+		}
+	}
+}
+
+// Replace swaps the set's contents for adds under one lock.
+func (w *WatchSet) Replace(adds []watchChange) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for addr := range w.addrs {
+		w.remove(addr)
+	}
+	for _, c := range adds {
+		w.add(c.addr, c.filter, c.minCost)
+	}
+}
+
+// Version identifies the set's state, to pass to Sync.
+func (w *WatchSet) Version() uint64 {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.version
+}
+
+// Sync makes the set match want, keyed by lowercased address, except for
+// the addresses added or removed after since: those changes are newer than
+// want, which was read at since. Existing watches keep their filters. It
+// returns the addresses it added and removed.
+func (w *WatchSet) Sync(want map[string]watch, since uint64) (added, removed []string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for addr, wt := range want {
+		if _, ok := w.addrs[addr]; ok || w.changed[addr] > since {
+			continue
+		}
+		filter, _ := methodFilter(wt.Methods)
+		minCost, _ := parseEthAmount(wt.MinCostEth)
+		w.add(addr, filter, minCost)
+		added = append(added, addr)
+	}
+	for addr := range w.addrs {
+		if _, ok := want[addr]; ok || w.changed[addr] > since {
+			continue
+		}
+		w.remove(addr)
+		removed = append(removed, addr)
+	}
+	// what is older than since has now been checked against want, and
+	// needs no guarding from the next Sync
+	for addr, v := range w.changed {
+		if v <= since {
+			delete(w.changed, addr)
+		}
+	}
+	return added, removed
+}
+
+// MinCost returns addr's minimum cost in wei, or nil if it has none.
+func (w *WatchSet) MinCost(addr string) *mathbig.Int {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.minCost[stringspkg.ToLower(addr)]
+}
+
+func (w *WatchSet) Contains(addr string) bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	_, ok := w.addrs[stringspkg.ToLower(addr)]
+	return ok
+}
+
+// Matches reports whether a call with calldata to or from addr is watched,
+// applying addr's method filter.
+func (w *WatchSet) Matches(addr string, calldata []byte) bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	filter, ok := w.addrs[stringspkg.ToLower(addr)]
+	return ok && filterMatches(filter, calldata)
+}
+
+// filterMatches applies a method filter to a call: nil matches everything.
+func filterMatches(filter map[string]bool, calldata []byte) bool {
+	if filter == nil {
+		return true
+	}
+	return len(calldata) >= 4 && filter[hexpkg.EncodeToString(calldata[:4])]
+}
+
+// methodFilter builds the filter matching methods, nil for every method,
+// returning the selectors that are not valid.
+func methodFilter(methods []string) (filter map[string]bool, invalid []string) {
+	for _, m := range methods {
+		sel, ok := normalizeSelector(m)
+		if !ok {
+			invalid = append(invalid, m)
+			continue
+		}
+		if filter == nil {
+			filter = make(map[string]bool)
+		}
+		filter[sel] = true
+	}
+	return filter, invalid
+}
+
+// normalizeSelector turns a 4-byte selector into lowercase hex without 0x.
+func normalizeSelector(s string) (string, bool) {
+	s = stringspkg.ToLower(stringspkg.TrimPrefix(stringspkg.TrimPrefix(s, "0x"), "0X"))
+	if b, err := hexpkg.DecodeString(s); err != nil || len(b) != 4 {
+		return "", false
+	}
+	return s, true
+}
+
+// parseEthAmount turns a decimal amount of ETH, such as "0.005", into wei
+// exactly, so thresholds compare without float rounding. An empty s is no
+// amount: nil and no error.
+func parseEthAmount(s string) (*mathbig.Int, error) {
+	s = stringspkg.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	r, ok := new(mathbig.Rat).SetString(s)
+	if !ok || stringspkg.Contains(s, "/") || r.Sign() < 0 {
+		return nil, fmtpkg.Errorf("invalid ETH amount %q", s)
+	}
+	r.Mul(r, mathbig.NewRat(1e18, 1))
+	if !r.IsInt() {
+		return nil, fmtpkg.Errorf("ETH amount %q is finer than 1 wei", s)
+	}
+	return new(mathbig.Int).Set(r.Num()), nil
+}
+
+// formatEthAmount is the inverse of parseEthAmount: wei as a decimal amount
+// of ETH, exactly, or "" for nil.
+func formatEthAmount(wei *mathbig.Int) string {
+	if wei == nil {
+		return ""
+	}
+	s := new(mathbig.Rat).SetFrac(wei, mathbig.NewInt(1e18)).FloatString(18)
+	return stringspkg.TrimSuffix(stringspkg.TrimRight(s, "0"), ".")
+}
+
+// normalizeAddress validates a watched address and returns its EIP-55
+// form. A mixed-case address must carry a valid checksum, so a typo in a
+// checksummed address is caught instead of silently never matching.
+func normalizeAddress(s string) (string, bool) {
+	s = stringspkg.TrimSpace(s)
+	if !common.IsHexAddress(s) {
+		return "", false
+	}
+	norm := common.HexToAddress(s).Hex()
+	hex := stringspkg.TrimPrefix(stringspkg.TrimPrefix(s, "0x"), "0X")
+	mixed := hex != stringspkg.ToLower(hex) && hex != stringspkg.ToUpper(hex)
+	if mixed && hex != norm[2:] {
+		return "", false
+	}
+	return norm, true
+}
+
+func (w *WatchSet) Len() int {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return len(w.addrs)
+}
+
+// Snapshot returns a copy of the current addresses that is safe to iterate
+// without holding the lock.
+func (w *WatchSet) Snapshot() []string {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	out := make([]string, 0, len(w.addrs))
+	for a := range w.addrs {
+		out = append(out, a)
+	}
+	return out
+}
+
+// Watches returns the set's watches, with Type left empty.
+func (w *WatchSet) Watches() []watch {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	out := make([]watch, 0, len(w.addrs))
+	for addr, filter := range w.addrs {
+		wt := watch{Address: addr, MinCostEth: formatEthAmount(w.minCost[addr])}
+		for sel := range filter {
+			wt.Methods = append(wt.Methods, "0x"+sel)
+		}
+		sortpkg.Strings(wt.Methods)
+		out = append(out, wt)
+	}
+	return out
+}
+
+// Watch types. A contract watch matches transactions sent to the address, a
+// from watch matches transactions sent by it, whatever they call.
+const (
+	watchContract = "contract"
+	watchFrom     = "from"
+)
+
+// watch is one entry from the API or a watch request. An empty Type means
+// watchContract; empty Methods means every method. MinCostEth is a decimal
+// amount of ETH, empty for no per-watch minimum.
+type watch struct {
+	Address    string
+	Type       string
+	Methods    []string
+	MinCostEth string
+}
+
+// setFor returns the set holding watches of typ, or nil for an unknown type.
+func setFor(typ string, contracts, senders *WatchSet) *WatchSet {
+	switch typ {
+	case "", watchContract:
+		return contracts
+	case watchFrom:
+		return senders
+	}
+	return nil
+}
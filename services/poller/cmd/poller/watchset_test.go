@@ -0,0 +1,99 @@
+package main
+
+import (
+	fmtpkg "fmt"
+	syncpkg "sync"
+	testingpkg "testing"
+)
+
+// TestWatchSetConcurrentAccess hammers a WatchSet from writers and readers
+// at once, as the watch-request consumer and the poll loop do. Run it with
+// -race: it is the race detector that fails it.
+func TestWatchSetConcurrentAccess(t *testingpkg.T) {
+	const (
+		writers = 4
+		readers = 4
+		rounds  = 1000
+	)
+	set := NewWatchSet()
+	addr := func(i int) string { return fmtpkg.Sprintf("0x%040x", i%16) }
+
+	var wg syncpkg.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				if i%2 == 0 {
+					set.Add(addr(w+i), "0xa9059cbb")
+				} else {
+					set.Remove(addr(w + i))
+				}
+			}
+		}()
+	}
+	for r := 0; r < readers; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				set.Contains(addr(i))
+				set.Matches(addr(i), []byte{0xa9, 0x05, 0x9c, 0xbb})
+				set.Snapshot()
+				set.View().Contains(addr(i))
+				set.Len()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// whatever the interleaving, the set stays consistent with itself
+	snap := set.Snapshot()
+	if len(snap) != set.Len() {
+		t.Fatalf("Snapshot has %d addresses, Len says %d", len(snap), set.Len())
+	}
+	for _, a := range snap {
+		if !set.Contains(a) {
+			t.Errorf("Snapshot lists %s but Contains says it is not watched", a)
+		}
+	}
+}
+
+func TestWatchSetAddRemove(t *testingpkg.T) {
+	set := NewWatchSet()
+	const mixed = "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"
+	set.Add(mixed)
+	if !set.Contains("0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed") {
+		t.Fatal("address added in mixed case is not found lowercased")
+	}
+	set.Remove("0x5AAEB6053F3E94C9B9A09F33669435E7EF1BEAED")
+	if set.Contains(mixed) || set.Len() != 0 {
+		t.Fatal("address still watched after Remove")
+	}
+}
+
+func TestNormalizeAddress(t *testingpkg.T) {
+	const checksummed = "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"
+	for _, tc := range []struct {
+		name, in string
+		want     string
+		ok       bool
+	}{
+		{name: "empty", in: ""},
+		{name: "blank", in: "   "},
+		{name: "too short", in: "0x5aaeb6053f3e94c9b9a09f33669435e7ef1bea"},
+		{name: "too long", in: "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed00"},
+		{name: "not hex", in: "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaeg"},
+		{name: "checksum mismatch", in: "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAeD"},
+		{name: "checksummed", in: checksummed, want: checksummed, ok: true},
+		{name: "lowercase", in: "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", want: checksummed, ok: true},
+		{name: "uppercase", in: "0x5AAEB6053F3E94C9B9A09F33669435E7EF1BEAED", want: checksummed, ok: true},
+		{name: "no 0x", in: "5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", want: checksummed, ok: true},
+		{name: "surrounding space", in: " " + checksummed + "\n", want: checksummed, ok: true},
+	} {
+		got, ok := normalizeAddress(tc.in)
+		if ok != tc.ok || got != tc.want {
+			t.Errorf("%s: normalizeAddress(%q) = %q, %v; want %q, %v", tc.name, tc.in, got, ok, tc.want, tc.ok)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	bytespkg "bytes"
+	contextpkg "context"
+	hmacpkg "crypto/hmac"
+	sha256pkg "crypto/sha256"
+	hexpkg "encoding/hex"
+	fmtpkg "fmt"
+	iopkg "io"
+	nethttppkg "net/http"
+	syncpkg "sync"
+	timepkg "time"
+)
+
+// WebhookSink POSTs messages to a tenant's HTTP endpoint (SINK=webhook), for
+// tenants without Kafka. A body that still fails after the retries is
+// logged and counted in poller_webhook_failures_total but not returned as
+// an error: the endpoint is not ours, and it being down must not stall the
+// chain.
+type WebhookSink struct {
+	url    string
+	secret []byte
+	client *nethttppkg.Client
+	retry  backoff
+
+	// batchSize > 1 collects that many messages into one POST of a JSON
+	// array, flushed early every batchInterval
+	batchSize     int
+	batchInterval timepkg.Duration
+	mu            syncpkg.Mutex
+	batch         [][]byte
+	stop          chan struct{}
+	done          chan struct{}
+}
+
+func newWebhookSink(url, secret string, timeout timepkg.Duration, retry backoff, batchSize int, batchInterval timepkg.Duration) *WebhookSink {
+	s := &WebhookSink{
+		url:           url,
+		secret:        []byte(secret),
+		client:        &nethttppkg.Client{Timeout: timeout},
+		retry:         retry,
+		batchSize:     batchSize,
+		batchInterval: batchInterval,
+	}
+	if s.batchSize > 1 {
+		s.stop, s.done = make(chan struct{}), make(chan struct{})
+		go s.flushLoop()
+	}
+	return s
+}
+
+func (s *WebhookSink) Emit(ctx contextpkg.Context, _ string, value []byte) error {
+	if s.batchSize <= 1 {
+		s.post(ctx, value, 1)
+		return nil
+	}
+	s.mu.Lock()
+	s.batch = append(s.batch, value)
+	var full [][]byte
+	if len(s.batch) >= s.batchSize {
+		full, s.batch = s.batch, nil
+	}
+	s.mu.Unlock()
+	if full != nil {
+		s.post(ctx, joinJSONArray(full), len(full))
+	}
+	return nil
+}
+
+func (s *WebhookSink) flushLoop() {
+	defer close(s.done)
+	ticker := timepkg.NewTicker(s.batchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.stop:
+			s.flush()
+			return
+		}
+		s.flush()
+	}
+}
+
+func (s *WebhookSink) flush() {
+	s.mu.Lock()
+	pending := s.batch
+	s.batch = nil
+	s.mu.Unlock()
+	if len(pending) > 0 {
+		s.post(contextpkg.Background(), joinJSONArray(pending), len(pending))
+	}
+}
+
+// Close sends whatever is still batched.
+func (s *WebhookSink) Close() error {
+	if s.stop != nil {
+		close(s.stop)
+		<-s.done
+	}
+	return nil
+}
+
+// post sends body, retrying network errors, 5xx and 429 responses. Any
+// other status is final.
+func (s *WebhookSink) post(ctx contextpkg.Context, body []byte, events int) {
+	var err error
+	for attempt := 0; attempt < s.retry.attempts; attempt++ {
+		var retryable bool
+		if retryable, err = s.postOnce(ctx, body); err == nil {
+			webhookSent.Add(float64(events))
+			return
+		}
+		if !retryable || attempt == s.retry.attempts-1 {
+			break
+		}
+		if !sleepCtx(ctx, s.retry.delay(attempt)) {
+			err = ctx.Err()
+			break
+		}
+	}
+	webhookFailures.Add(float64(events))
+	logFor("webhook").Error("webhook delivery failed, events dropped", "events", events, "err", err)
+}
+
+func (s *WebhookSink) postOnce(ctx contextpkg.Context, body []byte) (bool, error) {
+	req, err := nethttppkg.NewRequestWithContext(ctx, "POST", s.url, bytespkg.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(s.secret) > 0 {
+		mac := hmacpkg.New(sha256pkg.New, s.secret)
+		mac.Write(body)
+		req.Header.Set("X-Signature", "sha256="+hexpkg.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	_, _ = iopkg.Copy(iopkg.Discard, iopkg.LimitReader(resp.Body, 1<<16))
+	resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return false, nil
+	}
+	retryable := resp.StatusCode >= 500 || resp.StatusCode == nethttppkg.StatusTooManyRequests
+	return retryable, fmtpkg.Errorf("webhook: status %d", resp.StatusCode)
+}
+
+func joinJSONArray(values [][]byte) []byte {
+	out := append([]byte{'['}, bytespkg.Join(values, []byte{','})...)
+	return append(out, ']')
+}
@@ -0,0 +1,12 @@
+// Package gaseventpb holds the protobuf GasEvent the poller sends with
+// OUTPUT_FORMAT=protobuf.
+package gaseventpb
+
+import _ "embed"
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative gas_event.proto
+
+// Schema is gas_event.proto, as registered with a schema registry.
+//
+//go:embed gas_event.proto
+var Schema string
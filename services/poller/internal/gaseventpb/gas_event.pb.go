@@ -0,0 +1,609 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.3
+// 	protoc        (unknown)
+// source: gas_event.proto
+
+// GasEvent is the protobuf form of the poller's gas event
+// (OUTPUT_FORMAT=protobuf). Field names follow the JSON event. Amounts of
+// wei are decimal strings, exact where the JSON carries gwei and ETH as
+// floats; those float fields are left out.
+
+package gaseventpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type GasEvent struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	TenantId string                 `protobuf:"bytes,1,opt,name=tenant_id,json=tenantId,proto3" json:"tenant_id,omitempty"`
+	ChainId  uint64                 `protobuf:"varint,2,opt,name=chain_id,json=chainId,proto3" json:"chain_id,omitempty"`
+	// a known chain's name, or NETWORK_NAME
+	NetworkName     *string `protobuf:"bytes,37,opt,name=network_name,json=networkName,proto3,oneof" json:"network_name,omitempty"`
+	Contract        string  `protobuf:"bytes,3,opt,name=contract,proto3" json:"contract,omitempty"`
+	TxHash          string  `protobuf:"bytes,4,opt,name=tx_hash,json=txHash,proto3" json:"tx_hash,omitempty"`
+	EventId         string  `protobuf:"bytes,5,opt,name=event_id,json=eventId,proto3" json:"event_id,omitempty"`
+	BlockNumber     uint64  `protobuf:"varint,6,opt,name=block_number,json=blockNumber,proto3" json:"block_number,omitempty"`
+	Timestamp       uint64  `protobuf:"varint,7,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	From            string  `protobuf:"bytes,8,opt,name=from,proto3" json:"from,omitempty"`
+	To              string  `protobuf:"bytes,9,opt,name=to,proto3" json:"to,omitempty"`
+	MethodSignature string  `protobuf:"bytes,10,opt,name=method_signature,json=methodSignature,proto3" json:"method_signature,omitempty"`
+	MethodName      string  `protobuf:"bytes,11,opt,name=method_name,json=methodName,proto3" json:"method_name,omitempty"`
+	Source          string  `protobuf:"bytes,12,opt,name=source,proto3" json:"source,omitempty"`
+	MatchReason     string  `protobuf:"bytes,13,opt,name=match_reason,json=matchReason,proto3" json:"match_reason,omitempty"`
+	// set when the receipt could not be fetched; the receipt fields below
+	// are then unset, but for status, which is "unknown"
+	ReceiptMissing       bool    `protobuf:"varint,14,opt,name=receipt_missing,json=receiptMissing,proto3" json:"receipt_missing,omitempty"`
+	Status               *string `protobuf:"bytes,15,opt,name=status,proto3,oneof" json:"status,omitempty"`
+	RevertReason         *string `protobuf:"bytes,16,opt,name=revert_reason,json=revertReason,proto3,oneof" json:"revert_reason,omitempty"`
+	GasUsed              *uint64 `protobuf:"varint,17,opt,name=gas_used,json=gasUsed,proto3,oneof" json:"gas_used,omitempty"`
+	LogsCount            *uint32 `protobuf:"varint,38,opt,name=logs_count,json=logsCount,proto3,oneof" json:"logs_count,omitempty"`
+	EffectiveGasPriceWei *string `protobuf:"bytes,18,opt,name=effective_gas_price_wei,json=effectiveGasPriceWei,proto3,oneof" json:"effective_gas_price_wei,omitempty"`
+	BaseFeeWei           *string `protobuf:"bytes,19,opt,name=base_fee_wei,json=baseFeeWei,proto3,oneof" json:"base_fee_wei,omitempty"`
+	PriorityFeeWei       *string `protobuf:"bytes,20,opt,name=priority_fee_wei,json=priorityFeeWei,proto3,oneof" json:"priority_fee_wei,omitempty"`
+	FeeModel             *string `protobuf:"bytes,21,opt,name=fee_model,json=feeModel,proto3,oneof" json:"fee_model,omitempty"`
+	// EIP-4844 blob gas, set from Cancun on
+	BlobGasUsed    *uint64 `protobuf:"varint,22,opt,name=blob_gas_used,json=blobGasUsed,proto3,oneof" json:"blob_gas_used,omitempty"`
+	BlobBaseFeeWei *string `protobuf:"bytes,23,opt,name=blob_base_fee_wei,json=blobBaseFeeWei,proto3,oneof" json:"blob_base_fee_wei,omitempty"`
+	BlobCostWei    *string `protobuf:"bytes,24,opt,name=blob_cost_wei,json=blobCostWei,proto3,oneof" json:"blob_cost_wei,omitempty"`
+	// what the tx paid: execution gas, plus blob gas with INCLUDE_BLOB_COST
+	CostWei         *string     `protobuf:"bytes,25,opt,name=cost_wei,json=costWei,proto3,oneof" json:"cost_wei,omitempty"`
+	RevertedCostWei *string     `protobuf:"bytes,26,opt,name=reverted_cost_wei,json=revertedCostWei,proto3,oneof" json:"reverted_cost_wei,omitempty"`
+	EthUsdPrice     *float64    `protobuf:"fixed64,27,opt,name=eth_usd_price,json=ethUsdPrice,proto3,oneof" json:"eth_usd_price,omitempty"`
+	CostUsd         *float64    `protobuf:"fixed64,28,opt,name=cost_usd,json=costUsd,proto3,oneof" json:"cost_usd,omitempty"`
+	Transfers       []*Transfer `protobuf:"bytes,29,rep,name=transfers,proto3" json:"transfers,omitempty"`
+	// set on internal-call matches (TRACE_ENABLED)
+	CallType      *string `protobuf:"bytes,30,opt,name=call_type,json=callType,proto3,oneof" json:"call_type,omitempty"`
+	Depth         *uint32 `protobuf:"varint,31,opt,name=depth,proto3,oneof" json:"depth,omitempty"`
+	CallGasUsed   *uint64 `protobuf:"varint,32,opt,name=call_gas_used,json=callGasUsed,proto3,oneof" json:"call_gas_used,omitempty"`
+	CallCount     *uint32 `protobuf:"varint,33,opt,name=call_count,json=callCount,proto3,oneof" json:"call_count,omitempty"`
+	CallReverted  bool    `protobuf:"varint,34,opt,name=call_reverted,json=callReverted,proto3" json:"call_reverted,omitempty"`
+	Reorged       bool    `protobuf:"varint,35,opt,name=reorged,proto3" json:"reorged,omitempty"`
+	Replaces      *string `protobuf:"bytes,36,opt,name=replaces,proto3,oneof" json:"replaces,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GasEvent) Reset() {
+	*x = GasEvent{}
+	mi := &file_gas_event_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GasEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GasEvent) ProtoMessage() {}
+
+func (x *GasEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_gas_event_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GasEvent.ProtoReflect.Descriptor instead.
+func (*GasEvent) Descriptor() ([]byte, []int) {
+	return file_gas_event_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *GasEvent) GetTenantId() string {
+	if x != nil {
+		return x.TenantId
+	}
+	return ""
+}
+
+func (x *GasEvent) GetChainId() uint64 {
+	if x != nil {
+		return x.ChainId
+	}
+	return 0
+}
+
+func (x *GasEvent) GetNetworkName() string {
+	if x != nil && x.NetworkName != nil {
+		return *x.NetworkName
+	}
+	return ""
+}
+
+func (x *GasEvent) GetContract() string {
+	if x != nil {
+		return x.Contract
+	}
+	return ""
+}
+
+func (x *GasEvent) GetTxHash() string {
+	if x != nil {
+		return x.TxHash
+	}
+	return ""
+}
+
+func (x *GasEvent) GetEventId() string {
+	if x != nil {
+		return x.EventId
+	}
+	return ""
+}
+
+func (x *GasEvent) GetBlockNumber() uint64 {
+	if x != nil {
+		return x.BlockNumber
+	}
+	return 0
+}
+
+func (x *GasEvent) GetTimestamp() uint64 {
+	if x != nil {
+		return x.Timestamp
+	}
+	return 0
+}
+
+func (x *GasEvent) GetFrom() string {
+	if x != nil {
+		return x.From
+	}
+	return ""
+}
+
+func (x *GasEvent) GetTo() string {
+	if x != nil {
+		return x.To
+	}
+	return ""
+}
+
+func (x *GasEvent) GetMethodSignature() string {
+	if x != nil {
+		return x.MethodSignature
+	}
+	return ""
+}
+
+func (x *GasEvent) GetMethodName() string {
+	if x != nil {
+		return x.MethodName
+	}
+	return ""
+}
+
+func (x *GasEvent) GetSource() string {
+	if x != nil {
+		return x.Source
+	}
+	return ""
+}
+
+func (x *GasEvent) GetMatchReason() string {
+	if x != nil {
+		return x.MatchReason
+	}
+	return ""
+}
+
+func (x *GasEvent) GetReceiptMissing() bool {
+	if x != nil {
+		return x.ReceiptMissing
+	}
+	return false
+}
+
+func (x *GasEvent) GetStatus() string {
+	if x != nil && x.Status != nil {
+		return *x.Status
+	}
+	return ""
+}
+
+func (x *GasEvent) GetRevertReason() string {
+	if x != nil && x.RevertReason != nil {
+		return *x.RevertReason
+	}
+	return ""
+}
+
+func (x *GasEvent) GetGasUsed() uint64 {
+	if x != nil && x.GasUsed != nil {
+		return *x.GasUsed
+	}
+	return 0
+}
+
+func (x *GasEvent) GetLogsCount() uint32 {
+	if x != nil && x.LogsCount != nil {
+		return *x.LogsCount
+	}
+	return 0
+}
+
+func (x *GasEvent) GetEffectiveGasPriceWei() string {
+	if x != nil && x.EffectiveGasPriceWei != nil {
+		return *x.EffectiveGasPriceWei
+	}
+	return ""
+}
+
+func (x *GasEvent) GetBaseFeeWei() string {
+	if x != nil && x.BaseFeeWei != nil {
+		return *x.BaseFeeWei
+	}
+	return ""
+}
+
+func (x *GasEvent) GetPriorityFeeWei() string {
+	if x != nil && x.PriorityFeeWei != nil {
+		return *x.PriorityFeeWei
+	}
+	return ""
+}
+
+func (x *GasEvent) GetFeeModel() string {
+	if x != nil && x.FeeModel != nil {
+		return *x.FeeModel
+	}
+	return ""
+}
+
+func (x *GasEvent) GetBlobGasUsed() uint64 {
+	if x != nil && x.BlobGasUsed != nil {
+		return *x.BlobGasUsed
+	}
+	return 0
+}
+
+func (x *GasEvent) GetBlobBaseFeeWei() string {
+	if x != nil && x.BlobBaseFeeWei != nil {
+		return *x.BlobBaseFeeWei
+	}
+	return ""
+}
+
+func (x *GasEvent) GetBlobCostWei() string {
+	if x != nil && x.BlobCostWei != nil {
+		return *x.BlobCostWei
+	}
+	return ""
+}
+
+func (x *GasEvent) GetCostWei() string {
+	if x != nil && x.CostWei != nil {
+		return *x.CostWei
+	}
+	return ""
+}
+
+func (x *GasEvent) GetRevertedCostWei() string {
+	if x != nil && x.RevertedCostWei != nil {
+		return *x.RevertedCostWei
+	}
+	return ""
+}
+
+func (x *GasEvent) GetEthUsdPrice() float64 {
+	if x != nil && x.EthUsdPrice != nil {
+		return *x.EthUsdPrice
+	}
+	return 0
+}
+
+func (x *GasEvent) GetCostUsd() float64 {
+	if x != nil && x.CostUsd != nil {
+		return *x.CostUsd
+	}
+	return 0
+}
+
+func (x *GasEvent) GetTransfers() []*Transfer {
+	if x != nil {
+		return x.Transfers
+	}
+	return nil
+}
+
+func (x *GasEvent) GetCallType() string {
+	if x != nil && x.CallType != nil {
+		return *x.CallType
+	}
+	return ""
+}
+
+func (x *GasEvent) GetDepth() uint32 {
+	if x != nil && x.Depth != nil {
+		return *x.Depth
+	}
+	return 0
+}
+
+func (x *GasEvent) GetCallGasUsed() uint64 {
+	if x != nil && x.CallGasUsed != nil {
+		return *x.CallGasUsed
+	}
+	return 0
+}
+
+func (x *GasEvent) GetCallCount() uint32 {
+	if x != nil && x.CallCount != nil {
+		return *x.CallCount
+	}
+	return 0
+}
+
+func (x *GasEvent) GetCallReverted() bool {
+	if x != nil {
+		return x.CallReverted
+	}
+	return false
+}
+
+func (x *GasEvent) GetReorged() bool {
+	if x != nil {
+		return x.Reorged
+	}
+	return false
+}
+
+func (x *GasEvent) GetReplaces() string {
+	if x != nil && x.Replaces != nil {
+		return *x.Replaces
+	}
+	return ""
+}
+
+// Transfer is an ERC-20 Transfer event the watched contract emitted.
+type Transfer struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	From  string                 `protobuf:"bytes,1,opt,name=from,proto3" json:"from,omitempty"`
+	To    string                 `protobuf:"bytes,2,opt,name=to,proto3" json:"to,omitempty"`
+	// token amount in base units
+	Value         string `protobuf:"bytes,3,opt,name=value,proto3" json:"value,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Transfer) Reset() {
+	*x = Transfer{}
+	mi := &file_gas_event_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Transfer) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Transfer) ProtoMessage() {}
+
+func (x *Transfer) ProtoReflect() protoreflect.Message {
+	mi := &file_gas_event_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Transfer.ProtoReflect.Descriptor instead.
+func (*Transfer) Descriptor() ([]byte, []int) {
+	return file_gas_event_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Transfer) GetFrom() string {
+	if x != nil {
+		return x.From
+	}
+	return ""
+}
+
+func (x *Transfer) GetTo() string {
+	if x != nil {
+		return x.To
+	}
+	return ""
+}
+
+func (x *Transfer) GetValue() string {
+	if x != nil {
+		return x.Value
+	}
+	return ""
+}
+
+var File_gas_event_proto protoreflect.FileDescriptor
+
+var file_gas_event_proto_rawDesc = []byte{
+	0x0a, 0x0f, 0x67, 0x61, 0x73, 0x5f, 0x65, 0x76, 0x65, 0x6e, 0x74, 0x2e, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x12, 0x0d, 0x67, 0x61, 0x73, 0x6d, 0x6f, 0x6e, 0x69, 0x74, 0x6f, 0x72, 0x2e, 0x76, 0x31,
+	0x22, 0xa7, 0x0d, 0x0a, 0x08, 0x47, 0x61, 0x73, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x12, 0x1b, 0x0a,
+	0x09, 0x74, 0x65, 0x6e, 0x61, 0x6e, 0x74, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x08, 0x74, 0x65, 0x6e, 0x61, 0x6e, 0x74, 0x49, 0x64, 0x12, 0x19, 0x0a, 0x08, 0x63, 0x68,
+	0x61, 0x69, 0x6e, 0x5f, 0x69, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x04, 0x52, 0x07, 0x63, 0x68,
+	0x61, 0x69, 0x6e, 0x49, 0x64, 0x12, 0x26, 0x0a, 0x0c, 0x6e, 0x65, 0x74, 0x77, 0x6f, 0x72, 0x6b,
+	0x5f, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x25, 0x20, 0x01, 0x28, 0x09, 0x48, 0x00, 0x52, 0x0b, 0x6e,
+	0x65, 0x74, 0x77, 0x6f, 0x72, 0x6b, 0x4e, 0x61, 0x6d, 0x65, 0x88, 0x01, 0x01, 0x12, 0x1a, 0x0a,
+	0x08, 0x63, 0x6f, 0x6e, 0x74, 0x72, 0x61, 0x63, 0x74, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x08, 0x63, 0x6f, 0x6e, 0x74, 0x72, 0x61, 0x63, 0x74, 0x12, 0x17, 0x0a, 0x07, 0x74, 0x78, 0x5f,
+	0x68, 0x61, 0x73, 0x68, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x74, 0x78, 0x48, 0x61,
+	0x73, 0x68, 0x12, 0x19, 0x0a, 0x08, 0x65, 0x76, 0x65, 0x6e, 0x74, 0x5f, 0x69, 0x64, 0x18, 0x05,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x65, 0x76, 0x65, 0x6e, 0x74, 0x49, 0x64, 0x12, 0x21, 0x0a,
+	0x0c, 0x62, 0x6c, 0x6f, 0x63, 0x6b, 0x5f, 0x6e, 0x75, 0x6d, 0x62, 0x65, 0x72, 0x18, 0x06, 0x20,
+	0x01, 0x28, 0x04, 0x52, 0x0b, 0x62, 0x6c, 0x6f, 0x63, 0x6b, 0x4e, 0x75, 0x6d, 0x62, 0x65, 0x72,
+	0x12, 0x1c, 0x0a, 0x09, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x18, 0x07, 0x20,
+	0x01, 0x28, 0x04, 0x52, 0x09, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x12, 0x12,
+	0x0a, 0x04, 0x66, 0x72, 0x6f, 0x6d, 0x18, 0x08, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x66, 0x72,
+	0x6f, 0x6d, 0x12, 0x0e, 0x0a, 0x02, 0x74, 0x6f, 0x18, 0x09, 0x20, 0x01, 0x28, 0x09, 0x52, 0x02,
+	0x74, 0x6f, 0x12, 0x29, 0x0a, 0x10, 0x6d, 0x65, 0x74, 0x68, 0x6f, 0x64, 0x5f, 0x73, 0x69, 0x67,
+	0x6e, 0x61, 0x74, 0x75, 0x72, 0x65, 0x18, 0x0a, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0f, 0x6d, 0x65,
+	0x74, 0x68, 0x6f, 0x64, 0x53, 0x69, 0x67, 0x6e, 0x61, 0x74, 0x75, 0x72, 0x65, 0x12, 0x1f, 0x0a,
+	0x0b, 0x6d, 0x65, 0x74, 0x68, 0x6f, 0x64, 0x5f, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x0b, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x0a, 0x6d, 0x65, 0x74, 0x68, 0x6f, 0x64, 0x4e, 0x61, 0x6d, 0x65, 0x12, 0x16,
+	0x0a, 0x06, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x18, 0x0c, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06,
+	0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x12, 0x21, 0x0a, 0x0c, 0x6d, 0x61, 0x74, 0x63, 0x68, 0x5f,
+	0x72, 0x65, 0x61, 0x73, 0x6f, 0x6e, 0x18, 0x0d, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x6d, 0x61,
+	0x74, 0x63, 0x68, 0x52, 0x65, 0x61, 0x73, 0x6f, 0x6e, 0x12, 0x27, 0x0a, 0x0f, 0x72, 0x65, 0x63,
+	0x65, 0x69, 0x70, 0x74, 0x5f, 0x6d, 0x69, 0x73, 0x73, 0x69, 0x6e, 0x67, 0x18, 0x0e, 0x20, 0x01,
+	0x28, 0x08, 0x52, 0x0e, 0x72, 0x65, 0x63, 0x65, 0x69, 0x70, 0x74, 0x4d, 0x69, 0x73, 0x73, 0x69,
+	0x6e, 0x67, 0x12, 0x1b, 0x0a, 0x06, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x18, 0x0f, 0x20, 0x01,
+	0x28, 0x09, 0x48, 0x01, 0x52, 0x06, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x88, 0x01, 0x01, 0x12,
+	0x28, 0x0a, 0x0d, 0x72, 0x65, 0x76, 0x65, 0x72, 0x74, 0x5f, 0x72, 0x65, 0x61, 0x73, 0x6f, 0x6e,
+	0x18, 0x10, 0x20, 0x01, 0x28, 0x09, 0x48, 0x02, 0x52, 0x0c, 0x72, 0x65, 0x76, 0x65, 0x72, 0x74,
+	0x52, 0x65, 0x61, 0x73, 0x6f, 0x6e, 0x88, 0x01, 0x01, 0x12, 0x1e, 0x0a, 0x08, 0x67, 0x61, 0x73,
+	0x5f, 0x75, 0x73, 0x65, 0x64, 0x18, 0x11, 0x20, 0x01, 0x28, 0x04, 0x48, 0x03, 0x52, 0x07, 0x67,
+	0x61, 0x73, 0x55, 0x73, 0x65, 0x64, 0x88, 0x01, 0x01, 0x12, 0x22, 0x0a, 0x0a, 0x6c, 0x6f, 0x67,
+	0x73, 0x5f, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x18, 0x26, 0x20, 0x01, 0x28, 0x0d, 0x48, 0x04, 0x52,
+	0x09, 0x6c, 0x6f, 0x67, 0x73, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x88, 0x01, 0x01, 0x12, 0x3a, 0x0a,
+	0x17, 0x65, 0x66, 0x66, 0x65, 0x63, 0x74, 0x69, 0x76, 0x65, 0x5f, 0x67, 0x61, 0x73, 0x5f, 0x70,
+	0x72, 0x69, 0x63, 0x65, 0x5f, 0x77, 0x65, 0x69, 0x18, 0x12, 0x20, 0x01, 0x28, 0x09, 0x48, 0x05,
+	0x52, 0x14, 0x65, 0x66, 0x66, 0x65, 0x63, 0x74, 0x69, 0x76, 0x65, 0x47, 0x61, 0x73, 0x50, 0x72,
+	0x69, 0x63, 0x65, 0x57, 0x65, 0x69, 0x88, 0x01, 0x01, 0x12, 0x25, 0x0a, 0x0c, 0x62, 0x61, 0x73,
+	0x65, 0x5f, 0x66, 0x65, 0x65, 0x5f, 0x77, 0x65, 0x69, 0x18, 0x13, 0x20, 0x01, 0x28, 0x09, 0x48,
+	0x06, 0x52, 0x0a, 0x62, 0x61, 0x73, 0x65, 0x46, 0x65, 0x65, 0x57, 0x65, 0x69, 0x88, 0x01, 0x01,
+	0x12, 0x2d, 0x0a, 0x10, 0x70, 0x72, 0x69, 0x6f, 0x72, 0x69, 0x74, 0x79, 0x5f, 0x66, 0x65, 0x65,
+	0x5f, 0x77, 0x65, 0x69, 0x18, 0x14, 0x20, 0x01, 0x28, 0x09, 0x48, 0x07, 0x52, 0x0e, 0x70, 0x72,
+	0x69, 0x6f, 0x72, 0x69, 0x74, 0x79, 0x46, 0x65, 0x65, 0x57, 0x65, 0x69, 0x88, 0x01, 0x01, 0x12,
+	0x20, 0x0a, 0x09, 0x66, 0x65, 0x65, 0x5f, 0x6d, 0x6f, 0x64, 0x65, 0x6c, 0x18, 0x15, 0x20, 0x01,
+	0x28, 0x09, 0x48, 0x08, 0x52, 0x08, 0x66, 0x65, 0x65, 0x4d, 0x6f, 0x64, 0x65, 0x6c, 0x88, 0x01,
+	0x01, 0x12, 0x27, 0x0a, 0x0d, 0x62, 0x6c, 0x6f, 0x62, 0x5f, 0x67, 0x61, 0x73, 0x5f, 0x75, 0x73,
+	0x65, 0x64, 0x18, 0x16, 0x20, 0x01, 0x28, 0x04, 0x48, 0x09, 0x52, 0x0b, 0x62, 0x6c, 0x6f, 0x62,
+	0x47, 0x61, 0x73, 0x55, 0x73, 0x65, 0x64, 0x88, 0x01, 0x01, 0x12, 0x2e, 0x0a, 0x11, 0x62, 0x6c,
+	0x6f, 0x62, 0x5f, 0x62, 0x61, 0x73, 0x65, 0x5f, 0x66, 0x65, 0x65, 0x5f, 0x77, 0x65, 0x69, 0x18,
+	0x17, 0x20, 0x01, 0x28, 0x09, 0x48, 0x0a, 0x52, 0x0e, 0x62, 0x6c, 0x6f, 0x62, 0x42, 0x61, 0x73,
+	0x65, 0x46, 0x65, 0x65, 0x57, 0x65, 0x69, 0x88, 0x01, 0x01, 0x12, 0x27, 0x0a, 0x0d, 0x62, 0x6c,
+	0x6f, 0x62, 0x5f, 0x63, 0x6f, 0x73, 0x74, 0x5f, 0x77, 0x65, 0x69, 0x18, 0x18, 0x20, 0x01, 0x28,
+	0x09, 0x48, 0x0b, 0x52, 0x0b, 0x62, 0x6c, 0x6f, 0x62, 0x43, 0x6f, 0x73, 0x74, 0x57, 0x65, 0x69,
+	0x88, 0x01, 0x01, 0x12, 0x1e, 0x0a, 0x08, 0x63, 0x6f, 0x73, 0x74, 0x5f, 0x77, 0x65, 0x69, 0x18,
+	0x19, 0x20, 0x01, 0x28, 0x09, 0x48, 0x0c, 0x52, 0x07, 0x63, 0x6f, 0x73, 0x74, 0x57, 0x65, 0x69,
+	0x88, 0x01, 0x01, 0x12, 0x2f, 0x0a, 0x11, 0x72, 0x65, 0x76, 0x65, 0x72, 0x74, 0x65, 0x64, 0x5f,
+	0x63, 0x6f, 0x73, 0x74, 0x5f, 0x77, 0x65, 0x69, 0x18, 0x1a, 0x20, 0x01, 0x28, 0x09, 0x48, 0x0d,
+	0x52, 0x0f, 0x72, 0x65, 0x76, 0x65, 0x72, 0x74, 0x65, 0x64, 0x43, 0x6f, 0x73, 0x74, 0x57, 0x65,
+	0x69, 0x88, 0x01, 0x01, 0x12, 0x27, 0x0a, 0x0d, 0x65, 0x74, 0x68, 0x5f, 0x75, 0x73, 0x64, 0x5f,
+	0x70, 0x72, 0x69, 0x63, 0x65, 0x18, 0x1b, 0x20, 0x01, 0x28, 0x01, 0x48, 0x0e, 0x52, 0x0b, 0x65,
+	0x74, 0x68, 0x55, 0x73, 0x64, 0x50, 0x72, 0x69, 0x63, 0x65, 0x88, 0x01, 0x01, 0x12, 0x1e, 0x0a,
+	0x08, 0x63, 0x6f, 0x73, 0x74, 0x5f, 0x75, 0x73, 0x64, 0x18, 0x1c, 0x20, 0x01, 0x28, 0x01, 0x48,
+	0x0f, 0x52, 0x07, 0x63, 0x6f, 0x73, 0x74, 0x55, 0x73, 0x64, 0x88, 0x01, 0x01, 0x12, 0x35, 0x0a,
+	0x09, 0x74, 0x72, 0x61, 0x6e, 0x73, 0x66, 0x65, 0x72, 0x73, 0x18, 0x1d, 0x20, 0x03, 0x28, 0x0b,
+	0x32, 0x17, 0x2e, 0x67, 0x61, 0x73, 0x6d, 0x6f, 0x6e, 0x69, 0x74, 0x6f, 0x72, 0x2e, 0x76, 0x31,
+	0x2e, 0x54, 0x72, 0x61, 0x6e, 0x73, 0x66, 0x65, 0x72, 0x52, 0x09, 0x74, 0x72, 0x61, 0x6e, 0x73,
+	0x66, 0x65, 0x72, 0x73, 0x12, 0x20, 0x0a, 0x09, 0x63, 0x61, 0x6c, 0x6c, 0x5f, 0x74, 0x79, 0x70,
+	0x65, 0x18, 0x1e, 0x20, 0x01, 0x28, 0x09, 0x48, 0x10, 0x52, 0x08, 0x63, 0x61, 0x6c, 0x6c, 0x54,
+	0x79, 0x70, 0x65, 0x88, 0x01, 0x01, 0x12, 0x19, 0x0a, 0x05, 0x64, 0x65, 0x70, 0x74, 0x68, 0x18,
+	0x1f, 0x20, 0x01, 0x28, 0x0d, 0x48, 0x11, 0x52, 0x05, 0x64, 0x65, 0x70, 0x74, 0x68, 0x88, 0x01,
+	0x01, 0x12, 0x27, 0x0a, 0x0d, 0x63, 0x61, 0x6c, 0x6c, 0x5f, 0x67, 0x61, 0x73, 0x5f, 0x75, 0x73,
+	0x65, 0x64, 0x18, 0x20, 0x20, 0x01, 0x28, 0x04, 0x48, 0x12, 0x52, 0x0b, 0x63, 0x61, 0x6c, 0x6c,
+	0x47, 0x61, 0x73, 0x55, 0x73, 0x65, 0x64, 0x88, 0x01, 0x01, 0x12, 0x22, 0x0a, 0x0a, 0x63, 0x61,
+	0x6c, 0x6c, 0x5f, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x18, 0x21, 0x20, 0x01, 0x28, 0x0d, 0x48, 0x13,
+	0x52, 0x09, 0x63, 0x61, 0x6c, 0x6c, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x88, 0x01, 0x01, 0x12, 0x23,
+	0x0a, 0x0d, 0x63, 0x61, 0x6c, 0x6c, 0x5f, 0x72, 0x65, 0x76, 0x65, 0x72, 0x74, 0x65, 0x64, 0x18,
+	0x22, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0c, 0x63, 0x61, 0x6c, 0x6c, 0x52, 0x65, 0x76, 0x65, 0x72,
+	0x74, 0x65, 0x64, 0x12, 0x18, 0x0a, 0x07, 0x72, 0x65, 0x6f, 0x72, 0x67, 0x65, 0x64, 0x18, 0x23,
+	0x20, 0x01, 0x28, 0x08, 0x52, 0x07, 0x72, 0x65, 0x6f, 0x72, 0x67, 0x65, 0x64, 0x12, 0x1f, 0x0a,
+	0x08, 0x72, 0x65, 0x70, 0x6c, 0x61, 0x63, 0x65, 0x73, 0x18, 0x24, 0x20, 0x01, 0x28, 0x09, 0x48,
+	0x14, 0x52, 0x08, 0x72, 0x65, 0x70, 0x6c, 0x61, 0x63, 0x65, 0x73, 0x88, 0x01, 0x01, 0x42, 0x0f,
+	0x0a, 0x0d, 0x5f, 0x6e, 0x65, 0x74, 0x77, 0x6f, 0x72, 0x6b, 0x5f, 0x6e, 0x61, 0x6d, 0x65, 0x42,
+	0x09, 0x0a, 0x07, 0x5f, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x42, 0x10, 0x0a, 0x0e, 0x5f, 0x72,
+	0x65, 0x76, 0x65, 0x72, 0x74, 0x5f, 0x72, 0x65, 0x61, 0x73, 0x6f, 0x6e, 0x42, 0x0b, 0x0a, 0x09,
+	0x5f, 0x67, 0x61, 0x73, 0x5f, 0x75, 0x73, 0x65, 0x64, 0x42, 0x0d, 0x0a, 0x0b, 0x5f, 0x6c, 0x6f,
+	0x67, 0x73, 0x5f, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x42, 0x1a, 0x0a, 0x18, 0x5f, 0x65, 0x66, 0x66,
+	0x65, 0x63, 0x74, 0x69, 0x76, 0x65, 0x5f, 0x67, 0x61, 0x73, 0x5f, 0x70, 0x72, 0x69, 0x63, 0x65,
+	0x5f, 0x77, 0x65, 0x69, 0x42, 0x0f, 0x0a, 0x0d, 0x5f, 0x62, 0x61, 0x73, 0x65, 0x5f, 0x66, 0x65,
+	0x65, 0x5f, 0x77, 0x65, 0x69, 0x42, 0x13, 0x0a, 0x11, 0x5f, 0x70, 0x72, 0x69, 0x6f, 0x72, 0x69,
+	0x74, 0x79, 0x5f, 0x66, 0x65, 0x65, 0x5f, 0x77, 0x65, 0x69, 0x42, 0x0c, 0x0a, 0x0a, 0x5f, 0x66,
+	0x65, 0x65, 0x5f, 0x6d, 0x6f, 0x64, 0x65, 0x6c, 0x42, 0x10, 0x0a, 0x0e, 0x5f, 0x62, 0x6c, 0x6f,
+	0x62, 0x5f, 0x67, 0x61, 0x73, 0x5f, 0x75, 0x73, 0x65, 0x64, 0x42, 0x14, 0x0a, 0x12, 0x5f, 0x62,
+	0x6c, 0x6f, 0x62, 0x5f, 0x62, 0x61, 0x73, 0x65, 0x5f, 0x66, 0x65, 0x65, 0x5f, 0x77, 0x65, 0x69,
+	0x42, 0x10, 0x0a, 0x0e, 0x5f, 0x62, 0x6c, 0x6f, 0x62, 0x5f, 0x63, 0x6f, 0x73, 0x74, 0x5f, 0x77,
+	0x65, 0x69, 0x42, 0x0b, 0x0a, 0x09, 0x5f, 0x63, 0x6f, 0x73, 0x74, 0x5f, 0x77, 0x65, 0x69, 0x42,
+	0x14, 0x0a, 0x12, 0x5f, 0x72, 0x65, 0x76, 0x65, 0x72, 0x74, 0x65, 0x64, 0x5f, 0x63, 0x6f, 0x73,
+	0x74, 0x5f, 0x77, 0x65, 0x69, 0x42, 0x10, 0x0a, 0x0e, 0x5f, 0x65, 0x74, 0x68, 0x5f, 0x75, 0x73,
+	0x64, 0x5f, 0x70, 0x72, 0x69, 0x63, 0x65, 0x42, 0x0b, 0x0a, 0x09, 0x5f, 0x63, 0x6f, 0x73, 0x74,
+	0x5f, 0x75, 0x73, 0x64, 0x42, 0x0c, 0x0a, 0x0a, 0x5f, 0x63, 0x61, 0x6c, 0x6c, 0x5f, 0x74, 0x79,
+	0x70, 0x65, 0x42, 0x08, 0x0a, 0x06, 0x5f, 0x64, 0x65, 0x70, 0x74, 0x68, 0x42, 0x10, 0x0a, 0x0e,
+	0x5f, 0x63, 0x61, 0x6c, 0x6c, 0x5f, 0x67, 0x61, 0x73, 0x5f, 0x75, 0x73, 0x65, 0x64, 0x42, 0x0d,
+	0x0a, 0x0b, 0x5f, 0x63, 0x61, 0x6c, 0x6c, 0x5f, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x42, 0x0b, 0x0a,
+	0x09, 0x5f, 0x72, 0x65, 0x70, 0x6c, 0x61, 0x63, 0x65, 0x73, 0x22, 0x44, 0x0a, 0x08, 0x54, 0x72,
+	0x61, 0x6e, 0x73, 0x66, 0x65, 0x72, 0x12, 0x12, 0x0a, 0x04, 0x66, 0x72, 0x6f, 0x6d, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x66, 0x72, 0x6f, 0x6d, 0x12, 0x0e, 0x0a, 0x02, 0x74, 0x6f,
+	0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x02, 0x74, 0x6f, 0x12, 0x14, 0x0a, 0x05, 0x76, 0x61,
+	0x6c, 0x75, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65,
+	0x42, 0x3b, 0x5a, 0x39, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x65,
+	0x78, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x2f, 0x67, 0x61, 0x73, 0x2d, 0x6d, 0x6f, 0x6e, 0x69, 0x74,
+	0x6f, 0x72, 0x2d, 0x70, 0x6f, 0x6c, 0x6c, 0x65, 0x72, 0x2f, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x6e,
+	0x61, 0x6c, 0x2f, 0x67, 0x61, 0x73, 0x65, 0x76, 0x65, 0x6e, 0x74, 0x70, 0x62, 0x62, 0x06, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_gas_event_proto_rawDescOnce sync.Once
+	file_gas_event_proto_rawDescData = file_gas_event_proto_rawDesc
+)
+
+func file_gas_event_proto_rawDescGZIP() []byte {
+	file_gas_event_proto_rawDescOnce.Do(func() {
+		file_gas_event_proto_rawDescData = protoimpl.X.CompressGZIP(file_gas_event_proto_rawDescData)
+	})
+	return file_gas_event_proto_rawDescData
+}
+
+var file_gas_event_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_gas_event_proto_goTypes = []any{
+	(*GasEvent)(nil), // 0: gasmonitor.v1.GasEvent
+	(*Transfer)(nil), // 1: gasmonitor.v1.Transfer
+}
+var file_gas_event_proto_depIdxs = []int32{
+	1, // 0: gasmonitor.v1.GasEvent.transfers:type_name -> gasmonitor.v1.Transfer
+	1, // [1:1] is the sub-list for method output_type
+	1, // [1:1] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
+}
+
+func init() { file_gas_event_proto_init() }
+func file_gas_event_proto_init() {
+	if File_gas_event_proto != nil {
+		return
+	}
+	file_gas_event_proto_msgTypes[0].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_gas_event_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   2,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_gas_event_proto_goTypes,
+		DependencyIndexes: file_gas_event_proto_depIdxs,
+		MessageInfos:      file_gas_event_proto_msgTypes,
+	}.Build()
+	File_gas_event_proto = out.File
+	file_gas_event_proto_rawDesc = nil
+	file_gas_event_proto_goTypes = nil
+	file_gas_event_proto_depIdxs = nil
+}
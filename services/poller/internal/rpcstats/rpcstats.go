@@ -0,0 +1,139 @@
+// Package rpcstats records how many RPC calls the poller makes, how many
+// fail and how long they take, per JSON-RPC method.
+package rpcstats
+
+import (
+	contextpkg "context"
+	fmtpkg "fmt"
+	slogpkg "log/slog"
+	sortpkg "sort"
+	stringspkg "strings"
+	syncpkg "sync"
+	timepkg "time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Recorder is told about every RPC call once it returns.
+type Recorder interface {
+	Observe(method string, took timepkg.Duration, err error)
+}
+
+// Nop discards every observation.
+type Nop struct{}
+
+func (Nop) Observe(string, timepkg.Duration, error) {}
+
+// maxSamples bounds the latencies kept per summary window; calls beyond it
+// are still counted but do not move the p95.
+const maxSamples = 10000
+
+// Stats exports per-method Prometheus metrics and keeps a rolling window
+// for the periodic summary log line.
+type Stats struct {
+	calls   *prometheus.CounterVec
+	errors  *prometheus.CounterVec
+	latency *prometheus.HistogramVec
+
+	mu      syncpkg.Mutex
+	counts  map[string]int
+	failed  int
+	samples []timepkg.Duration
+}
+
+// New registers the metrics with reg, named <namespace>_rpc_*.
+func New(reg prometheus.Registerer, namespace string) *Stats {
+	s := &Stats{
+		calls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "rpc_requests_total",
+			Help:      "RPC calls made, by method",
+		}, []string{"method"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "rpc_request_errors_total",
+			Help:      "RPC calls that returned an error, by method",
+		}, []string{"method"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "rpc_request_duration_seconds",
+			Help:      "RPC call latency, by method",
+			Buckets:   prometheus.ExponentialBuckets(0.005, 2, 12),
+		}, []string{"method"}),
+		counts: make(map[string]int),
+	}
+	reg.MustRegister(s.calls, s.errors, s.latency)
+	return s
+}
+
+func (s *Stats) Observe(method string, took timepkg.Duration, err error) {
+	s.calls.WithLabelValues(method).Inc()
+	s.latency.WithLabelValues(method).Observe(took.Seconds())
+	if err != nil {
+		s.errors.WithLabelValues(method).Inc()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counts[method]++
+	if err != nil {
+		s.failed++
+	}
+	if len(s.samples) < maxSamples {
+		s.samples = append(s.samples, took)
+	}
+}
+
+// Window is what happened since the previous summary.
+type Window struct {
+	Counts map[string]int
+	Errors int
+	P95    timepkg.Duration
+}
+
+// String reads like "40 eth_getBlockByNumber, 120 eth_getTransactionReceipt,
+// p95 180ms, 3 errors".
+func (w Window) String() string {
+	methods := make([]string, 0, len(w.Counts))
+	for m := range w.Counts {
+		methods = append(methods, m)
+	}
+	sortpkg.Strings(methods)
+	parts := make([]string, 0, len(methods)+2)
+	for _, m := range methods {
+		parts = append(parts, fmtpkg.Sprintf("%d %s", w.Counts[m], m))
+	}
+	if len(parts) == 0 {
+		parts = append(parts, "no calls")
+	}
+	parts = append(parts, fmtpkg.Sprintf("p95 %s", w.P95.Round(timepkg.Millisecond)), fmtpkg.Sprintf("%d errors", w.Errors))
+	return stringspkg.Join(parts, ", ")
+}
+
+// Reset returns the current window and starts a new one.
+func (s *Stats) Reset() Window {
+	s.mu.Lock()
+	w := Window{Counts: s.counts, Errors: s.failed}
+	samples := s.samples
+	s.counts, s.failed, s.samples = make(map[string]int), 0, nil
+	s.mu.Unlock()
+	if len(samples) > 0 {
+		sortpkg.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+		w.P95 = samples[(len(samples)*95-1)/100]
+	}
+	return w
+}
+
+// LogEvery logs a summary of each interval to log until ctx is cancelled.
+func (s *Stats) LogEvery(ctx contextpkg.Context, interval timepkg.Duration, log *slogpkg.Logger) {
+	t := timepkg.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			w := s.Reset()
+			log.Info(fmtpkg.Sprintf("last %s: %s", interval, w), "errors", w.Errors, "p95", w.P95.String())
+		}
+	}
+}